@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Duarte64/go-web-meli/internal/audit"
+	"github.com/Duarte64/go-web-meli/pkg/web"
+	"github.com/gin-gonic/gin"
+)
+
+type Audit struct {
+	log *audit.Log
+}
+
+func NewAudit(l *audit.Log) *Audit {
+	return &Audit{
+		log: l,
+	}
+}
+
+// ListAudit godoc
+// @Summary List audit entries
+// @Tags Audit
+// @Description list the changes made through the API, newest first
+// @Produce  json
+// @Param token header string true "token"
+// @Param actor query string false "who made the change"
+// @Param operation query string false "create, update, patch, delete, restore, revert or password"
+// @Param entity query string false "kind of entity changed, e.g. user"
+// @Param entity_id query int false "ID of the entity changed"
+// @Param from query string false "changed at or after (RFC3339 or YYYY-MM-DD)"
+// @Param to query string false "changed at or before (RFC3339 or YYYY-MM-DD)"
+// @Param limit query int false "page size"
+// @Param offset query int false "entries to skip"
+// @Success 200 {object} web.Response{data=[]audit.Entry,meta=web.Meta}
+// @Failure 400 {object} web.Response
+// @Router /audit [get]
+func (c *Audit) List() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		f, err := parseAuditFilter(ctx)
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, err.Error()))
+			return
+		}
+		entityID, err := queryInt(ctx, "entity_id")
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, err.Error()))
+			return
+		}
+		if entityID != nil {
+			f.EntityID = uint(max(*entityID, 0))
+		}
+		f.Entity = ctx.Query("entity")
+
+		page, err := c.log.List(f)
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, web.NewResponse(http.StatusInternalServerError, nil, err.Error()))
+			return
+		}
+
+		ctx.JSON(http.StatusOK, web.NewPageResponse(http.StatusOK, page.Entries, web.Meta{Total: page.Total, Offset: page.Offset}))
+	}
+}
+
+// parseAuditFilter reads the filters shared by the audit list and the
+// history of a user.
+func parseAuditFilter(ctx *gin.Context) (audit.Filter, error) {
+	f := audit.Filter{
+		Actor:     ctx.Query("actor"),
+		Operation: ctx.Query("operation"),
+	}
+	var err error
+	if f.From, err = queryTime(ctx, "from", false); err != nil {
+		return f, err
+	}
+	if f.To, err = queryTime(ctx, "to", true); err != nil {
+		return f, err
+	}
+	limit, err := queryInt(ctx, "limit")
+	if err != nil {
+		return f, err
+	}
+	if limit != nil {
+		if *limit < 1 {
+			return f, fmt.Errorf("limit inválido")
+		}
+		f.Limit = *limit
+	}
+	offset, err := queryInt(ctx, "offset")
+	if err != nil {
+		return f, err
+	}
+	if offset != nil {
+		if *offset < 0 {
+			return f, fmt.Errorf("offset inválido")
+		}
+		f.Offset = *offset
+	}
+	return f, nil
+}
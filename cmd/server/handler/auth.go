@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Duarte64/go-web-meli/cmd/server/middleware/guards"
+	"github.com/Duarte64/go-web-meli/internal/auth"
+	"github.com/Duarte64/go-web-meli/internal/users"
+	"github.com/Duarte64/go-web-meli/pkg/web"
+	"github.com/gin-gonic/gin"
+)
+
+// Auth failures are tracked by lockout, per client IP and per client ID
+// or email.
+type Auth struct {
+	issuer  *auth.Issuer
+	service users.Service
+	lockout *auth.Lockout
+}
+
+func NewAuth(i *auth.Issuer, u users.Service, l *auth.Lockout) *Auth {
+	return &Auth{
+		issuer:  i,
+		service: u,
+		lockout: l,
+	}
+}
+
+type TokenRequestDto struct {
+	GrantType    string `json:"grant_type" form:"grant_type"`
+	ClientID     string `json:"client_id" form:"client_id"`
+	ClientSecret string `json:"client_secret" form:"client_secret"`
+	Scope        string `json:"scope" form:"scope"`
+}
+
+// IssueToken godoc
+// @Summary Issue token
+// @Tags Auth
+// @Description issue a JWT to a configured client, with the client credentials grant. The client may authenticate with HTTP Basic instead of client_id and client_secret
+// @Accept  json,x-www-form-urlencoded
+// @Produce  json
+// @Param request body TokenRequestDto true "client credentials"
+// @Success 200 {object} web.Response{data=auth.Token}
+// @Failure 400 {object} web.Response
+// @Failure 401 {object} web.Response
+// @Failure 423 {object} web.Response
+// @Failure 429 {object} web.Response
+// @Router /auth/token [post]
+func (c *Auth) Token() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var req TokenRequestDto
+		if err := ctx.ShouldBind(&req); err != nil {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, err.Error()))
+			return
+		}
+		if req.GrantType != "client_credentials" {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, "grant_type deve ser client_credentials"))
+			return
+		}
+		if id, secret, ok := ctx.Request.BasicAuth(); ok {
+			req.ClientID, req.ClientSecret = id, secret
+		}
+		keys := []string{auth.IPKey(ctx.ClientIP()), auth.PrincipalKey(req.ClientID)}
+		if guards.AbortIfBlocked(ctx, c.lockout, keys...) {
+			return
+		}
+
+		token, err := c.issuer.Issue(req.ClientID, req.ClientSecret, req.Scope)
+		if err != nil {
+			switch {
+			case errors.Is(err, auth.ErrInvalidClient):
+				c.lockout.Fail(keys...)
+				ctx.AbortWithStatusJSON(http.StatusUnauthorized, web.NewResponse(http.StatusUnauthorized, nil, "Cliente inválido"))
+			case errors.Is(err, auth.ErrInvalidScope):
+				ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, "Escopo inválido"))
+			default:
+				ctx.AbortWithStatusJSON(http.StatusInternalServerError, web.NewResponse(http.StatusInternalServerError, nil, err.Error()))
+			}
+			return
+		}
+
+		c.lockout.Succeed(keys[1])
+
+		ctx.Header("Cache-Control", "no-store")
+		ctx.JSON(http.StatusOK, web.NewResponse(http.StatusOK, token, ""))
+	}
+}
+
+type LoginDto struct {
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type ResetPasswordDto struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// Login godoc
+// @Summary Login
+// @Tags Auth
+// @Description issue a JWT to an active user with its email and password, the token can only read and patch the user itself
+// @Accept  json
+// @Produce  json
+// @Param credentials body LoginDto true "email and password"
+// @Success 200 {object} web.Response{data=auth.Token}
+// @Failure 400 {object} web.Response
+// @Failure 401 {object} web.Response
+// @Failure 423 {object} web.Response
+// @Failure 429 {object} web.Response
+// @Router /auth/login [post]
+func (c *Auth) Login() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var req LoginDto
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, err.Error()))
+			return
+		}
+
+		keys := []string{auth.IPKey(ctx.ClientIP()), auth.PrincipalKey(req.Email)}
+		if guards.AbortIfBlocked(ctx, c.lockout, keys...) {
+			return
+		}
+
+		u, err := c.service.Authenticate(req.Email, req.Password)
+		if err != nil {
+			if errors.Is(err, users.ErrInvalidCredentials) {
+				c.lockout.Fail(keys...)
+				ctx.AbortWithStatusJSON(http.StatusUnauthorized, web.NewResponse(http.StatusUnauthorized, nil, "Email ou senha inválidos"))
+				return
+			}
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, web.NewResponse(http.StatusInternalServerError, nil, err.Error()))
+			return
+		}
+		c.lockout.Succeed(keys[1])
+		token, err := c.issuer.IssueUser(u.ID)
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, web.NewResponse(http.StatusInternalServerError, nil, err.Error()))
+			return
+		}
+
+		ctx.Header("Cache-Control", "no-store")
+		ctx.JSON(http.StatusOK, web.NewResponse(http.StatusOK, token, ""))
+	}
+}
+
+// ResetPassword godoc
+// @Summary Reset password
+// @Tags Auth
+// @Description set the password of a user with a reset token, which can only be used once
+// @Accept  json
+// @Produce  json
+// @Param reset body ResetPasswordDto true "reset token and new password"
+// @Success 204
+// @Failure 400 {object} web.Response
+// @Failure 422 {object} web.Response{details=[]users.FieldError}
+// @Failure 429 {object} web.Response
+// @Router /auth/reset-password [post]
+func (c *Auth) ResetPassword() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var req ResetPasswordDto
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, err.Error()))
+			return
+		}
+
+		ip := auth.IPKey(ctx.ClientIP())
+		if guards.AbortIfBlocked(ctx, c.lockout, ip) {
+			return
+		}
+
+		if _, err := c.service.ResetPassword(req.Token, req.Password); err != nil {
+			if abortOnValidation(ctx, err) {
+				return
+			}
+			if errors.Is(err, users.ErrInvalidResetToken) {
+				c.lockout.Fail(ip)
+				ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, err.Error()))
+				return
+			}
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, web.NewResponse(http.StatusInternalServerError, nil, err.Error()))
+			return
+		}
+
+		ctx.Status(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,167 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Duarte64/go-web-meli/cmd/server/middleware/guards"
+	"github.com/Duarte64/go-web-meli/internal/auth"
+	"github.com/Duarte64/go-web-meli/internal/rbac"
+	"github.com/Duarte64/go-web-meli/internal/users"
+	"github.com/Duarte64/go-web-meli/pkg/jwt"
+	"github.com/Duarte64/go-web-meli/pkg/store"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_IssueToken(t *testing.T) {
+	key, _ := jwt.NewHMACKey([]byte(strings.Repeat("k", jwt.MinSecretLength)))
+	cfg := auth.Config{Issuer: "meli", Audience: "users-api", TTL: time.Minute, ClockSkew: time.Second}
+	issuer := auth.NewIssuer(key, cfg, []auth.Client{{ID: "reports", SecretHash: auth.HashSecret("s3cret")}})
+
+	r := gin.Default()
+	r.POST("/auth/token", NewAuth(issuer, nil, auth.NewLockout(auth.LockoutConfig{})).Token())
+	r.GET("/me", guards.JWTAuthMiddleware(key, cfg.Validation()), func(ctx *gin.Context) {
+		ctx.String(http.StatusOK, guards.Actor(ctx)+" "+guards.Claims(ctx).Issuer)
+	})
+
+	token := func(form url.Values, user, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/auth/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if user != "" {
+			req.SetBasicAuth(user, password)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+	me := func(authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		req.Header.Set("Authorization", authorization)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := token(url.Values{"grant_type": {"client_credentials"}, "client_id": {"reports"}, "client_secret": {"s3cret"}}, "", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response struct {
+		Data auth.Token `json:"data"`
+	}
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, "Bearer", response.Data.TokenType)
+
+	rr = me("Bearer " + response.Data.AccessToken)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "reports meli", rr.Body.String())
+
+	assert.Equal(t, http.StatusOK, token(url.Values{"grant_type": {"client_credentials"}}, "reports", "s3cret").Code)
+	assert.Equal(t, http.StatusUnauthorized, token(url.Values{"grant_type": {"client_credentials"}}, "reports", "wrong").Code)
+	assert.Equal(t, http.StatusBadRequest, token(url.Values{"grant_type": {"password"}}, "reports", "s3cret").Code)
+
+	assert.Equal(t, http.StatusUnauthorized, me(response.Data.AccessToken).Code)
+	assert.Equal(t, http.StatusUnauthorized, me("Bearer "+response.Data.AccessToken+"x").Code)
+	expired, _ := key.Sign(jwt.Claims{Subject: "reports", Issuer: "meli", Audience: jwt.Audience{"users-api"}, ExpiresAt: time.Now().Add(-time.Minute).Unix()})
+	rr = me("Bearer " + expired)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Equal(t, `Bearer error="invalid_token"`, rr.Header().Get("WWW-Authenticate"))
+}
+
+func Test_LoginAndPasswords(t *testing.T) {
+	key, _ := jwt.NewHMACKey([]byte(strings.Repeat("k", jwt.MinSecretLength)))
+	cfg := auth.Config{TTL: time.Minute}
+	service := users.NewService(users.NewRepository(store.NewMemory("", 0)))
+	a := NewAuth(auth.NewIssuer(key, cfg, nil), service, auth.NewLockout(auth.LockoutConfig{}))
+	u := NewUserWithPolicy(service, rbac.DefaultPolicy())
+
+	r := gin.Default()
+	r.POST("/auth/login", a.Login())
+	r.POST("/auth/reset-password", a.ResetPassword())
+	admin := r.Group("/admin", func(ctx *gin.Context) { ctx.Set(guards.RolesKey, []string{rbac.RoleAdmin}) })
+	admin.PUT("/users/:id/password", u.SetPassword())
+	admin.POST("/users/:id/password-reset", u.PasswordReset())
+	self := r.Group("/users", guards.JWTAuthMiddleware(key, cfg.Validation()))
+	self.GET("/:id", u.GetById())
+	self.PUT("/:id/password", u.SetPassword())
+
+	_, err := service.Store("Gabriel", "Duarte", "gabriel@meli.com", 30, 1.8, true)
+	assert.NoError(t, err)
+	request := func(method, url, body, token string) *httptest.ResponseRecorder {
+		req, rr := createRequestTest(method, url, body)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+	login := func(password string) (string, int) {
+		rr := request(http.MethodPost, "/auth/login", `{"email": "gabriel@meli.com", "password": "`+password+`"}`, "")
+		var response struct {
+			Data auth.Token `json:"data"`
+		}
+		_ = json.Unmarshal(rr.Body.Bytes(), &response)
+		return response.Data.AccessToken, rr.Code
+	}
+
+	_, code := login("tr0ub4dor&3x")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Equal(t, http.StatusUnprocessableEntity, request(http.MethodPut, "/admin/users/1/password", `{"password": "weak"}`, "").Code)
+	assert.Equal(t, http.StatusNoContent, request(http.MethodPut, "/admin/users/1/password", `{"password": "tr0ub4dor&3x"}`, "").Code)
+
+	token, code := login("tr0ub4dor&3x")
+	assert.Equal(t, http.StatusOK, code)
+	rr := request(http.MethodGet, "/users/1", "", token)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotContains(t, rr.Body.String(), "password")
+	assert.NotContains(t, rr.Body.String(), "hash")
+
+	// Users must know their current password to change it.
+	assert.Equal(t, http.StatusForbidden, request(http.MethodPut, "/users/1/password", `{"password": "n3w passphrase"}`, token).Code)
+	assert.Equal(t, http.StatusNoContent, request(http.MethodPut, "/users/1/password", `{"current_password": "tr0ub4dor&3x", "password": "n3w passphrase"}`, token).Code)
+	_, code = login("n3w passphrase")
+	assert.Equal(t, http.StatusOK, code)
+
+	rr = request(http.MethodPost, "/admin/users/1/password-reset", "", "")
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	var reset struct {
+		Data PasswordResetDto `json:"data"`
+	}
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &reset))
+	body := `{"token": "` + reset.Data.Token + `", "password": "r3set passphrase"}`
+	assert.Equal(t, http.StatusNoContent, request(http.MethodPost, "/auth/reset-password", body, "").Code)
+	assert.Equal(t, http.StatusBadRequest, request(http.MethodPost, "/auth/reset-password", body, "").Code)
+	_, code = login("r3set passphrase")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, http.StatusNotFound, request(http.MethodPost, "/admin/users/9/password-reset", "", "").Code)
+}
+
+func Test_TokenDefaultRole(t *testing.T) {
+	key, _ := jwt.NewHMACKey([]byte(strings.Repeat("k", jwt.MinSecretLength)))
+	cfg := auth.Config{TTL: time.Minute}
+	// Clients configured before roles existed have none.
+	issuer := auth.NewIssuer(key, cfg, []auth.Client{{ID: "legacy", SecretHash: auth.HashSecret("s3cret")}})
+	token, err := issuer.Issue("legacy", "s3cret", "")
+	assert.NoError(t, err)
+
+	u := NewUserWithPolicy(users.NewService(users.NewRepository(store.NewMemory("", 0))), rbac.DefaultPolicy())
+	r := gin.Default()
+	withRole := r.Group("/users", guards.JWTAuthMiddlewareWithRole(key, cfg.Validation(), rbac.RoleViewer))
+	withRole.GET("", u.GetAll())
+	withRole.POST("", u.Store())
+	r.GET("/roleless", guards.JWTAuthMiddleware(key, cfg.Validation()), u.GetAll())
+
+	request := func(method, url, body string) int {
+		req, rr := createRequestTest(method, url, body)
+		req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+		r.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	assert.Equal(t, http.StatusNoContent, request(http.MethodGet, "/users", ""))
+	assert.Equal(t, http.StatusForbidden, request(http.MethodPost, "/users", `{"name": "teste","lastname": "teste","age": 30,"height": 1.8,"email": "teste@test.com", "active": true}`))
+	assert.Equal(t, http.StatusForbidden, request(http.MethodGet, "/roleless", ""))
+}
@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Duarte64/go-web-meli/pkg/store/backup"
+	"github.com/Duarte64/go-web-meli/pkg/web"
+	"github.com/gin-gonic/gin"
+)
+
+type Backup struct {
+	manager *backup.Manager
+}
+
+func NewBackup(m *backup.Manager) *Backup {
+	return &Backup{
+		manager: m,
+	}
+}
+
+// ListBackups godoc
+// @Summary List backups
+// @Tags Admin
+// @Description list the snapshots of the store
+// @Produce  json
+// @Param token header string true "token"
+// @Success 200 {object} web.Response{data=[]backup.Snapshot}
+// @Router /admin/backups [get]
+func (c *Backup) List() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		snapshots, err := c.manager.List()
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, web.NewResponse(http.StatusInternalServerError, nil, err.Error()))
+			return
+		}
+
+		ctx.JSON(http.StatusOK, web.NewResponse(http.StatusOK, snapshots, ""))
+	}
+}
+
+// CreateBackup godoc
+// @Summary Create backup
+// @Tags Admin
+// @Description take a snapshot of the store
+// @Produce  json
+// @Param token header string true "token"
+// @Success 201 {object} web.Response{data=backup.Snapshot}
+// @Router /admin/backups [post]
+func (c *Backup) Create() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		snapshot, err := c.manager.Create()
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, web.NewResponse(http.StatusInternalServerError, nil, err.Error()))
+			return
+		}
+
+		ctx.JSON(http.StatusCreated, web.NewResponse(http.StatusCreated, snapshot, ""))
+	}
+}
+
+// RestoreBackup godoc
+// @Summary Restore backup
+// @Tags Admin
+// @Description replace the data of the store with a snapshot
+// @Produce  json
+// @Param token header string true "token"
+// @Param name path string true "snapshot name"
+// @Success 204
+// @Router /admin/backups/{name}/restore [post]
+func (c *Backup) Restore() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if err := c.manager.Restore(ctx.Param("name")); err != nil {
+			if errors.Is(err, backup.ErrNotFound) {
+				ctx.AbortWithStatusJSON(http.StatusNotFound, web.NewResponse(http.StatusNotFound, nil, "Backup não encontrado"))
+				return
+			}
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, web.NewResponse(http.StatusInternalServerError, nil, err.Error()))
+			return
+		}
+
+		ctx.Status(http.StatusNoContent)
+	}
+}
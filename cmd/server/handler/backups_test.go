@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/Duarte64/go-web-meli/internal/users"
+	"github.com/Duarte64/go-web-meli/pkg/store"
+	"github.com/Duarte64/go-web-meli/pkg/store/backup"
+	"github.com/Duarte64/go-web-meli/pkg/web"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createBackupServer(t *testing.T) (*gin.Engine, store.Store) {
+	db := store.NewMemory("", 0)
+	require.NoError(t, db.Write([]users.User{{ID: 1, Name: "teste"}}))
+	manager, err := users.NewBackupManager(db, backup.Config{Dir: t.TempDir()})
+	require.NoError(t, err)
+
+	b := NewBackup(manager)
+	r := gin.Default()
+	r.GET("/admin/backups", b.List())
+	r.POST("/admin/backups", b.Create())
+	r.POST("/admin/backups/:name/restore", b.Restore())
+	return r, db
+}
+
+func Test_RestoreBackup_OK(t *testing.T) {
+	r, db := createBackupServer(t)
+
+	req, rr := createRequestTest(http.MethodPost, "/admin/backups", "")
+	r.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusCreated, rr.Code)
+
+	var response struct {
+		web.Response
+		Data backup.Snapshot `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+
+	require.NoError(t, db.Write([]users.User{}))
+	req, rr = createRequestTest(http.MethodPost, "/admin/backups/"+response.Data.Name+"/restore", "")
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+
+	var us []users.User
+	require.NoError(t, db.Read(&us))
+	assert.Len(t, us, 1)
+}
+
+func Test_RestoreBackup_NotFound(t *testing.T) {
+	r, _ := createBackupServer(t)
+
+	req, rr := createRequestTest(http.MethodPost, "/admin/backups/users-missing.json/restore", "")
+	r.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
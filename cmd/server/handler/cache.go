@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/Duarte64/go-web-meli/internal/users"
+	"github.com/Duarte64/go-web-meli/pkg/web"
+	"github.com/gin-gonic/gin"
+)
+
+type Cache struct {
+	repository *users.CachedRepository
+}
+
+func NewCache(r *users.CachedRepository) *Cache {
+	return &Cache{
+		repository: r,
+	}
+}
+
+// CacheStats godoc
+// @Summary Cache stats
+// @Tags Admin
+// @Description hit and miss counters of the users cache
+// @Produce  json
+// @Param token header string true "token"
+// @Success 200 {object} web.Response{data=users.CacheStats}
+// @Router /admin/cache [get]
+func (c *Cache) Stats() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, web.NewResponse(http.StatusOK, c.repository.Stats(), ""))
+	}
+}
@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Duarte64/go-web-meli/cmd/server/middleware/guards"
+	"github.com/Duarte64/go-web-meli/internal/auth"
+	"github.com/Duarte64/go-web-meli/pkg/web"
+	"github.com/gin-gonic/gin"
+)
+
+type APIKey struct {
+	keys *auth.Keys
+}
+
+func NewAPIKey(k *auth.Keys) *APIKey {
+	return &APIKey{
+		keys: k,
+	}
+}
+
+type APIKeyModelDto struct {
+	Name   string   `json:"name" binding:"required"`
+	Owner  string   `json:"owner"`
+	Scopes []string `json:"scopes" binding:"required"`
+	// ExpiresAt is RFC3339, the key never expires without it.
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// APIKeySecret is a key along with its value, only shown once.
+type APIKeySecret struct {
+	auth.APIKey
+	Key string `json:"key"`
+}
+
+// ListAPIKeys godoc
+// @Summary List API keys
+// @Tags Admin
+// @Description list the API keys, including revoked and expired ones, without their values
+// @Produce  json
+// @Param token header string true "token"
+// @Success 200 {object} web.Response{data=[]auth.APIKey}
+// @Router /admin/keys [get]
+func (c *APIKey) List() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		keys, err := c.keys.List()
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, web.NewResponse(http.StatusInternalServerError, nil, err.Error()))
+			return
+		}
+
+		ctx.JSON(http.StatusOK, web.NewResponse(http.StatusOK, keys, ""))
+	}
+}
+
+// CreateAPIKey godoc
+// @Summary Create API key
+// @Tags Admin
+// @Description create an API key, its value is only returned here. The owner defaults to the caller
+// @Accept  json
+// @Produce  json
+// @Param token header string true "token"
+// @Param key body APIKeyModelDto true "key to create"
+// @Success 201 {object} web.Response{data=APIKeySecret}
+// @Failure 400 {object} web.Response
+// @Router /admin/keys [post]
+func (c *APIKey) Create() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var keyDto APIKeyModelDto
+		if err := ctx.ShouldBindJSON(&keyDto); err != nil {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, err.Error()))
+			return
+		}
+		if keyDto.Owner == "" {
+			keyDto.Owner = guards.Actor(ctx)
+		}
+
+		key, secret, err := c.keys.Create(keyDto.Name, keyDto.Owner, keyDto.Scopes, keyDto.ExpiresAt)
+		if err != nil {
+			abortOnKeyError(ctx, err)
+			return
+		}
+
+		ctx.JSON(http.StatusCreated, web.NewResponse(http.StatusCreated, APIKeySecret{APIKey: key, Key: secret}, ""))
+	}
+}
+
+// RevokeAPIKey godoc
+// @Summary Revoke API key
+// @Tags Admin
+// @Description disable an API key at once
+// @Produce  json
+// @Param token header string true "token"
+// @Param id path int true "key id"
+// @Success 200 {object} web.Response{data=auth.APIKey}
+// @Failure 404 {object} web.Response
+// @Router /admin/keys/{id} [delete]
+func (c *APIKey) Revoke() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id, err := strconv.Atoi(ctx.Param("id"))
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, "ID inválido"))
+			return
+		}
+
+		key, err := c.keys.Revoke(uint(id))
+		if err != nil {
+			abortOnKeyError(ctx, err)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, web.NewResponse(http.StatusOK, key, ""))
+	}
+}
+
+// RotateAPIKey godoc
+// @Summary Rotate API key
+// @Tags Admin
+// @Description replace an API key with a new one with the same name, owner, scopes and expiry. The old key keeps working for the grace period
+// @Produce  json
+// @Param token header string true "token"
+// @Param id path int true "key id"
+// @Param grace query string false "how long the old key keeps working, e.g. 24h"
+// @Success 201 {object} web.Response{data=APIKeySecret}
+// @Failure 400 {object} web.Response
+// @Failure 404 {object} web.Response
+// @Router /admin/keys/{id}/rotate [post]
+func (c *APIKey) Rotate() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id, err := strconv.Atoi(ctx.Param("id"))
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, "ID inválido"))
+			return
+		}
+		var grace time.Duration
+		if value, ok := ctx.GetQuery("grace"); ok {
+			if grace, err = time.ParseDuration(value); err != nil || grace < 0 {
+				ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, "grace inválido"))
+				return
+			}
+		}
+
+		key, secret, err := c.keys.Rotate(uint(id), grace)
+		if err != nil {
+			abortOnKeyError(ctx, err)
+			return
+		}
+
+		ctx.JSON(http.StatusCreated, web.NewResponse(http.StatusCreated, APIKeySecret{APIKey: key, Key: secret}, ""))
+	}
+}
+
+func abortOnKeyError(ctx *gin.Context, err error) {
+	var requestErr *auth.InvalidKeyRequestError
+	switch {
+	case errors.As(err, &requestErr):
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, err.Error()))
+	case errors.Is(err, auth.ErrKeyNotFound):
+		ctx.AbortWithStatusJSON(http.StatusNotFound, web.NewResponse(http.StatusNotFound, nil, "Chave de API não encontrada"))
+	default:
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, web.NewResponse(http.StatusInternalServerError, nil, err.Error()))
+	}
+}
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Duarte64/go-web-meli/cmd/server/middleware/guards"
+	"github.com/Duarte64/go-web-meli/internal/auth"
+	"github.com/Duarte64/go-web-meli/internal/rbac"
+	"github.com/Duarte64/go-web-meli/pkg/jwt"
+	"github.com/Duarte64/go-web-meli/pkg/store"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_APIKeys(t *testing.T) {
+	keys := auth.NewKeys(store.NewMemory("", 0))
+	k := NewAPIKey(keys)
+	r := gin.Default()
+	admin := r.Group("/admin", func(ctx *gin.Context) { ctx.Set(guards.ActorKey, "tester") })
+	admin.GET("/keys", k.List())
+	admin.POST("/keys", k.Create())
+	admin.DELETE("/keys/:id", k.Revoke())
+	admin.POST("/keys/:id/rotate", k.Rotate())
+	reject := func(ctx *gin.Context) { ctx.AbortWithStatus(http.StatusUnauthorized) }
+	ur := r.Group("/users", guards.APIKeyAuthMiddleware(keys, reject))
+	ur.GET("", guards.RequireScope(auth.ScopeUsersRead), func(ctx *gin.Context) { ctx.String(http.StatusOK, guards.Actor(ctx)) })
+	ur.POST("", guards.RequireScope(auth.ScopeUsersWrite), func(ctx *gin.Context) { ctx.Status(http.StatusCreated) })
+
+	request := func(method, url, key string) *httptest.ResponseRecorder {
+		req, rr := createRequestTest(method, url, "")
+		req.Header.Set(guards.APIKeyHeader, key)
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+	var created struct {
+		Data APIKeySecret `json:"data"`
+	}
+	req, rr := createRequestTest(http.MethodPost, "/admin/keys", `{"name": "reports", "scopes": ["users:read"]}`)
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &created))
+	assert.Equal(t, "tester", created.Data.Owner)
+	assert.NotContains(t, rr.Body.String(), "hash")
+
+	rr = request(http.MethodGet, "/users", created.Data.Key)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "apikey:reports", rr.Body.String())
+	assert.Equal(t, http.StatusForbidden, request(http.MethodPost, "/users", created.Data.Key).Code)
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/users", "gwm_unknown").Code)
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/users", "").Code)
+
+	old := created.Data.Key
+	rr = request(http.MethodPost, "/admin/keys/1/rotate", "")
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &created))
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/users", old).Code)
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "/users", created.Data.Key).Code)
+
+	assert.Equal(t, http.StatusOK, request(http.MethodDelete, "/admin/keys/2", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/users", created.Data.Key).Code)
+	assert.Equal(t, http.StatusNotFound, request(http.MethodDelete, "/admin/keys/9", "").Code)
+
+	req, rr = createRequestTest(http.MethodPost, "/admin/keys", `{"name": "bad", "scopes": ["admin"]}`)
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = request(http.MethodGet, "/admin/keys", "")
+	var list struct {
+		Data []auth.APIKey `json:"data"`
+	}
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &list))
+	assert.Len(t, list.Data, 2)
+	assert.NotEmpty(t, list.Data[0].LastUsedAt)
+}
+
+func Test_APIKeys_RequireAdmin(t *testing.T) {
+	key, _ := jwt.NewHMACKey([]byte(strings.Repeat("k", jwt.MinSecretLength)))
+	cfg := auth.Config{TTL: time.Minute}
+	issuer := auth.NewIssuer(key, cfg, []auth.Client{
+		{ID: "root", SecretHash: auth.HashSecret("s3cret"), Roles: []string{rbac.RoleAdmin}},
+		{ID: "reports", SecretHash: auth.HashSecret("s3cret"), Scopes: []string{auth.ScopeUsersRead}, Roles: []string{rbac.RoleViewer}},
+	})
+	k := NewAPIKey(auth.NewKeys(store.NewMemory("", 0)))
+	r := gin.Default()
+	admin := r.Group("/admin", guards.JWTAuthMiddleware(key, cfg.Validation()), guards.RequireAction(rbac.DefaultPolicy(), rbac.ActionAdmin))
+	admin.POST("/keys", k.Create())
+	admin.DELETE("/keys/:id", k.Revoke())
+	admin.POST("/keys/:id/rotate", k.Rotate())
+
+	request := func(method, url, body, client string) int {
+		token, err := issuer.Issue(client, "s3cret", "")
+		assert.Nil(t, err)
+		req, rr := createRequestTest(method, url, body)
+		req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+		r.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	user, _ := auth.NewIssuer(key, cfg, nil).IssueUser(1)
+	req, rr := createRequestTest(http.MethodPost, "/admin/keys", `{"name": "mine", "scopes": ["users:write"]}`)
+	req.Header.Set("Authorization", "Bearer "+user.AccessToken)
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	body := `{"name": "reports", "scopes": ["users:write"]}`
+	assert.Equal(t, http.StatusForbidden, request(http.MethodPost, "/admin/keys", body, "reports"))
+	assert.Equal(t, http.StatusCreated, request(http.MethodPost, "/admin/keys", body, "root"))
+	assert.Equal(t, http.StatusForbidden, request(http.MethodPost, "/admin/keys/1/rotate", "", "reports"))
+	assert.Equal(t, http.StatusForbidden, request(http.MethodDelete, "/admin/keys/1", "", "reports"))
+	assert.Equal(t, http.StatusOK, request(http.MethodDelete, "/admin/keys/1", "", "root"))
+}
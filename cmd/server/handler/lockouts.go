@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/Duarte64/go-web-meli/internal/auth"
+	"github.com/Duarte64/go-web-meli/pkg/web"
+	"github.com/gin-gonic/gin"
+)
+
+type Lockout struct {
+	lockout *auth.Lockout
+}
+
+func NewLockout(l *auth.Lockout) *Lockout {
+	return &Lockout{
+		lockout: l,
+	}
+}
+
+// ListLockouts godoc
+// @Summary List lockouts
+// @Tags Admin
+// @Description list the client IPs and principals with recent failed authentications, locked out ones first
+// @Produce  json
+// @Param token header string true "token"
+// @Success 200 {object} web.Response{data=[]auth.LockoutEntry}
+// @Router /admin/lockouts [get]
+func (c *Lockout) List() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, web.NewResponse(http.StatusOK, c.lockout.List(), ""))
+	}
+}
+
+// ClearLockout godoc
+// @Summary Clear lockout
+// @Tags Admin
+// @Description forget the failed authentications of a key, e.g. ip:10.0.0.1 or principal:user@example.com
+// @Produce  json
+// @Param token header string true "token"
+// @Param key path string true "lockout key"
+// @Success 204
+// @Failure 404 {object} web.Response
+// @Router /admin/lockouts/{key} [delete]
+func (c *Lockout) Clear() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !c.lockout.Clear(ctx.Param("key")) {
+			ctx.AbortWithStatusJSON(http.StatusNotFound, web.NewResponse(http.StatusNotFound, nil, "Bloqueio não encontrado"))
+			return
+		}
+
+		ctx.Status(http.StatusNoContent)
+	}
+}
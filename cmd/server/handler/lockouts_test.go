@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Duarte64/go-web-meli/cmd/server/middleware/guards"
+	"github.com/Duarte64/go-web-meli/internal/auth"
+	"github.com/Duarte64/go-web-meli/internal/users"
+	"github.com/Duarte64/go-web-meli/pkg/jwt"
+	"github.com/Duarte64/go-web-meli/pkg/store"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Lockouts(t *testing.T) {
+	_ = os.Setenv("TOKEN", "TESTE123")
+	key, _ := jwt.NewHMACKey([]byte(strings.Repeat("k", jwt.MinSecretLength)))
+	service := users.NewService(users.NewRepository(store.NewMemory("", 0)))
+	_, err := service.Store("Gabriel", "Duarte", "gabriel@meli.com", 30, 1.8, true)
+	assert.NoError(t, err)
+	assert.NoError(t, service.SetPassword(1, "tr0ub4dor&3x"))
+	lockout := auth.NewLockout(auth.LockoutConfig{FreeAttempts: 1, BaseDelay: time.Hour, MaxDelay: time.Hour, MaxFailures: 3})
+
+	r := gin.Default()
+	r.POST("/auth/login", NewAuth(auth.NewIssuer(key, auth.Config{TTL: time.Minute}, nil), service, lockout).Login())
+	admin := r.Group("/admin", guards.Protect(lockout, guards.TokenAuthMiddleware()))
+	l := NewLockout(lockout)
+	admin.GET("/lockouts", l.List())
+	admin.DELETE("/lockouts/:key", l.Clear())
+
+	request := func(method, url, body, token, ip string) *httptest.ResponseRecorder {
+		req, rr := createRequestTest(method, url, body)
+		req.Header.Set("Authorization", token)
+		req.RemoteAddr = ip + ":1234"
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+	login := func(password, ip string) *httptest.ResponseRecorder {
+		return request(http.MethodPost, "/auth/login", `{"email": "gabriel@meli.com", "password": "`+password+`"}`, "", ip)
+	}
+
+	// Guessing the token slows the IP down, other IPs are not affected.
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/admin/lockouts", "", "guess", "10.0.0.9").Code)
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/admin/lockouts", "", "guess", "10.0.0.9").Code)
+	rr := request(http.MethodGet, "/admin/lockouts", "", "TESTE123", "10.0.0.9")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "3600", rr.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "/admin/lockouts", "", "TESTE123", "10.0.0.1").Code)
+
+	// Wrong passwords from many IPs lock the account.
+	assert.Equal(t, http.StatusUnauthorized, login("wrong", "10.0.0.2").Code)
+	assert.Equal(t, http.StatusUnauthorized, login("wrong", "10.0.0.3").Code)
+	assert.Equal(t, http.StatusTooManyRequests, login("tr0ub4dor&3x", "10.0.0.4").Code)
+	assert.Equal(t, http.StatusTooManyRequests, login("wrong", "10.0.0.2").Code)
+	lockout.Clear(auth.IPKey("10.0.0.2"))
+	lockout.Clear(auth.IPKey("10.0.0.3"))
+	lockout.Fail(auth.PrincipalKey("gabriel@meli.com"))
+	rr = login("tr0ub4dor&3x", "10.0.0.5")
+	assert.Equal(t, http.StatusLocked, rr.Code)
+	assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+
+	rr = request(http.MethodGet, "/admin/lockouts", "", "TESTE123", "10.0.0.1")
+	var response struct {
+		Data []auth.LockoutEntry `json:"data"`
+	}
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, "principal:gabriel@meli.com", response.Data[0].Key)
+	assert.True(t, response.Data[0].Locked)
+
+	assert.Equal(t, http.StatusNoContent, request(http.MethodDelete, "/admin/lockouts/principal:gabriel@meli.com", "", "TESTE123", "10.0.0.1").Code)
+	assert.Equal(t, http.StatusNotFound, request(http.MethodDelete, "/admin/lockouts/principal:gabriel@meli.com", "", "TESTE123", "10.0.0.1").Code)
+	assert.Equal(t, http.StatusOK, login("tr0ub4dor&3x", "10.0.0.5").Code)
+
+	// A missing header is rejected, and so is every request without a TOKEN.
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/admin/lockouts", "", "", "10.0.0.6").Code)
+	t.Setenv("TOKEN", "")
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/admin/lockouts", "", "", "10.0.0.7").Code)
+}
+
+func Test_Lockouts_UntrustedForwardedFor(t *testing.T) {
+	key, _ := jwt.NewHMACKey([]byte(strings.Repeat("k", jwt.MinSecretLength)))
+	service := users.NewService(users.NewRepository(store.NewMemory("", 0)))
+	lockout := auth.NewLockout(auth.LockoutConfig{})
+
+	r := gin.Default()
+	assert.NoError(t, r.SetTrustedProxies(nil))
+	r.POST("/auth/login", NewAuth(auth.NewIssuer(key, auth.Config{TTL: time.Minute}, nil), service, lockout).Login())
+	for _, forwarded := range []string{"1.1.1.1", "2.2.2.2"} {
+		req, rr := createRequestTest(http.MethodPost, "/auth/login", `{"email": "nobody@meli.com", "password": "wrong"}`)
+		req.RemoteAddr = "10.0.0.9:1234"
+		req.Header.Set("X-Forwarded-For", forwarded)
+		req.Header.Set("X-Real-IP", forwarded)
+		r.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	}
+
+	keys := []string{}
+	for _, e := range lockout.List() {
+		keys = append(keys, e.Key)
+	}
+	assert.ElementsMatch(t, []string{auth.IPKey("10.0.0.9"), auth.PrincipalKey("nobody@meli.com")}, keys)
+	assert.Equal(t, 2, lockout.List()[0].Failures)
+}
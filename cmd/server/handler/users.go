@@ -1,17 +1,28 @@
 package handler
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/Duarte64/go-web-meli/cmd/server/middleware/guards"
+	"github.com/Duarte64/go-web-meli/internal/rbac"
 	"github.com/Duarte64/go-web-meli/internal/users"
+	"github.com/Duarte64/go-web-meli/pkg/jsonpatch"
 	"github.com/Duarte64/go-web-meli/pkg/web"
 	"github.com/gin-gonic/gin"
 )
 
 type User struct {
 	service users.Service
+	policy  *rbac.Policy
 }
 
 type UserModelDto struct {
@@ -20,43 +31,560 @@ type UserModelDto struct {
 	Email    string  `json:"email" binding:"required"`
 	Age      int     `json:"age" binding:"required"`
 	Height   float64 `json:"height" binding:"required"`
-	Active   bool    `json:"active" binding:"required"`
+	// Active is a pointer so that required accepts false.
+	Active *bool `json:"active" binding:"required"`
 }
 
-type UserPatchDto struct {
-	Lastname string `json:"lastname"`
-	Age      int    `json:"age"`
+func NewUser(u users.Service) *User {
+	return &User{
+		service: u,
+	}
 }
 
-func NewUser(u users.Service) *User {
+// NewUserWithPolicy returns a handler that checks every request against
+// the policy.
+func NewUserWithPolicy(u users.Service, p *rbac.Policy) *User {
 	return &User{
 		service: u,
+		policy:  p,
 	}
 }
 
 // ListUsers godoc
 // @Summary List users
 // @Tags Users
-// @Description list users
+// @Description list users, filtered, sorted and paginated by offset or cursor
 // @Accept  json
 // @Produce  json
 // @Param token header string true "token"
-// @Success 200 {object} web.Response{data=[]users.User}
+// @Param name query string false "name prefix"
+// @Param lastname query string false "lastname prefix"
+// @Param email query string false "email"
+// @Param age_min query int false "minimum age"
+// @Param age_max query int false "maximum age"
+// @Param height_min query number false "minimum height"
+// @Param height_max query number false "maximum height"
+// @Param active query bool false "active"
+// @Param created_from query string false "created at or after (RFC3339 or YYYY-MM-DD)"
+// @Param created_to query string false "created at or before (RFC3339 or YYYY-MM-DD)"
+// @Param sort query string false "sort fields, - for descending, e.g. -age,name"
+// @Param limit query int false "page size"
+// @Param offset query int false "users to skip"
+// @Param cursor query string false "cursor from the next or prev link"
+// @Success 200 {object} web.Response{data=[]users.User,meta=web.Meta}
+// @Failure 400 {object} web.Response
+// @Failure 403 {object} web.Response
 // @Router /users [get]
 func (c *User) GetAll() gin.HandlerFunc {
+	return c.list(false)
+}
+
+// ListTrash godoc
+// @Summary List deleted users
+// @Tags Users
+// @Description list the users in the trash, with the same filters, sorting and pagination as the user list
+// @Accept  json
+// @Produce  json
+// @Param token header string true "token"
+// @Param sort query string false "sort fields, - for descending, e.g. -deleted_at"
+// @Param limit query int false "page size"
+// @Param offset query int false "users to skip"
+// @Param cursor query string false "cursor from the next or prev link"
+// @Success 200 {object} web.Response{data=[]users.User,meta=web.Meta}
+// @Failure 400 {object} web.Response
+// @Failure 403 {object} web.Response
+// @Router /users/trash [get]
+func (c *User) Trash() gin.HandlerFunc {
+	return c.list(true)
+}
+
+func (c *User) list(trashed bool) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		u, err := c.service.GetAll()
+		if !c.allow(ctx, rbac.ActionList, 0) {
+			return
+		}
+		q, err := parseUserQuery(ctx)
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, err.Error()))
+			return
+		}
+		q.Trashed = trashed
+
+		page, err := c.service.GetAll(q)
 		if err != nil {
+			var queryErr *users.InvalidQueryError
+			if errors.As(err, &queryErr) {
+				ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, queryErr.Error()))
+				return
+			}
 			ctx.AbortWithStatusJSON(http.StatusNotFound, web.NewResponse(http.StatusNotFound, nil, err.Error()))
+			return
 		}
 
-		if len(u) == 0 {
+		if len(page.Users) == 0 {
 			ctx.Status(http.StatusNoContent)
 			return
 		}
 
-		ctx.JSON(http.StatusOK, web.NewResponse(http.StatusOK, u, ""))
+		ctx.JSON(http.StatusOK, web.NewPageResponse(http.StatusOK, page.Users, pageMeta(ctx, q, page)))
+	}
+}
+
+func parseUserQuery(ctx *gin.Context) (users.Query, error) {
+	q := users.Query{
+		Name:     ctx.Query("name"),
+		Lastname: ctx.Query("lastname"),
+		Email:    ctx.Query("email"),
+		Cursor:   ctx.Query("cursor"),
+	}
+	var err error
+	if q.Sort, err = users.ParseSort(ctx.Query("sort")); err != nil {
+		return q, err
+	}
+	if q.MinAge, err = queryInt(ctx, "age_min"); err != nil {
+		return q, err
+	}
+	if q.MaxAge, err = queryInt(ctx, "age_max"); err != nil {
+		return q, err
+	}
+	if q.MinHeight, err = queryFloat(ctx, "height_min"); err != nil {
+		return q, err
+	}
+	if q.MaxHeight, err = queryFloat(ctx, "height_max"); err != nil {
+		return q, err
+	}
+	if value, ok := ctx.GetQuery("active"); ok {
+		active, err := strconv.ParseBool(value)
+		if err != nil {
+			return q, fmt.Errorf("active inválido")
+		}
+		q.Active = &active
+	}
+	if q.CreatedFrom, err = queryTime(ctx, "created_from", false); err != nil {
+		return q, err
+	}
+	if q.CreatedTo, err = queryTime(ctx, "created_to", true); err != nil {
+		return q, err
+	}
+
+	limit, err := queryInt(ctx, "limit")
+	if err != nil {
+		return q, err
+	}
+	if limit != nil {
+		if *limit < 1 {
+			return q, fmt.Errorf("limit deve estar entre 1 e %d", users.MaxLimit)
+		}
+		q.Limit = *limit
+	}
+	offset, err := queryInt(ctx, "offset")
+	if err != nil {
+		return q, err
+	}
+	if offset != nil {
+		q.Offset = *offset
+	}
+	return q, nil
+}
+
+func queryInt(ctx *gin.Context, key string) (*int, error) {
+	value, ok := ctx.GetQuery(key)
+	if !ok {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s inválido", key)
+	}
+	return &n, nil
+}
+
+func queryFloat(ctx *gin.Context, key string) (*float64, error) {
+	value, ok := ctx.GetQuery(key)
+	if !ok {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s inválido", key)
+	}
+	return &f, nil
+}
+
+// queryTime accepts RFC3339 or a plain date, which with endOfDay covers the
+// whole day.
+func queryTime(ctx *gin.Context, key string, endOfDay bool) (*time.Time, error) {
+	value, ok := ctx.GetQuery(key)
+	if !ok {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return nil, fmt.Errorf("%s inválido", key)
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	return &t, nil
+}
+
+// pageMeta links the neighbouring pages the same way the request paged, by
+// offset when it sent one and by cursor otherwise.
+func pageMeta(ctx *gin.Context, q users.Query, page users.Page) web.Meta {
+	meta := web.Meta{Total: page.Total, Offset: page.Offset}
+	link := func(set func(v url.Values)) string {
+		v := ctx.Request.URL.Query()
+		v.Del("cursor")
+		v.Del("offset")
+		set(v)
+		return ctx.Request.URL.Path + "?" + v.Encode()
+	}
+
+	if _, byOffset := ctx.GetQuery("offset"); byOffset && q.Limit > 0 {
+		if page.Next != "" {
+			meta.Next = link(func(v url.Values) { v.Set("offset", strconv.Itoa(page.Offset+q.Limit)) })
+		}
+		if page.Prev != "" {
+			meta.Prev = link(func(v url.Values) { v.Set("offset", strconv.Itoa(max(page.Offset-q.Limit, 0))) })
+		}
+		return meta
+	}
+	if page.Next != "" {
+		meta.Next = link(func(v url.Values) { v.Set("cursor", page.Next) })
+	}
+	if page.Prev != "" {
+		meta.Prev = link(func(v url.Values) { v.Set("cursor", page.Prev) })
+	}
+	return meta
+}
+
+// SearchUsers godoc
+// @Summary Search users
+// @Tags Users
+// @Description search users by name, lastname and email, ignoring accents and small typos, best matches first
+// @Accept  json
+// @Produce  json
+// @Param token header string true "token"
+// @Param q query string true "search terms"
+// @Param limit query int false "maximum results (default 20, max 100)"
+// @Success 200 {object} web.Response{data=[]users.SearchResult}
+// @Failure 400 {object} web.Response
+// @Failure 403 {object} web.Response
+// @Router /users/search [get]
+func (c *User) Search() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !c.allow(ctx, rbac.ActionList, 0) {
+			return
+		}
+		q := strings.TrimSpace(ctx.Query("q"))
+		if q == "" {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, "q é obrigatório"))
+			return
+		}
+		limit := 20
+		if value, ok := ctx.GetQuery("limit"); ok {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > 100 {
+				ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, "limit deve estar entre 1 e 100"))
+				return
+			}
+			limit = n
+		}
+
+		results, err := c.service.Search(q, limit)
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, web.NewResponse(http.StatusInternalServerError, nil, err.Error()))
+			return
+		}
+
+		ctx.JSON(http.StatusOK, web.NewResponse(http.StatusOK, results, ""))
+	}
+}
+
+// maxImportSize is the largest import body accepted, in bytes.
+const maxImportSize = 32 << 20
+
+// maxPatchSize is the largest patch body accepted, in bytes.
+const maxPatchSize = 1 << 20
+
+// ImportUsers godoc
+// @Summary Import users
+// @Tags Users
+// @Description create users from a CSV file, with a header row, or from JSON Lines. Each row is validated as in the creation of a single user and the report tells what happened to every row
+// @Accept  text/csv,application/x-ndjson
+// @Produce  json
+// @Param token header string true "token"
+// @Param mapping query string false "file columns to user fields, e.g. nome:name,sobrenome:lastname"
+// @Param atomic query bool false "store every user or none of them"
+// @Param file body string true "CSV or JSON Lines with name, lastname, email, age, height and active"
+// @Success 200 {object} web.Response{data=users.ImportReport}
+// @Failure 400 {object} web.Response
+// @Failure 415 {object} web.Response
+// @Failure 422 {object} web.Response{details=users.ImportReport}
+// @Failure 403 {object} web.Response
+// @Router /users/import [post]
+func (c *User) Import() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !c.allow(ctx, rbac.ActionCreate, 0) {
+			return
+		}
+		var format users.Format
+		switch ctx.ContentType() {
+		case "text/csv":
+			format = users.CSV
+		case "application/x-ndjson", "application/jsonl", "application/x-jsonlines":
+			format = users.JSONL
+		default:
+			ctx.AbortWithStatusJSON(http.StatusUnsupportedMediaType, web.NewResponse(http.StatusUnsupportedMediaType, nil, "Content-Type não suportado"))
+			return
+		}
+
+		mapping := map[string]string{}
+		if value := ctx.Query("mapping"); value != "" {
+			for _, pair := range strings.Split(value, ",") {
+				column, field, ok := strings.Cut(pair, ":")
+				if !ok {
+					ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, "mapping inválido"))
+					return
+				}
+				mapping[strings.TrimSpace(column)] = strings.TrimSpace(field)
+			}
+		}
+		atomic := false
+		if value, ok := ctx.GetQuery("atomic"); ok {
+			var err error
+			if atomic, err = strconv.ParseBool(value); err != nil {
+				ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, "atomic inválido"))
+				return
+			}
+		}
+
+		body := http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxImportSize)
+		rows, err := users.ReadImport(body, format, mapping)
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, err.Error()))
+			return
+		}
+
+		report, err := c.as(ctx).Import(rows, atomic)
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, web.NewResponse(http.StatusInternalServerError, nil, err.Error()))
+			return
+		}
+		if atomic && report.Failed > 0 {
+			ctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, web.NewErrorResponse(http.StatusUnprocessableEntity,
+				fmt.Sprintf("importação cancelada: %d linhas inválidas", report.Failed), report))
+			return
+		}
+
+		ctx.JSON(http.StatusOK, web.NewResponse(http.StatusOK, report, ""))
+	}
+}
+
+// ExportUsers godoc
+// @Summary Export users
+// @Tags Users
+// @Description stream every user matching the same filters and sorting as the user list, as CSV or JSON Lines
+// @Produce  text/csv,application/x-ndjson
+// @Param token header string true "token"
+// @Param format query string false "csv (default) or jsonl"
+// @Param name query string false "name prefix"
+// @Param lastname query string false "lastname prefix"
+// @Param email query string false "email"
+// @Param age_min query int false "minimum age"
+// @Param age_max query int false "maximum age"
+// @Param height_min query number false "minimum height"
+// @Param height_max query number false "maximum height"
+// @Param active query bool false "active"
+// @Param created_from query string false "created at or after (RFC3339 or YYYY-MM-DD)"
+// @Param created_to query string false "created at or before (RFC3339 or YYYY-MM-DD)"
+// @Param sort query string false "sort fields, - for descending, e.g. -age,name"
+// @Success 200 {string} string
+// @Failure 400 {object} web.Response
+// @Failure 403 {object} web.Response
+// @Router /users/export [get]
+func (c *User) Export() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !c.allow(ctx, rbac.ActionList, 0) {
+			return
+		}
+		format := users.Format(ctx.DefaultQuery("format", string(users.CSV)))
+		contentType := map[users.Format]string{users.CSV: "text/csv; charset=utf-8", users.JSONL: "application/x-ndjson"}[format]
+		if contentType == "" {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, "format inválido"))
+			return
+		}
+		q, err := parseUserQuery(ctx)
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, err.Error()))
+			return
+		}
+
+		exporter, _ := users.NewExporter(ctx.Writer, format)
+		ctx.Header("Content-Type", contentType)
+		ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="users.%s"`, format))
+		err = c.service.Export(q, exporter.Write)
+		if err == nil {
+			err = exporter.Flush()
+		}
+		if err != nil {
+			// Once the first users were sent the status can't change anymore,
+			// the client sees a truncated file.
+			if ctx.Writer.Written() {
+				_ = ctx.Error(err)
+				ctx.Abort()
+				return
+			}
+			ctx.Writer.Header().Del("Content-Disposition")
+			ctx.Writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+			var queryErr *users.InvalidQueryError
+			if errors.As(err, &queryErr) {
+				ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, queryErr.Error()))
+				return
+			}
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, web.NewResponse(http.StatusInternalServerError, nil, err.Error()))
+		}
+	}
+}
+
+type BatchOperationDto struct {
+	Op string `json:"op" binding:"required,oneof=create update patch delete"`
+	// ID and Version select the user of an update, patch or delete.
+	ID      uint `json:"id"`
+	Version uint `json:"version"`
+	// Data is the user of a create or update.
+	Data *UserModelDto `json:"data"`
+	// Patch is a merge patch object or a list of JSON Patch operations.
+	Patch json.RawMessage `json:"patch" swaggertype:"object"`
+}
+
+type BatchRequestDto struct {
+	Atomic     bool                `json:"atomic"`
+	Operations []BatchOperationDto `json:"operations" binding:"required,min=1,dive"`
+}
+
+// BatchUsers godoc
+// @Summary Batch user operations
+// @Tags Users
+// @Description run many create, update, patch and delete operations in a single transaction. Atomic batches are discarded as a whole when an operation fails, otherwise every operation that succeeds is saved. Each operation gets its own response, in order
+// @Accept  json
+// @Produce  json
+// @Param token header string true "token"
+// @Param batch body BatchRequestDto true "Operations to run"
+// @Success 200 {object} web.Response{data=[]web.Response}
+// @Failure 400 {object} web.Response
+// @Failure 422 {object} web.Response{details=[]web.Response}
+// @Failure 403 {object} web.Response
+// @Router /users/batch [post]
+func (c *User) Batch() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var req BatchRequestDto
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, err.Error()))
+			return
+		}
+		if len(req.Operations) > users.MaxBatchSize {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil,
+				fmt.Sprintf("o lote deve ter no máximo %d operações", users.MaxBatchSize)))
+			return
+		}
+
+		ops := make([]users.Operation, len(req.Operations))
+		for i, dto := range req.Operations {
+			op, err := batchOperation(dto)
+			if err != nil {
+				ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, fmt.Sprintf("operação %d: %s", i, err)))
+				return
+			}
+			ops[i] = op
+			if !c.allow(ctx, batchActions[op.Op], op.ID) {
+				return
+			}
+		}
+
+		results, err := c.as(ctx).Batch(ops, req.Atomic)
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, web.NewResponse(http.StatusInternalServerError, nil, err.Error()))
+			return
+		}
+
+		responses := make([]web.Response, len(results))
+		failed := -1
+		for i, result := range results {
+			if result.Err == nil {
+				status := map[users.OperationType]int{users.OpCreate: http.StatusCreated, users.OpUpdate: http.StatusCreated,
+					users.OpPatch: http.StatusOK, users.OpDelete: http.StatusNoContent}[ops[i].Op]
+				var data interface{}
+				if result.User != nil {
+					data = result.User
+				}
+				responses[i] = web.NewResponse(status, data, "")
+				continue
+			}
+			status, details := errorStatus(result.Err)
+			responses[i] = web.NewErrorResponse(status, result.Err.Error(), details)
+			if failed < 0 && !errors.Is(result.Err, users.ErrBatchAborted) {
+				failed = i
+			}
+		}
+		if req.Atomic && failed >= 0 {
+			ctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, web.NewErrorResponse(http.StatusUnprocessableEntity,
+				fmt.Sprintf("lote cancelado: a operação %d falhou", failed), responses))
+			return
+		}
+
+		ctx.JSON(http.StatusOK, web.NewResponse(http.StatusOK, responses, ""))
+	}
+}
+
+func batchOperation(dto BatchOperationDto) (users.Operation, error) {
+	op := users.Operation{Op: users.OperationType(dto.Op), ID: dto.ID, Version: dto.Version}
+	if op.Op != users.OpCreate && op.ID == 0 {
+		return op, errors.New("id é obrigatório")
+	}
+	switch op.Op {
+	case users.OpCreate, users.OpUpdate:
+		if dto.Data == nil {
+			return op, errors.New("data é obrigatório")
+		}
+		op.User = users.User{Name: dto.Data.Name, Lastname: dto.Data.Lastname, Email: dto.Data.Email,
+			Age: dto.Data.Age, Height: dto.Data.Height, Active: *dto.Data.Active}
+	case users.OpPatch:
+		patch := bytes.TrimSpace(dto.Patch)
+		if len(patch) == 0 {
+			return op, errors.New("patch é obrigatório")
+		}
+		op.PatchType, op.Patch = users.MergePatch, patch
+		if patch[0] == '[' {
+			op.PatchType = users.JSONPatch
+		}
+	}
+	return op, nil
+}
+
+// errorStatus is the status code, and the details, a handler answers err
+// with.
+func errorStatus(err error) (int, interface{}) {
+	var validationErr *users.ValidationError
+	var notFoundErr *users.NotFoundError
+	var conflictErr *users.ConflictError
+	var versionErr *users.VersionNotFoundError
+	switch {
+	case errors.As(err, &validationErr):
+		return http.StatusUnprocessableEntity, validationErr.Fields
+	case errors.As(err, &notFoundErr), errors.As(err, &versionErr):
+		return http.StatusNotFound, nil
+	case errors.As(err, &conflictErr):
+		return http.StatusPreconditionFailed, nil
+	case errors.Is(err, jsonpatch.ErrTestFailed):
+		return http.StatusConflict, nil
+	case errors.Is(err, users.ErrBatchAborted):
+		return http.StatusFailedDependency, nil
 	}
+	return http.StatusBadRequest, nil
 }
 
 // GetUser godoc
@@ -66,7 +594,11 @@ func (c *User) GetAll() gin.HandlerFunc {
 // @Accept  json
 // @Produce  json
 // @Param token header string true "token"
+// @Param If-None-Match header string false "ETag of a cached version"
 // @Success 200 {object} web.Response{data=users.User}
+// @Success 304
+// @Header 200 {string} ETag "version of the user"
+// @Failure 403 {object} web.Response
 // @Router /users/:id [get]
 func (c *User) GetById() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
@@ -77,6 +609,9 @@ func (c *User) GetById() gin.HandlerFunc {
 			ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, "ID inválido"))
 			return
 		}
+		if !c.allow(ctx, rbac.ActionRead, uint(idInt)) {
+			return
+		}
 
 		u, err := c.service.GetById(uint(idInt))
 		if err != nil {
@@ -89,6 +624,11 @@ func (c *User) GetById() gin.HandlerFunc {
 			return
 		}
 
+		ctx.Header("ETag", etag(u))
+		if noneMatch := ctx.GetHeader("If-None-Match"); noneMatch != "" && etagsMatch(noneMatch, u, true) {
+			ctx.Status(http.StatusNotModified)
+			return
+		}
 		ctx.JSON(http.StatusOK, web.NewResponse(http.StatusOK, u, ""))
 	}
 }
@@ -102,21 +642,30 @@ func (c *User) GetById() gin.HandlerFunc {
 // @Param token header string true "token"
 // @Param product body UserModelDto true "User to store"
 // @Success 200 {object} web.Response{data=users.User}
+// @Failure 422 {object} web.Response{details=[]users.FieldError}
+// @Failure 403 {object} web.Response
 // @Router /users/:id [put]
 func (c *User) Store() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
+		if !c.allow(ctx, rbac.ActionCreate, 0) {
+			return
+		}
 		var userDto UserModelDto
 		if err := ctx.ShouldBindJSON(&userDto); err != nil {
 			ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, err.Error()))
 			return
 		}
 
-		user, err := c.service.Store(userDto.Name, userDto.Lastname, userDto.Email, userDto.Age, userDto.Height, userDto.Active)
+		user, err := c.as(ctx).Store(userDto.Name, userDto.Lastname, userDto.Email, userDto.Age, userDto.Height, *userDto.Active)
 		if err != nil {
+			if abortOnValidation(ctx, err) {
+				return
+			}
 			ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, err.Error()))
 			return
 		}
 
+		ctx.Header("ETag", etag(user))
 		ctx.JSON(http.StatusCreated, web.NewResponse(http.StatusCreated, user, ""))
 	}
 }
@@ -128,8 +677,13 @@ func (c *User) Store() gin.HandlerFunc {
 // @Accept  json
 // @Produce  json
 // @Param token header string true "token"
+// @Param If-Match header string false "ETag of the version being updated"
 // @Param product body UserModelDto true "User to update"
 // @Success 201 {object} web.Response{data=users.User}
+// @Header 201 {string} ETag "new version of the user"
+// @Failure 412 {object} web.Response
+// @Failure 422 {object} web.Response{details=[]users.FieldError}
+// @Failure 403 {object} web.Response
 // @Router /users [post]
 func (c *User) Update() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
@@ -144,13 +698,25 @@ func (c *User) Update() gin.HandlerFunc {
 			ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, "ID inválido"))
 			return
 		}
+		if !c.allow(ctx, rbac.ActionUpdate, uint(id)) {
+			return
+		}
+
+		version, ok := c.ifMatch(ctx, uint(id))
+		if !ok {
+			return
+		}
 
-		user, err := c.service.Update(uint(id), userDto.Name, userDto.Lastname, userDto.Email, userDto.Age, userDto.Height, userDto.Active)
+		user, err := c.as(ctx).Update(uint(id), version, userDto.Name, userDto.Lastname, userDto.Email, userDto.Age, userDto.Height, *userDto.Active)
 		if err != nil {
+			if abortOnValidation(ctx, err) || abortOnConflict(ctx, err) {
+				return
+			}
 			ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, err.Error()))
 			return
 		}
 
+		ctx.Header("ETag", etag(user))
 		ctx.JSON(http.StatusCreated, web.NewResponse(http.StatusCreated, user, ""))
 	}
 }
@@ -158,18 +724,33 @@ func (c *User) Update() gin.HandlerFunc {
 // PatchUser godoc
 // @Summary Patch user
 // @Tags Users
-// @Description patch user
-// @Accept  json
+// @Description patch any field of the user with a JSON Merge Patch (RFC 7396, also used for application/json) or a JSON Patch (RFC 6902)
+// @Accept  application/merge-patch+json,application/json-patch+json,json
 // @Produce  json
 // @Param token header string true "token"
-// @Param product body UserPatchDto true "Fields to update"
+// @Param If-Match header string false "ETag of the version being patched"
+// @Param patch body object true "Merge patch object or list of JSON Patch operations"
 // @Success 200 {object} web.Response{data=users.User}
+// @Header 200 {string} ETag "new version of the user"
+// @Failure 400 {object} web.Response
+// @Failure 404 {object} web.Response
+// @Failure 409 {object} web.Response
+// @Failure 412 {object} web.Response
+// @Failure 413 {object} web.Response
+// @Failure 415 {object} web.Response
+// @Failure 422 {object} web.Response{details=[]users.FieldError}
+// @Failure 403 {object} web.Response
 // @Router /users/:id [patch]
 func (c *User) Patch() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		var userPatchDto UserPatchDto
-		if err := ctx.ShouldBindJSON(&userPatchDto); err != nil {
-			ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, err.Error()))
+		var patchType users.PatchType
+		switch ctx.ContentType() {
+		case string(users.MergePatch), "application/json":
+			patchType = users.MergePatch
+		case string(users.JSONPatch):
+			patchType = users.JSONPatch
+		default:
+			ctx.AbortWithStatusJSON(http.StatusUnsupportedMediaType, web.NewResponse(http.StatusUnsupportedMediaType, nil, "Content-Type não suportado"))
 			return
 		}
 
@@ -178,13 +759,46 @@ func (c *User) Patch() gin.HandlerFunc {
 			ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, "ID inválido"))
 			return
 		}
+		if !c.allow(ctx, rbac.ActionPatch, uint(id)) {
+			return
+		}
 
-		user, err := c.service.Patch(uint(id), userPatchDto.Lastname, userPatchDto.Age)
+		patch, err := io.ReadAll(http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxPatchSize))
+		var tooLargeErr *http.MaxBytesError
+		if errors.As(err, &tooLargeErr) {
+			ctx.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, web.NewResponse(http.StatusRequestEntityTooLarge, nil,
+				fmt.Sprintf("o patch deve ter no máximo %d bytes", maxPatchSize)))
+			return
+		}
 		if err != nil {
 			ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, err.Error()))
 			return
 		}
 
+		version, ok := c.ifMatch(ctx, uint(id))
+		if !ok {
+			return
+		}
+
+		user, err := c.as(ctx).Patch(uint(id), version, patchType, patch)
+		if err != nil {
+			if abortOnValidation(ctx, err) || abortOnConflict(ctx, err) {
+				return
+			}
+			var notFoundErr *users.NotFoundError
+			if errors.As(err, &notFoundErr) {
+				ctx.AbortWithStatusJSON(http.StatusNotFound, web.NewResponse(http.StatusNotFound, nil, notFoundErr.Error()))
+				return
+			}
+			if errors.Is(err, jsonpatch.ErrTestFailed) {
+				ctx.AbortWithStatusJSON(http.StatusConflict, web.NewResponse(http.StatusConflict, nil, err.Error()))
+				return
+			}
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, err.Error()))
+			return
+		}
+
+		ctx.Header("ETag", etag(user))
 		ctx.JSON(http.StatusOK, web.NewResponse(http.StatusOK, user, ""))
 	}
 }
@@ -192,11 +806,14 @@ func (c *User) Patch() gin.HandlerFunc {
 // deleteUser godoc
 // @Summary Delete user
 // @Tags Users
-// @Description Delete user
+// @Description Move user to the trash, it can be restored until it is purged
 // @Accept  json
 // @Produce  json
 // @Param token header string true "token"
+// @Param If-Match header string false "ETag of the version being deleted"
 // @Success 204
+// @Failure 412 {object} web.Response
+// @Failure 403 {object} web.Response
 // @Router /users/:id [delete]
 func (c *User) Delete() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
@@ -205,7 +822,17 @@ func (c *User) Delete() gin.HandlerFunc {
 			ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, "ID inválido"))
 			return
 		}
-		if err := c.service.Delete(uint(id)); err != nil {
+		if !c.allow(ctx, rbac.ActionDelete, uint(id)) {
+			return
+		}
+		version, ok := c.ifMatch(ctx, uint(id))
+		if !ok {
+			return
+		}
+		if err := c.as(ctx).Delete(uint(id), version); err != nil {
+			if abortOnConflict(ctx, err) {
+				return
+			}
 			var notFoundErr *users.NotFoundError
 			if errors.As(err, &notFoundErr) {
 				ctx.AbortWithStatusJSON(http.StatusNotFound, web.NewResponse(http.StatusNotFound, nil, notFoundErr.Error()))
@@ -218,3 +845,378 @@ func (c *User) Delete() gin.HandlerFunc {
 		ctx.Status(http.StatusNoContent)
 	}
 }
+
+// RestoreUser godoc
+// @Summary Restore user
+// @Tags Users
+// @Description restore a deleted user from the trash
+// @Accept  json
+// @Produce  json
+// @Param token header string true "token"
+// @Success 200 {object} web.Response{data=users.User}
+// @Failure 422 {object} web.Response{details=[]users.FieldError}
+// @Failure 404 {object} web.Response
+// @Failure 403 {object} web.Response
+// @Router /users/:id/restore [post]
+func (c *User) Restore() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id, err := strconv.Atoi(ctx.Param("id"))
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, "ID inválido"))
+			return
+		}
+		if !c.allow(ctx, rbac.ActionUpdate, uint(id)) {
+			return
+		}
+		user, err := c.as(ctx).Restore(uint(id))
+		if err != nil {
+			if abortOnValidation(ctx, err) {
+				return
+			}
+			var notFoundErr *users.NotFoundError
+			if errors.As(err, &notFoundErr) {
+				ctx.AbortWithStatusJSON(http.StatusNotFound, web.NewResponse(http.StatusNotFound, nil, "Usuário não encontrado na lixeira"))
+				return
+			}
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, err.Error()))
+			return
+		}
+
+		ctx.Header("ETag", etag(user))
+		ctx.JSON(http.StatusOK, web.NewResponse(http.StatusOK, user, ""))
+	}
+}
+
+// GetUserVersion godoc
+// @Summary Get user version
+// @Tags Users
+// @Description get the user as it was at a past version
+// @Produce  json
+// @Param token header string true "token"
+// @Success 200 {object} web.Response{data=users.User}
+// @Failure 404 {object} web.Response
+// @Failure 403 {object} web.Response
+// @Router /users/:id/versions/:version [get]
+func (c *User) GetVersion() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id, err := strconv.Atoi(ctx.Param("id"))
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, "ID inválido"))
+			return
+		}
+		if !c.allow(ctx, rbac.ActionRead, uint(id)) {
+			return
+		}
+		version, err := strconv.ParseUint(ctx.Param("version"), 10, 0)
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, "versão inválida"))
+			return
+		}
+
+		u, err := c.service.GetVersion(uint(id), uint(version))
+		if err != nil {
+			status, details := errorStatus(err)
+			ctx.AbortWithStatusJSON(status, web.NewErrorResponse(status, err.Error(), details))
+			return
+		}
+
+		ctx.JSON(http.StatusOK, web.NewResponse(http.StatusOK, u, ""))
+	}
+}
+
+// RevertUser godoc
+// @Summary Revert user
+// @Tags Users
+// @Description save the values the user had at a past version as a new version, validated as an update
+// @Produce  json
+// @Param token header string true "token"
+// @Param If-Match header string false "ETag of the version being replaced"
+// @Param version query int true "version to go back to"
+// @Success 200 {object} web.Response{data=users.User}
+// @Header 200 {string} ETag "new version of the user"
+// @Failure 400 {object} web.Response
+// @Failure 404 {object} web.Response
+// @Failure 412 {object} web.Response
+// @Failure 422 {object} web.Response{details=[]users.FieldError}
+// @Failure 403 {object} web.Response
+// @Router /users/:id/revert [post]
+func (c *User) Revert() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id, err := strconv.Atoi(ctx.Param("id"))
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, "ID inválido"))
+			return
+		}
+		if !c.allow(ctx, rbac.ActionUpdate, uint(id)) {
+			return
+		}
+		target, err := strconv.ParseUint(ctx.Query("version"), 10, 0)
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, "version inválido"))
+			return
+		}
+		version, ok := c.ifMatch(ctx, uint(id))
+		if !ok {
+			return
+		}
+
+		user, err := c.as(ctx).Revert(uint(id), version, uint(target))
+		if err != nil {
+			status, details := errorStatus(err)
+			ctx.AbortWithStatusJSON(status, web.NewErrorResponse(status, err.Error(), details))
+			return
+		}
+
+		ctx.Header("ETag", etag(user))
+		ctx.JSON(http.StatusOK, web.NewResponse(http.StatusOK, user, ""))
+	}
+}
+
+// UserHistory godoc
+// @Summary User history
+// @Tags Users
+// @Description list the audit entries of the changes made to the user, newest first
+// @Produce  json
+// @Param token header string true "token"
+// @Param actor query string false "who made the change"
+// @Param operation query string false "create, update, patch, delete, restore, revert or password"
+// @Param from query string false "changed at or after (RFC3339 or YYYY-MM-DD)"
+// @Param to query string false "changed at or before (RFC3339 or YYYY-MM-DD)"
+// @Param limit query int false "page size"
+// @Param offset query int false "entries to skip"
+// @Success 200 {object} web.Response{data=[]audit.Entry,meta=web.Meta}
+// @Failure 400 {object} web.Response
+// @Failure 403 {object} web.Response
+// @Router /users/:id/history [get]
+func (c *User) History() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id, err := strconv.Atoi(ctx.Param("id"))
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, "ID inválido"))
+			return
+		}
+		if !c.allow(ctx, rbac.ActionRead, uint(id)) {
+			return
+		}
+		f, err := parseAuditFilter(ctx)
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, err.Error()))
+			return
+		}
+
+		page, err := c.service.History(uint(id), f)
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, web.NewResponse(http.StatusInternalServerError, nil, err.Error()))
+			return
+		}
+
+		ctx.JSON(http.StatusOK, web.NewPageResponse(http.StatusOK, page.Entries, web.Meta{Total: page.Total, Offset: page.Offset}))
+	}
+}
+
+// allow answers 403 unless the caller may do action on the user id, zero
+// when the action is not about a single user. Without a policy, or roles
+// set by the guard, everything is allowed.
+func (c *User) allow(ctx *gin.Context, action string, id uint) bool {
+	if c.policy == nil {
+		return true
+	}
+	if p, ok := guards.Principal(ctx); !ok || c.policy.Allowed(p, action, id) {
+		return true
+	}
+	ctx.AbortWithStatusJSON(http.StatusForbidden, web.NewResponse(http.StatusForbidden, nil, "Acesso negado"))
+	return false
+}
+
+var batchActions = map[users.OperationType]string{
+	users.OpCreate: rbac.ActionCreate,
+	users.OpUpdate: rbac.ActionUpdate,
+	users.OpPatch:  rbac.ActionPatch,
+	users.OpDelete: rbac.ActionDelete,
+}
+
+type PasswordDto struct {
+	// CurrentPassword is required when users change their own password.
+	CurrentPassword string `json:"current_password"`
+	Password        string `json:"password" binding:"required"`
+}
+
+type PasswordResetDto struct {
+	Token     string `json:"token"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+// SetUserPassword godoc
+// @Summary Set user password
+// @Tags Users
+// @Description set the password of a user. Users changing their own password must send the current one
+// @Accept  json
+// @Produce  json
+// @Param token header string true "token"
+// @Param id path int true "user id"
+// @Param password body PasswordDto true "new password"
+// @Success 204
+// @Failure 400 {object} web.Response
+// @Failure 403 {object} web.Response
+// @Failure 404 {object} web.Response
+// @Failure 422 {object} web.Response{details=[]users.FieldError}
+// @Router /users/:id/password [put]
+func (c *User) SetPassword() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id, err := strconv.Atoi(ctx.Param("id"))
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, "ID inválido"))
+			return
+		}
+		if !c.allow(ctx, rbac.ActionPassword, uint(id)) {
+			return
+		}
+		var passwordDto PasswordDto
+		if err := ctx.ShouldBindJSON(&passwordDto); err != nil {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, err.Error()))
+			return
+		}
+
+		p, _ := guards.Principal(ctx)
+		if p.UserID == uint(id) || passwordDto.CurrentPassword != "" {
+			err = c.as(ctx).ChangePassword(uint(id), passwordDto.CurrentPassword, passwordDto.Password)
+		} else {
+			err = c.as(ctx).SetPassword(uint(id), passwordDto.Password)
+		}
+		if err != nil {
+			if abortOnValidation(ctx, err) {
+				return
+			}
+			var notFoundErr *users.NotFoundError
+			switch {
+			case errors.As(err, &notFoundErr):
+				ctx.AbortWithStatusJSON(http.StatusNotFound, web.NewResponse(http.StatusNotFound, nil, notFoundErr.Error()))
+			case errors.Is(err, users.ErrInvalidCredentials):
+				ctx.AbortWithStatusJSON(http.StatusForbidden, web.NewResponse(http.StatusForbidden, nil, "Senha atual incorreta"))
+			default:
+				ctx.AbortWithStatusJSON(http.StatusInternalServerError, web.NewResponse(http.StatusInternalServerError, nil, err.Error()))
+			}
+			return
+		}
+
+		ctx.Status(http.StatusNoContent)
+	}
+}
+
+// CreatePasswordReset godoc
+// @Summary Create password reset token
+// @Tags Users
+// @Description issue a single use token the user can set a new password with at POST /auth/reset-password, replacing any earlier one
+// @Produce  json
+// @Param token header string true "token"
+// @Param id path int true "user id"
+// @Success 201 {object} web.Response{data=PasswordResetDto}
+// @Failure 400 {object} web.Response
+// @Failure 403 {object} web.Response
+// @Failure 404 {object} web.Response
+// @Router /users/:id/password-reset [post]
+func (c *User) PasswordReset() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id, err := strconv.Atoi(ctx.Param("id"))
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, "ID inválido"))
+			return
+		}
+		if !c.allow(ctx, rbac.ActionPasswordReset, uint(id)) {
+			return
+		}
+
+		token, expiresAt, err := c.service.PasswordResetToken(uint(id))
+		if err != nil {
+			var notFoundErr *users.NotFoundError
+			if errors.As(err, &notFoundErr) {
+				ctx.AbortWithStatusJSON(http.StatusNotFound, web.NewResponse(http.StatusNotFound, nil, notFoundErr.Error()))
+				return
+			}
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, web.NewResponse(http.StatusInternalServerError, nil, err.Error()))
+			return
+		}
+
+		ctx.Header("Cache-Control", "no-store")
+		ctx.JSON(http.StatusCreated, web.NewResponse(http.StatusCreated, PasswordResetDto{Token: token, ExpiresAt: expiresAt.Format(time.RFC3339)}, ""))
+	}
+}
+
+// as returns the service acting on behalf of the authenticated caller.
+func (c *User) as(ctx *gin.Context) users.Service {
+	return c.service.WithActor(guards.Actor(ctx))
+}
+
+// abortOnValidation answers 422 with every failing field when err is a
+// users.ValidationError.
+func abortOnValidation(ctx *gin.Context, err error) bool {
+	var validationErr *users.ValidationError
+	if !errors.As(err, &validationErr) {
+		return false
+	}
+	ctx.AbortWithStatusJSON(http.StatusUnprocessableEntity,
+		web.NewErrorResponse(http.StatusUnprocessableEntity, validationErr.Error(), validationErr.Fields))
+	return true
+}
+
+// abortOnConflict answers 412 when err is a users.ConflictError, the write
+// was made against a stale version of the user.
+func abortOnConflict(ctx *gin.Context, err error) bool {
+	var conflictErr *users.ConflictError
+	if !errors.As(err, &conflictErr) {
+		return false
+	}
+	ctx.AbortWithStatusJSON(http.StatusPreconditionFailed, web.NewResponse(http.StatusPreconditionFailed, nil, conflictErr.Error()))
+	return true
+}
+
+// etag is the strong entity tag of the user's current version.
+func etag(u users.User) string {
+	return `"` + strconv.FormatUint(uint64(u.Version), 10) + `"`
+}
+
+// etagsMatch reports whether header, a list of entity tags or "*", names the
+// user's current version. A weak comparison ignores the W/ prefix, a strong
+// one never matches weak tags.
+func etagsMatch(header string, u users.User, weak bool) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if strings.HasPrefix(tag, "W/") {
+			if !weak {
+				continue
+			}
+			tag = tag[2:]
+		}
+		if tag == etag(u) {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatch returns the version the If-Match header requires the write to be
+// made against, zero when there is no header or it is "*". A list of tags is
+// checked against the current user. It answers 412 and returns false when
+// the header can't match.
+func (c *User) ifMatch(ctx *gin.Context, id uint) (uint, bool) {
+	header := strings.TrimSpace(ctx.GetHeader("If-Match"))
+	if header == "" || header == "*" {
+		return 0, true
+	}
+	if !strings.Contains(header, ",") {
+		tag := strings.Trim(header, `"`)
+		if version, err := strconv.ParseUint(tag, 10, 0); err == nil && header == `"`+tag+`"` && version > 0 {
+			return uint(version), true
+		}
+	} else if u, err := c.service.GetById(id); err == nil && etagsMatch(header, u, false) {
+		return u.Version, true
+	} else if err != nil {
+		// Let the write report the missing user.
+		return 0, true
+	}
+	ctx.AbortWithStatusJSON(http.StatusPreconditionFailed, web.NewResponse(http.StatusPreconditionFailed, nil, "If-Match não corresponde à versão do usuário"))
+	return 0, false
+}
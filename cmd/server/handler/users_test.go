@@ -3,11 +3,17 @@ package handler
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/Duarte64/go-web-meli/cmd/server/middleware/guards"
+	"github.com/Duarte64/go-web-meli/internal/audit"
+	"github.com/Duarte64/go-web-meli/internal/rbac"
 	"github.com/Duarte64/go-web-meli/internal/users"
 	"github.com/Duarte64/go-web-meli/pkg/store"
 	"github.com/Duarte64/go-web-meli/pkg/web"
@@ -55,6 +61,61 @@ func Test_SaveUser_OK(t *testing.T) {
 	assert.Equal(t, data.Height, expectedUser.Height)
 }
 
+func Test_SaveUser_Validation(t *testing.T) {
+	r := createServer()
+
+	req, rr := createRequestTest(http.MethodPost, "/users/", `{"name": "teste","lastname": "teste","age": 30,"height": 1.8,"email": "test@test.com", "active": false}`)
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	req, rr = createRequestTest(http.MethodPost, "/users/", `{"name": "teste","lastname": "teste","age": 300,"height": 1.8,"email": "TEST@test.com", "active": true}`)
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+	var response struct {
+		Details []users.FieldError `json:"details"`
+	}
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, []users.FieldError{
+		{Field: "age", Message: "deve estar entre 1 e 150"},
+		{Field: "email", Message: "já está em uso"},
+	}, response.Details)
+}
+
+func Test_PatchUser(t *testing.T) {
+	r := createServer()
+	req, rr := createRequestTest(http.MethodPost, "/users/", `{"name": "teste","lastname": "teste","age": 30,"height": 1.8,"email": "test@test.com", "active": true}`)
+	r.ServeHTTP(rr, req)
+
+	req, rr = createRequestTest(http.MethodPatch, "/users/1", `{"active": false, "age": 31}`)
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response web.Response
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, false, response.Data.(map[string]interface{})["active"])
+
+	req, rr = createRequestTest(http.MethodPatch, "/users/1", `[{"op": "test", "path": "/active", "value": true}, {"op": "replace", "path": "/age", "value": 40}]`)
+	req.Header.Set("Content-Type", "application/json-patch+json")
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	req, rr = createRequestTest(http.MethodPatch, "/users/1", `[{"op": "replace", "path": "/age", "value": -1}]`)
+	req.Header.Set("Content-Type", "application/json-patch+json")
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+	req, rr = createRequestTest(http.MethodPatch, "/users/1", `age=40`)
+	req.Header.Set("Content-Type", "text/plain")
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnsupportedMediaType, rr.Code)
+
+	req, rr = createRequestTest(http.MethodPatch, "/users/1", `{"name": "`+strings.Repeat("a", 1<<20)+`"}`)
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+}
+
 func Test_DeleteUser_OK(t *testing.T) {
 	var response web.Response
 	r := createServer()
@@ -69,6 +130,307 @@ func Test_DeleteUser_OK(t *testing.T) {
 	assert.Nil(t, response.Data)
 }
 
+func Test_RestoreUser_OK(t *testing.T) {
+	r := createServer()
+	req, rr := createRequestTest(http.MethodPost, "/users/", `{"name": "teste","lastname": "teste","age": 100,"height": 1.8,"email": "test@test.com", "active": true}`)
+	r.ServeHTTP(rr, req)
+	req, rr = createRequestTest(http.MethodDelete, "/users/1", "")
+	r.ServeHTTP(rr, req)
+
+	req, rr = createRequestTest(http.MethodGet, "/users/trash", "")
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	req, rr = createRequestTest(http.MethodPost, "/users/1/restore", "")
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	req, rr = createRequestTest(http.MethodPost, "/users/1/restore", "")
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	req, rr = createRequestTest(http.MethodGet, "/users/trash", "")
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+}
+
+func Test_ListUsers_Pagination(t *testing.T) {
+	r := createServer()
+	for _, name := range []string{"Ana", "Bruno", "Carla"} {
+		req, rr := createRequestTest(http.MethodPost, "/users/", `{"name": "`+name+`","lastname": "teste","age": 30,"height": 1.8,"email": "`+name+`@test.com", "active": true}`)
+		r.ServeHTTP(rr, req)
+	}
+
+	req, rr := createRequestTest(http.MethodGet, "/users/?sort=-name&limit=2", "")
+	r.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response web.Response
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Len(t, response.Data, 2)
+	assert.Equal(t, 3, response.Meta.Total)
+	assert.Empty(t, response.Meta.Prev)
+
+	req, rr = createRequestTest(http.MethodGet, response.Meta.Next, "")
+	r.ServeHTTP(rr, req)
+
+	response = web.Response{}
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, "Ana", response.Data.([]interface{})[0].(map[string]interface{})["name"])
+	assert.Empty(t, response.Meta.Next)
+	assert.NotEmpty(t, response.Meta.Prev)
+
+	req, rr = createRequestTest(http.MethodGet, "/users/?sort=nome", "")
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func Test_SearchUsers(t *testing.T) {
+	r := createServer()
+	req, rr := createRequestTest(http.MethodPost, "/users/", `{"name": "José","lastname": "Araújo","age": 30,"height": 1.8,"email": "jose@test.com", "active": true}`)
+	r.ServeHTTP(rr, req)
+
+	req, rr = createRequestTest(http.MethodGet, "/users/search?q=araujo", "")
+	r.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response web.Response
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Len(t, response.Data, 1)
+
+	req, rr = createRequestTest(http.MethodGet, "/users/search", "")
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func Test_UserVersions(t *testing.T) {
+	r := createServer()
+	body := `{"name": "teste","lastname": "teste","age": 30,"height": 1.8,"email": "test@test.com", "active": true}`
+	req, rr := createRequestTest(http.MethodPost, "/users/", body)
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, `"1"`, rr.Header().Get("ETag"))
+
+	req, rr = createRequestTest(http.MethodGet, "/users/1", "")
+	req.Header.Set("If-None-Match", `W/"1"`)
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotModified, rr.Code)
+
+	req, rr = createRequestTest(http.MethodPut, "/users/1", body)
+	req.Header.Set("If-Match", `"1"`)
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Equal(t, `"2"`, rr.Header().Get("ETag"))
+
+	// A second client still holding version 1 must not overwrite the update.
+	req, rr = createRequestTest(http.MethodPut, "/users/1", body)
+	req.Header.Set("If-Match", `"1"`)
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusPreconditionFailed, rr.Code)
+
+	req, rr = createRequestTest(http.MethodPatch, "/users/1", `{"age": 31}`)
+	req.Header.Set("If-Match", `"1", "2"`)
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `"3"`, rr.Header().Get("ETag"))
+
+	req, rr = createRequestTest(http.MethodGet, "/users/1", "")
+	req.Header.Set("If-None-Match", `"2"`)
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	req, rr = createRequestTest(http.MethodDelete, "/users/1", "")
+	req.Header.Set("If-Match", `W/"3"`)
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusPreconditionFailed, rr.Code)
+
+	req, rr = createRequestTest(http.MethodDelete, "/users/1", "")
+	req.Header.Set("If-Match", `"3"`)
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+}
+
+func Test_ImportExportUsers(t *testing.T) {
+	r := createServer()
+	file := "nome,sobrenome,email,idade,altura,ativo\n" +
+		"Ana,Silva,ana@test.com,30,1.6,true\n" +
+		"Bruno,Souza,bruno@test.com,300,1.8,false\n"
+
+	req, rr := createRequestTest(http.MethodPost, "/users/import?atomic=true&mapping=nome:name,sobrenome:lastname,idade:age,altura:height,ativo:active", file)
+	req.Header.Set("Content-Type", "text/csv")
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+	req, rr = createRequestTest(http.MethodPost, "/users/import?mapping=nome:name,sobrenome:lastname,idade:age,altura:height,ativo:active", file)
+	req.Header.Set("Content-Type", "text/csv")
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response struct {
+		Data users.ImportReport `json:"data"`
+	}
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, 1, response.Data.Created)
+	assert.Equal(t, 1, response.Data.Failed)
+
+	req, rr = createRequestTest(http.MethodPost, "/users/import", `{"name": "Carla", "lastname": "Lima", "email": "carla@test.com", "age": 40, "height": 1.7, "active": true}`)
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	req, rr = createRequestTest(http.MethodGet, "/users/export?sort=-name", "")
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", rr.Header().Get("Content-Type"))
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	assert.Len(t, lines, 3)
+	assert.True(t, strings.HasPrefix(lines[1], "2,Carla,"))
+
+	req, rr = createRequestTest(http.MethodGet, "/users/export?format=jsonl&name=ana", "")
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, 1, strings.Count(rr.Body.String(), "\n"))
+
+	req, rr = createRequestTest(http.MethodGet, "/users/export?format=xlsx", "")
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func Test_BatchUsers(t *testing.T) {
+	r := createServer()
+	batch := `{"atomic": %t, "operations": [
+		{"op": "create", "data": {"name": "Ana","lastname": "Silva","age": 30,"height": 1.6,"email": "ana@test.com", "active": true}},
+		{"op": "patch", "id": 1, "patch": [{"op": "replace", "path": "/age", "value": 31}]},
+		{"op": "delete", "id": 7}
+	]}`
+
+	req, rr := createRequestTest(http.MethodPost, "/users/batch", fmt.Sprintf(batch, true))
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	var failed struct {
+		Details []web.Response `json:"details"`
+	}
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &failed))
+	assert.Equal(t, []string{"424", "424", "404"}, []string{failed.Details[0].Code, failed.Details[1].Code, failed.Details[2].Code})
+
+	req, rr = createRequestTest(http.MethodPost, "/users/batch", fmt.Sprintf(batch, false))
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response struct {
+		Data []web.Response `json:"data"`
+	}
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, []string{"201", "200", "404"}, []string{response.Data[0].Code, response.Data[1].Code, response.Data[2].Code})
+	assert.Equal(t, float64(31), response.Data[1].Data.(map[string]interface{})["age"])
+
+	req, rr = createRequestTest(http.MethodPost, "/users/batch", `{"operations": [{"op": "delete"}]}`)
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func Test_UserHistory(t *testing.T) {
+	r := createServer()
+	req, rr := createRequestTest(http.MethodPost, "/users/", `{"name": "teste","lastname": "teste","age": 30,"height": 1.8,"email": "test@test.com", "active": true}`)
+	r.ServeHTTP(rr, req)
+	req, rr = createRequestTest(http.MethodPatch, "/users/1", `{"age": 31}`)
+	r.ServeHTTP(rr, req)
+
+	req, rr = createRequestTest(http.MethodGet, "/users/1/history?operation=patch", "")
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response struct {
+		Data []audit.Entry `json:"data"`
+		Meta web.Meta      `json:"meta"`
+	}
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, 1, response.Meta.Total)
+	assert.Equal(t, "tester", response.Data[0].Actor)
+
+	req, rr = createRequestTest(http.MethodGet, "/audit?actor=tester&entity_id=1", "")
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	response.Data = nil
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, 2, response.Meta.Total)
+
+	req, rr = createRequestTest(http.MethodGet, "/audit?from=ontem", "")
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func Test_RevertUser(t *testing.T) {
+	r := createServer()
+	req, rr := createRequestTest(http.MethodPost, "/users/", `{"name": "teste","lastname": "teste","age": 30,"height": 1.8,"email": "test@test.com", "active": true}`)
+	r.ServeHTTP(rr, req)
+	req, rr = createRequestTest(http.MethodPatch, "/users/1", `{"age": 31}`)
+	r.ServeHTTP(rr, req)
+
+	req, rr = createRequestTest(http.MethodGet, "/users/1/versions/1", "")
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response web.Response
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, float64(30), response.Data.(map[string]interface{})["age"])
+
+	req, rr = createRequestTest(http.MethodPost, "/users/1/revert?version=1", "")
+	req.Header.Set("If-Match", `"1"`)
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusPreconditionFailed, rr.Code)
+
+	req, rr = createRequestTest(http.MethodPost, "/users/1/revert?version=1", "")
+	req.Header.Set("If-Match", `"2"`)
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `"3"`, rr.Header().Get("ETag"))
+	response = web.Response{}
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, float64(30), response.Data.(map[string]interface{})["age"])
+
+	req, rr = createRequestTest(http.MethodPost, "/users/1/revert?version=7", "")
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func Test_UserPolicy(t *testing.T) {
+	db := store.NewMemory("", 0)
+	u := NewUserWithPolicy(users.NewService(users.NewRepository(db)), rbac.DefaultPolicy())
+	r := gin.Default()
+	ur := r.Group("/users", func(ctx *gin.Context) {
+		role, _ := strconv.Atoi(ctx.GetHeader("X-Test-Role"))
+		ctx.Set(guards.RolesKey, []string{[]string{rbac.RoleAdmin, rbac.RoleOperator, rbac.RoleViewer, rbac.RoleSelf}[role]})
+		ctx.Set(guards.UserIDKey, uint(1))
+	})
+	ur.GET("/", u.GetAll())
+	ur.POST("/", u.Store())
+	ur.GET("/:id", u.GetById())
+	ur.PATCH("/:id", u.Patch())
+	ur.DELETE("/:id", u.Delete())
+	ur.POST("/batch", u.Batch())
+	ur.POST("/:id/password-reset", u.PasswordReset())
+
+	request := func(role int, method, url, body string) int {
+		req, rr := createRequestTest(method, url, body)
+		req.Header.Set("X-Test-Role", strconv.Itoa(role))
+		r.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	const admin, operator, viewer, self = 0, 1, 2, 3
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusCreated, request(operator, http.MethodPost, "/users/", fmt.Sprintf(`{"name": "teste","lastname": "teste","age": 30,"height": 1.8,"email": "test%d@test.com", "active": true}`, i)))
+	}
+	assert.Equal(t, http.StatusForbidden, request(viewer, http.MethodPost, "/users/", `{}`))
+	assert.Equal(t, http.StatusOK, request(viewer, http.MethodGet, "/users/", ""))
+	assert.Equal(t, http.StatusForbidden, request(viewer, http.MethodPatch, "/users/1", `{"age": 31}`))
+	assert.Equal(t, http.StatusForbidden, request(self, http.MethodGet, "/users/", ""))
+	assert.Equal(t, http.StatusOK, request(self, http.MethodGet, "/users/1", ""))
+	assert.Equal(t, http.StatusForbidden, request(self, http.MethodGet, "/users/2", ""))
+	assert.Equal(t, http.StatusOK, request(self, http.MethodPatch, "/users/1", `{"age": 31}`))
+	assert.Equal(t, http.StatusForbidden, request(self, http.MethodPatch, "/users/2", `{"age": 31}`))
+	assert.Equal(t, http.StatusForbidden, request(operator, http.MethodDelete, "/users/2", ""))
+	assert.Equal(t, http.StatusForbidden, request(operator, http.MethodPost, "/users/batch", `{"operations": [{"op": "delete", "id": 2}]}`))
+	assert.Equal(t, http.StatusForbidden, request(self, http.MethodPost, "/users/1/password-reset", ""))
+	assert.Equal(t, http.StatusCreated, request(admin, http.MethodPost, "/users/1/password-reset", ""))
+	assert.Equal(t, http.StatusNoContent, request(admin, http.MethodDelete, "/users/2", ""))
+}
+
 func createRequestTest(method string, url string, body string) (*http.Request, *httptest.ResponseRecorder) {
 	req := httptest.NewRequest(method, url, bytes.NewBuffer([]byte(body)))
 	req.Header.Add("Content-Type", "application/json")
@@ -77,21 +439,32 @@ func createRequestTest(method string, url string, body string) (*http.Request, *
 	return req, httptest.NewRecorder()
 }
 
-func clearDatabase() error {
-	return os.WriteFile("./users.json", []byte("[]"), 0644)
-}
-
 func createServer() *gin.Engine {
 	_ = os.Setenv("TOKEN", "TESTE123")
-	db := store.New(store.FileType, "./users.json")
-	clearDatabase()
+	db := store.NewMemory("", 0)
 	repo := users.NewRepository(db)
-	service := users.NewService(repo)
+	auditLog := audit.NewLog(store.NewMemory("", 0))
+	service := users.NewAuditedService(repo, auditLog, nil)
 	u := NewUser(service)
 	r := gin.Default()
+	r.Use(func(ctx *gin.Context) { ctx.Set(guards.ActorKey, "tester") })
+	r.GET("/audit", NewAudit(auditLog).List())
 
 	ur := r.Group("/users")
+	ur.GET("/", u.GetAll())
+	ur.GET("/search", u.Search())
 	ur.POST("/", u.Store())
+	ur.GET("/:id", u.GetById())
+	ur.PUT("/:id", u.Update())
 	ur.DELETE("/:id", u.Delete())
+	ur.PATCH("/:id", u.Patch())
+	ur.GET("/trash", u.Trash())
+	ur.GET("/export", u.Export())
+	ur.POST("/import", u.Import())
+	ur.POST("/batch", u.Batch())
+	ur.POST("/:id/restore", u.Restore())
+	ur.GET("/:id/history", u.History())
+	ur.GET("/:id/versions/:version", u.GetVersion())
+	ur.POST("/:id/revert", u.Revert())
 	return r
 }
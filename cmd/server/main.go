@@ -1,13 +1,27 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/Duarte64/go-web-meli/cmd/server/handler"
 	"github.com/Duarte64/go-web-meli/cmd/server/middleware/guards"
 	"github.com/Duarte64/go-web-meli/docs"
+	"github.com/Duarte64/go-web-meli/internal/audit"
+	"github.com/Duarte64/go-web-meli/internal/auth"
+	"github.com/Duarte64/go-web-meli/internal/rbac"
 	"github.com/Duarte64/go-web-meli/internal/users"
 	"github.com/Duarte64/go-web-meli/pkg/store"
+	"github.com/Duarte64/go-web-meli/pkg/store/backup"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 	swaggerFiles "github.com/swaggo/files"
@@ -30,13 +44,50 @@ func main() {
 		panic("error ao carregar o arquivo .env")
 	}
 
-	db := store.New(store.FileType, "./users.json")
-
-	repo := users.NewRepository(db)
-	service := users.NewService(repo)
-	u := handler.NewUser(service)
+	db, history, repo, err := newRepository(store.ConfigFromEnv())
+	if err != nil {
+		panic("erro ao abrir o banco de dados: " + err.Error())
+	}
+	if err := runMigrations(db); err != nil {
+		panic("erro ao migrar o banco de dados: " + err.Error())
+	}
+	var cached *users.CachedRepository
+	if size, _ := strconv.Atoi(os.Getenv("CACHE_SIZE")); size > 0 {
+		cached = users.NewCachedRepository(repo, size, watchFile(db))
+		repo = cached
+	}
+	auditDB, err := store.Open(sideConfig("AUDIT", "./audit.json"))
+	if err != nil {
+		panic("erro ao abrir a auditoria: " + err.Error())
+	}
+	auditLog := audit.NewLog(auditDB)
+	keysDB, err := store.Open(sideConfig("KEYS", "./keys.json"))
+	if err != nil {
+		panic("erro ao abrir as chaves de API: " + err.Error())
+	}
+	keys := auth.NewKeys(keysDB)
+	credentialsDB, err := store.Open(sideConfig("CREDENTIALS", "./credentials.json"))
+	if err != nil {
+		panic("erro ao abrir as senhas: " + err.Error())
+	}
+	passwordAlgorithm := users.PasswordAlgorithm(os.Getenv("PASSWORD_HASH"))
+	if passwordAlgorithm != users.Bcrypt {
+		passwordAlgorithm = users.Argon2id
+	}
+	service := users.NewServiceWithCredentials(repo, auditLog, func(err error) { log.Println("erro ao registrar auditoria:", err) },
+		credentialsDB, passwordAlgorithm)
+	policy := rbac.DefaultPolicy()
+	if fileName := os.Getenv("RBAC_POLICY_FILE"); fileName != "" {
+		if policy, err = rbac.LoadPolicy(fileName); err != nil {
+			panic("erro ao carregar a política de acesso: " + err.Error())
+		}
+	}
+	u := handler.NewUserWithPolicy(service, policy)
 
 	router := gin.Default()
+	if err := router.SetTrustedProxies(trustedProxies()); err != nil {
+		panic("erro ao configurar os proxies confiáveis: " + err.Error())
+	}
 
 	docs.SwaggerInfo.Host = os.Getenv("HOST")
 	router.GET("/docs/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
@@ -47,19 +98,223 @@ func main() {
 		})
 	})
 
+	lockout := auth.NewLockout(auth.LockoutConfigFromEnv())
+	authenticate, err := authGuard(router, service, lockout)
+	if err != nil {
+		panic("erro ao configurar a autenticação: " + err.Error())
+	}
+	guard := guards.Protect(lockout, authenticate)
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	routeAdmin := router.Group("/admin")
+	routeAdmin.Use(guard, guards.RequireAction(policy, rbac.ActionAdmin))
+	if manager, err := users.NewBackupManager(db, backup.ConfigFromEnv()); err != nil {
+		log.Println("backups desativados:", err)
+	} else {
+		manager.AfterRestore = func() {
+			if cached != nil {
+				cached.Clear()
+			}
+			if err := service.Reindex(); err != nil {
+				log.Println("erro ao reindexar usuários:", err)
+			}
+		}
+		go manager.Run(ctx, func(err error) { log.Println("erro ao criar backup:", err) })
+
+		b := handler.NewBackup(manager)
+		routeAdmin.GET("/backups", b.List())
+		routeAdmin.POST("/backups", b.Create())
+		routeAdmin.POST("/backups/:name/restore", b.Restore())
+	}
+
+	if cached != nil {
+		routeAdmin.GET("/cache", handler.NewCache(cached).Stats())
+	}
+
+	k := handler.NewAPIKey(keys)
+	routeAdmin.GET("/keys", k.List())
+	routeAdmin.POST("/keys", k.Create())
+	routeAdmin.DELETE("/keys/:id", k.Revoke())
+	routeAdmin.POST("/keys/:id/rotate", k.Rotate())
+
+	l := handler.NewLockout(lockout)
+	routeAdmin.GET("/lockouts", l.List())
+	routeAdmin.DELETE("/lockouts/:key", l.Clear())
+
+	retention, _ := time.ParseDuration(os.Getenv("TRASH_RETENTION"))
+	purgeInterval, _ := time.ParseDuration(os.Getenv("TRASH_PURGE_INTERVAL"))
+	go users.RunPurge(ctx, service, retention, purgeInterval, func(err error) { log.Println("erro ao esvaziar a lixeira:", err) })
+
+	routeAudit := router.Group("/audit")
+	routeAudit.Use(guard, guards.RequireAction(policy, rbac.ActionAuditRead))
+	routeAudit.GET("", handler.NewAudit(auditLog).List())
+
 	routeUsers := router.Group("/users")
-	routeUsers.Use(guards.TokenAuthMiddleware())
+	routeUsers.Use(guards.Protect(lockout, guards.APIKeyAuthMiddleware(keys, authenticate)))
 	{
-		routeUsers.GET("", u.GetAll())
-		routeUsers.GET("/:id", u.GetById())
-		routeUsers.DELETE("/:id", u.Delete())
-		routeUsers.PATCH("/:id", u.Patch())
-		routeUsers.POST("", u.Store())
-		routeUsers.PUT("/:id", u.Update())
+		read, write := guards.RequireScope(auth.ScopeUsersRead), guards.RequireScope(auth.ScopeUsersWrite)
+		routeUsers.GET("", read, u.GetAll())
+		routeUsers.GET("/search", read, u.Search())
+		routeUsers.GET("/trash", read, u.Trash())
+		routeUsers.GET("/export", read, u.Export())
+		routeUsers.POST("/import", write, u.Import())
+		routeUsers.POST("/batch", write, u.Batch())
+		routeUsers.POST("/:id/restore", write, u.Restore())
+		routeUsers.GET("/:id/history", read, u.History())
+		routeUsers.GET("/:id/versions/:version", read, u.GetVersion())
+		routeUsers.POST("/:id/revert", write, u.Revert())
+		routeUsers.GET("/:id", read, u.GetById())
+		routeUsers.DELETE("/:id", write, u.Delete())
+		routeUsers.PATCH("/:id", write, u.Patch())
+		routeUsers.POST("", write, u.Store())
+		routeUsers.PUT("/:id", write, u.Update())
+		routeUsers.PUT("/:id/password", write, u.SetPassword())
+		routeUsers.POST("/:id/password-reset", write, u.PasswordReset())
 	}
 
-	err = router.Run(":8080")
+	srv := &http.Server{Addr: ":8080", Handler: router}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			panic("unable to start app")
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	stop()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Println("erro ao encerrar o servidor:", err)
+	}
+	for _, s := range []store.Store{db, history, auditDB, keysDB, credentialsDB} {
+		if closer, ok := s.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				log.Println("erro ao fechar o banco de dados:", err)
+			}
+		}
+	}
+}
+
+// authGuard returns the JWT guard when a key is configured and, if it can
+// sign, serves the login of the users and POST /auth/token to the clients
+// of AUTH_CLIENTS_FILE. Tokens without roles get JWT_DEFAULT_ROLE.
+// Otherwise the shared TOKEN is still accepted. The guard is not protected
+// from brute force, see guards.Protect.
+func authGuard(router *gin.Engine, service users.Service, lockout *auth.Lockout) (gin.HandlerFunc, error) {
+	cfg := auth.ConfigFromEnv()
+	if !cfg.Enabled() {
+		log.Println("JWT não configurado, usando o TOKEN compartilhado")
+		return guards.TokenAuthMiddleware(), nil
+	}
+	key, err := cfg.Key()
 	if err != nil {
-		panic("unable to start app")
+		return nil, err
+	}
+	if key.CanSign() {
+		var clients []auth.Client
+		if cfg.ClientsFile != "" {
+			if clients, err = auth.LoadClients(cfg.ClientsFile); err != nil {
+				return nil, err
+			}
+		}
+		for _, client := range clients {
+			if len(client.Roles) == 0 {
+				log.Printf("cliente %s sem papéis, seus tokens recebem o papel %s", client.ID, cfg.DefaultRole)
+			}
+		}
+		a := handler.NewAuth(auth.NewIssuer(key, cfg, clients), service, lockout)
+		router.POST("/auth/token", a.Token())
+		router.POST("/auth/login", a.Login())
+		router.POST("/auth/reset-password", a.ResetPassword())
+	}
+	return guards.JWTAuthMiddlewareWithRole(key, cfg.Validation(), cfg.DefaultRole), nil
+}
+
+// trustedProxies reads the comma separated TRUSTED_PROXIES, the addresses
+// or CIDRs whose X-Forwarded-For is believed. None are by default, so that
+// clients can't pick the IP failed logins are tracked by.
+func trustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
+// sideConfig describes a store kept next to the users one, a log store in
+// <prefix>_FILE unless <prefix>_STORE_TYPE says otherwise. It is encrypted
+// like the users store.
+func sideConfig(prefix, defaultFile string) store.Config {
+	cfg := store.ConfigFromEnv()
+	cfg.Type = store.Type(os.Getenv(prefix + "_STORE_TYPE"))
+	if cfg.Type == "" {
+		cfg.Type = store.LogType
+	}
+	cfg.FileName = os.Getenv(prefix + "_FILE")
+	if cfg.FileName == "" && cfg.Type != store.MemoryType {
+		cfg.FileName = defaultFile
+	}
+	return cfg
+}
+
+// newRepository opens the users store and, unless it is SQL, which keeps
+// them in a table of its own, the store of the past versions of the users.
+func newRepository(cfg store.Config) (store.Store, store.Store, users.Repository, error) {
+	db, err := store.Open(cfg)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if sqlite, ok := db.(*store.SQLiteStore); ok {
+		conn, err := sqlite.DB()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		repo, err := users.NewSQLRepository(conn)
+		return db, nil, repo, err
+	}
+	history, err := store.Open(sideConfig("HISTORY", "./history.json"))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return db, history, users.NewRepositoryWithHistory(db, history), nil
+}
+
+// runMigrations brings the store to the current schema unless
+// MIGRATE_ON_START is false. The SQL repository migrates its own table.
+func runMigrations(db store.Store) error {
+	if enabled, err := strconv.ParseBool(os.Getenv("MIGRATE_ON_START")); err == nil && !enabled {
+		return nil
+	}
+	if _, ok := db.(store.Versioned); !ok {
+		return nil
+	}
+	results, err := users.NewMigrationRunner(db).Run(false)
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		log.Printf("migração %d %s aplicada, %d registros alterados", r.Version, r.Name, r.Changed)
+	}
+	return nil
+}
+
+// watchFile returns the file other processes may change behind the cache,
+// the log and memory stores are only written by this process.
+func watchFile(db store.Store) string {
+	switch s := db.(type) {
+	case *store.FileStore:
+		return s.FileName
+	case *store.SQLiteStore:
+		return s.FileName
+	case *store.EncryptedStore:
+		return watchFile(s.Store)
 	}
+	return ""
 }
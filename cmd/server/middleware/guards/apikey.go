@@ -0,0 +1,74 @@
+package guards
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Duarte64/go-web-meli/internal/auth"
+	"github.com/Duarte64/go-web-meli/internal/rbac"
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyHeader carries the API key of a request.
+const APIKeyHeader = "X-API-Key"
+
+// ScopesKey is the gin context key of the scopes granted to the caller.
+// Guards that don't know about scopes leave it unset.
+const ScopesKey = "scopes"
+
+// Scopes returns the scopes granted to the caller, ok is false when the
+// guard that ran does not restrict them.
+func Scopes(c *gin.Context) (scopes []string, ok bool) {
+	v, ok := c.Get(ScopesKey)
+	scopes, _ = v.([]string)
+	return scopes, ok
+}
+
+// APIKeyAuthMiddleware authenticates requests carrying an API key, the
+// others are left to otherwise. Keys that may write are operators and the
+// others viewers.
+func APIKeyAuthMiddleware(keys *auth.Keys, otherwise gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		secret := c.GetHeader(APIKeyHeader)
+		if secret == "" {
+			otherwise(c)
+			return
+		}
+		key, err := keys.Authenticate(secret)
+		if err != nil {
+			if !errors.Is(err, auth.ErrInvalidKey) {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		c.Set(ActorKey, "apikey:"+key.Name)
+		c.Set(ScopesKey, key.Scopes)
+		role := rbac.RoleViewer
+		if key.HasScope(auth.ScopeUsersWrite) {
+			role = rbac.RoleOperator
+		}
+		c.Set(RolesKey, []string{role})
+		c.Next()
+	}
+}
+
+// RequireScope lets the request through when the caller was granted
+// scope, or when its guard does not restrict scopes.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scopes, ok := Scopes(c)
+		if !ok {
+			c.Next()
+			return
+		}
+		for _, s := range scopes {
+			if s == scope {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Forbidden", "required_scope": scope})
+	}
+}
@@ -0,0 +1,55 @@
+package guards
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/Duarte64/go-web-meli/pkg/jwt"
+	"github.com/gin-gonic/gin"
+)
+
+// ClaimsKey is the gin context key of the claims of the token the request
+// was authenticated with.
+const ClaimsKey = "claims"
+
+// Claims returns the claims set by JWTAuthMiddleware, zero when it did not
+// run.
+func Claims(c *gin.Context) jwt.Claims {
+	claims, _ := c.Get(ClaimsKey)
+	jc, _ := claims.(jwt.Claims)
+	return jc
+}
+
+// JWTAuthMiddleware accepts requests carrying a token signed with key in
+// the Authorization header, with the Bearer scheme. The subject of the
+// token is the actor, its scope claim, when present, the scopes and its
+// roles claim the roles.
+func JWTAuthMiddleware(key *jwt.Key, v jwt.Validation) gin.HandlerFunc {
+	return JWTAuthMiddlewareWithRole(key, v, "")
+}
+
+// JWTAuthMiddlewareWithRole is JWTAuthMiddleware giving defaultRole to the
+// tokens without a roles claim, which otherwise have no role.
+func JWTAuthMiddlewareWithRole(key *jwt.Key, v jwt.Validation, defaultRole string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scheme, token, _ := strings.Cut(c.GetHeader("Authorization"), " ")
+		if !strings.EqualFold(scheme, "Bearer") || token == "" {
+			c.Header("WWW-Authenticate", "Bearer")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		claims, err := key.Parse(strings.TrimSpace(token), v)
+		if err != nil || claims.Subject == "" {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		c.Set(ActorKey, claims.Subject)
+		c.Set(ClaimsKey, claims)
+		rolesOf(c, claims.Extra, defaultRole)
+		if scope, ok := claims.Extra["scope"].(string); ok {
+			c.Set(ScopesKey, strings.Fields(scope))
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,46 @@
+package guards
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/Duarte64/go-web-meli/internal/auth"
+	"github.com/Duarte64/go-web-meli/pkg/web"
+	"github.com/gin-gonic/gin"
+)
+
+// AbortIfBlocked answers with Retry-After when any of keys may not try to
+// authenticate yet: 423 when a principal is locked out and 429 otherwise.
+func AbortIfBlocked(c *gin.Context, l *auth.Lockout, keys ...string) bool {
+	block := l.Check(keys...)
+	if block == nil {
+		return false
+	}
+	seconds := int(math.Ceil(block.RetryAfter.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	status := http.StatusTooManyRequests
+	message := fmt.Sprintf("Muitas tentativas, tente novamente em %d segundos", seconds)
+	if block.Locked && block.Key != auth.IPKey(c.ClientIP()) {
+		status = http.StatusLocked
+		message = fmt.Sprintf("Conta bloqueada, tente novamente em %d segundos", seconds)
+	}
+	c.AbortWithStatusJSON(status, web.NewResponse(status, nil, message))
+	return true
+}
+
+// Protect runs guard unless the client IP is blocked, and records a
+// failure for the IP whenever guard rejects the request.
+func Protect(l *auth.Lockout, guard gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ip := auth.IPKey(c.ClientIP())
+		if AbortIfBlocked(c, l, ip) {
+			return
+		}
+		guard(c)
+		if c.IsAborted() && c.Writer.Status() == http.StatusUnauthorized && Actor(c) == "" {
+			l.Fail(ip)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package guards
+
+import (
+	"net/http"
+
+	"github.com/Duarte64/go-web-meli/internal/rbac"
+	"github.com/Duarte64/go-web-meli/pkg/web"
+	"github.com/gin-gonic/gin"
+)
+
+// RolesKey and UserIDKey are the gin context keys of the roles of the
+// caller and of the user record it is, if any.
+const (
+	RolesKey  = "roles"
+	UserIDKey = "user_id"
+)
+
+// Principal returns who the request acts as, ok is false when no guard
+// set the roles of the caller.
+func Principal(c *gin.Context) (p rbac.Principal, ok bool) {
+	v, ok := c.Get(RolesKey)
+	if !ok {
+		return rbac.Principal{}, false
+	}
+	p.Subject = Actor(c)
+	p.Roles, _ = v.([]string)
+	p.UserID = c.GetUint(UserIDKey)
+	return p, true
+}
+
+// RequireAction lets the request through when policy allows the caller to
+// do action, or when its guard does not know about roles.
+func RequireAction(policy *rbac.Policy, action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if p, ok := Principal(c); ok && !policy.Allowed(p, action, 0) {
+			c.AbortWithStatusJSON(http.StatusForbidden, web.NewResponse(http.StatusForbidden, nil, "Acesso negado"))
+			return
+		}
+		c.Next()
+	}
+}
+
+// rolesOf reads the roles claim of a token, defaultRole when it has none,
+// and the uid claim, the user the token was issued to.
+func rolesOf(c *gin.Context, extra map[string]interface{}, defaultRole string) {
+	roles := []string{}
+	if _, ok := extra["roles"]; !ok && defaultRole != "" {
+		roles = append(roles, defaultRole)
+	}
+	if list, ok := extra["roles"].([]interface{}); ok {
+		for _, r := range list {
+			if s, ok := r.(string); ok {
+				roles = append(roles, s)
+			}
+		}
+	}
+	c.Set(RolesKey, roles)
+	if uid, ok := extra["uid"].(float64); ok && uid > 0 {
+		c.Set(UserIDKey, uint(uid))
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Duarte64/go-web-meli/internal/users"
+	"github.com/Duarte64/go-web-meli/pkg/store"
+	"github.com/Duarte64/go-web-meli/pkg/store/backup"
+	"github.com/joho/godotenv"
+)
+
+const usage = `usage: storectl <command> [flags]
+
+commands:
+  convert      rewrite a users file in another encoding
+  rotate-key   encrypt the configured store again with STORE_ENCRYPTION_KEY
+  backup       create, list or restore snapshots of the configured store
+  migrate      show the schema version or apply pending migrations
+
+The store is configured by the same STORE_* variables as the server, read
+from the environment or from .env.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	// .env is optional here, the variables may come from the environment.
+	_ = godotenv.Load()
+
+	var err error
+	switch os.Args[1] {
+	case "convert":
+		err = convert(os.Args[2:])
+	case "rotate-key":
+		err = rotateKey(os.Args[2:])
+	case "backup":
+		err = backupCommand(os.Args[2:])
+	case "migrate":
+		err = migrateCommand(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "storectl:", err)
+		os.Exit(1)
+	}
+}
+
+func convert(args []string) error {
+	flags := flag.NewFlagSet("convert", flag.ExitOnError)
+	from := flags.String("from", "./users.json", "arquivo de origem")
+	fromType := flags.String("from-type", string(store.FileType), "formato de origem (file usa a extensão do arquivo)")
+	to := flags.String("to", "", "arquivo de destino")
+	toType := flags.String("to-type", string(store.FileType), "formato de destino (file usa a extensão do arquivo)")
+	flags.Parse(args)
+
+	if *to == "" {
+		return fmt.Errorf("convert: -to é obrigatório")
+	}
+	src, err := fileStore(store.Type(*fromType), *from)
+	if err != nil {
+		return err
+	}
+	dst, err := fileStore(store.Type(*toType), *to)
+	if err != nil {
+		return err
+	}
+
+	var us []users.User
+	if err := src.Read(&us); err != nil {
+		return err
+	}
+	if err := dst.Write(us); err != nil {
+		return err
+	}
+	fmt.Printf("%d usuários convertidos de %s para %s\n", len(us), *from, *to)
+	return nil
+}
+
+func fileStore(storeType store.Type, fileName string) (store.Store, error) {
+	codec, err := store.CodecFor(storeType, fileName)
+	if err != nil {
+		return nil, err
+	}
+	return &store.FileStore{FileName: fileName, Codec: codec}, nil
+}
+
+func rotateKey(args []string) error {
+	flags := flag.NewFlagSet("rotate-key", flag.ExitOnError)
+	plaintext := flags.Bool("plaintext", false, "criptografa um store que ainda não está criptografado")
+	flags.Parse(args)
+
+	db, err := store.Open(store.ConfigFromEnv())
+	if err != nil {
+		return err
+	}
+	encrypted, ok := db.(*store.EncryptedStore)
+	if !ok {
+		return fmt.Errorf("rotate-key: STORE_ENCRYPTION_KEY não configurada")
+	}
+	defer encrypted.Close()
+
+	if *plaintext {
+		err = encrypted.Encrypt(&[]users.User{})
+	} else {
+		err = encrypted.Rotate()
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("store criptografado com a chave atual")
+	return nil
+}
+
+const backupUsage = `usage: storectl backup create [-gzip]
+       storectl backup list
+       storectl backup restore <name>
+
+Restoring a log or memory store has to go through the running server
+(POST /admin/backups/:name/restore), their data lives in its memory.
+`
+
+func backupCommand(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, backupUsage)
+		os.Exit(2)
+	}
+
+	flags := flag.NewFlagSet("backup "+args[0], flag.ExitOnError)
+	cfg := backup.ConfigFromEnv()
+	flags.StringVar(&cfg.Dir, "dir", cfg.Dir, "diretório dos backups")
+	flags.BoolVar(&cfg.Compress, "gzip", cfg.Compress, "comprime o backup com gzip")
+	flags.Parse(args[1:])
+
+	db, err := store.Open(store.ConfigFromEnv())
+	if err != nil {
+		return err
+	}
+	if closer, ok := db.(interface{ Close() error }); ok {
+		defer closer.Close()
+	}
+	manager, err := users.NewBackupManager(db, cfg)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "create":
+		snapshot, err := manager.Create()
+		if err != nil {
+			return err
+		}
+		fmt.Println(snapshot.Name)
+	case "list":
+		snapshots, err := manager.List()
+		if err != nil {
+			return err
+		}
+		for _, s := range snapshots {
+			fmt.Printf("%s\t%s\t%d bytes\n", s.Name, s.CreatedAt.Format(time.RFC3339), s.Size)
+		}
+	case "restore":
+		if flags.NArg() != 1 {
+			return fmt.Errorf("backup restore: informe o nome do backup")
+		}
+		if err := manager.Restore(flags.Arg(0)); err != nil {
+			return err
+		}
+		fmt.Println("backup restaurado:", flags.Arg(0))
+	default:
+		fmt.Fprint(os.Stderr, backupUsage)
+		os.Exit(2)
+	}
+	return nil
+}
+
+const migrateUsage = `usage: storectl migrate status
+       storectl migrate up [-dry-run]
+
+SQLite databases are migrated by the server itself when it starts.
+`
+
+func migrateCommand(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+
+	flags := flag.NewFlagSet("migrate "+args[0], flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "mostra o que seria alterado sem gravar")
+	flags.Parse(args[1:])
+
+	db, err := store.Open(store.ConfigFromEnv())
+	if err != nil {
+		return err
+	}
+	if closer, ok := db.(interface{ Close() error }); ok {
+		defer closer.Close()
+	}
+	if _, ok := db.(store.Versioned); !ok {
+		return fmt.Errorf("migrate: o store configurado não suporta migrações")
+	}
+	runner := users.NewMigrationRunner(db)
+
+	switch args[0] {
+	case "status":
+		current, pending, err := runner.Status()
+		if err != nil {
+			return err
+		}
+		fmt.Println("versão atual:", current)
+		for _, m := range pending {
+			fmt.Printf("pendente\t%d\t%s\n", m.Version, m.Name)
+		}
+	case "up":
+		results, err := runner.Run(*dryRun)
+		if err != nil {
+			return err
+		}
+		if len(results) == 0 {
+			fmt.Println("nenhuma migração pendente")
+		}
+		for _, r := range results {
+			fmt.Printf("%d\t%s\t%d registros alterados\n", r.Version, r.Name, r.Changed)
+		}
+		if *dryRun {
+			fmt.Println("dry-run: nada foi gravado")
+		}
+	default:
+		fmt.Fprint(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+	return nil
+}
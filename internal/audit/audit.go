@@ -0,0 +1,164 @@
+// Package audit keeps an append-only record of the changes made to the
+// entities of the API.
+package audit
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/Duarte64/go-web-meli/pkg/store"
+)
+
+// Entry records one change. Changes lists every field that differs
+// between the entity before and after it.
+type Entry struct {
+	ID        uint     `json:"id"`
+	Time      string   `json:"time"`
+	Actor     string   `json:"actor"`
+	Operation string   `json:"operation"`
+	Entity    string   `json:"entity"`
+	EntityID  uint     `json:"entity_id"`
+	Changes   []Change `json:"changes"`
+}
+
+func (e Entry) GetID() uint {
+	return e.ID
+}
+
+// Change is a field of the entity, Before is missing for created fields and
+// After for removed ones.
+type Change struct {
+	Field  string      `json:"field"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// Diff compares the JSON fields of before and after, either may be nil.
+func Diff(before, after interface{}) ([]Change, error) {
+	b, err := fields(before)
+	if err != nil {
+		return nil, err
+	}
+	a, err := fields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []Change{}
+	for field, value := range b {
+		if other, ok := a[field]; !ok || !reflect.DeepEqual(value, other) {
+			changes = append(changes, Change{Field: field, Before: value, After: a[field]})
+		}
+	}
+	for field, value := range a {
+		if _, ok := b[field]; !ok {
+			changes = append(changes, Change{Field: field, After: value})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes, nil
+}
+
+func fields(v interface{}) (map[string]interface{}, error) {
+	m := map[string]interface{}{}
+	if v == nil || reflect.ValueOf(v).Kind() == reflect.Pointer && reflect.ValueOf(v).IsNil() {
+		return m, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return m, json.Unmarshal(data, &m)
+}
+
+// Filter selects entries, zero values do not filter.
+type Filter struct {
+	Actor     string
+	Operation string
+	Entity    string
+	EntityID  uint
+	From      *time.Time
+	To        *time.Time
+	Limit     int
+	Offset    int
+}
+
+func (f Filter) matches(e Entry) bool {
+	if f.Actor != "" && e.Actor != f.Actor ||
+		f.Operation != "" && e.Operation != f.Operation ||
+		f.Entity != "" && e.Entity != f.Entity ||
+		f.EntityID != 0 && e.EntityID != f.EntityID {
+		return false
+	}
+	if f.From != nil || f.To != nil {
+		t, err := time.Parse(time.RFC3339, e.Time)
+		if err != nil || f.From != nil && t.Before(*f.From) || f.To != nil && t.After(*f.To) {
+			return false
+		}
+	}
+	return true
+}
+
+// Page is a page of the entries matching a Filter, newest first. Total
+// counts every match.
+type Page struct {
+	Entries []Entry
+	Total   int
+	Offset  int
+}
+
+// Log is the audit log, entries can be appended and listed but never
+// changed or removed.
+type Log struct {
+	entries store.Collection[Entry]
+}
+
+func NewLog(db store.Store) *Log {
+	return &Log{entries: store.NewCollection[Entry](db)}
+}
+
+// Append stores the entries, in one write, giving each the next ID and
+// the current time when it has none.
+func (l *Log) Append(entries ...Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	now := time.Now().Format(time.RFC3339)
+	_, err := l.entries.CreateAll(func(next uint) []Entry {
+		appended := make([]Entry, len(entries))
+		for i, e := range entries {
+			e.ID = next + uint(i)
+			if e.Time == "" {
+				e.Time = now
+			}
+			appended[i] = e
+		}
+		return appended
+	})
+	return err
+}
+
+func (l *Log) List(f Filter) (Page, error) {
+	entries, err := l.entries.List()
+	if err != nil {
+		return Page{}, err
+	}
+	matched := []Entry{}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if f.matches(entries[i]) {
+			matched = append(matched, entries[i])
+		}
+	}
+
+	page := Page{Entries: []Entry{}, Total: len(matched), Offset: f.Offset}
+	if f.Offset < len(matched) {
+		matched = matched[f.Offset:]
+		if f.Limit > 0 && f.Limit < len(matched) {
+			matched = matched[:f.Limit]
+		}
+		page.Entries = matched
+	}
+	return page, nil
+}
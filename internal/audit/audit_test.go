@@ -0,0 +1,66 @@
+package audit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Duarte64/go-web-meli/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type item struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+	Note string `json:"note,omitempty"`
+}
+
+func TestDiff(t *testing.T) {
+	changes, err := Diff(item{Name: "a", Age: 1}, &item{Name: "a", Age: 2, Note: "x"})
+	require.NoError(t, err)
+	assert.Equal(t, []Change{
+		{Field: "age", Before: float64(1), After: float64(2)},
+		{Field: "note", After: "x"},
+	}, changes)
+
+	changes, err = Diff(nil, item{Name: "a"})
+	require.NoError(t, err)
+	assert.Equal(t, []Change{{Field: "age", After: float64(0)}, {Field: "name", After: "a"}}, changes)
+
+	var none *item
+	changes, err = Diff(item{Name: "a"}, none)
+	require.NoError(t, err)
+	assert.Equal(t, []Change{{Field: "age", Before: float64(0)}, {Field: "name", Before: "a"}}, changes)
+}
+
+func TestLog(t *testing.T) {
+	log := NewLog(store.NewMemory("", 0))
+	require.NoError(t, log.Append(
+		Entry{Actor: "ana", Operation: "create", Entity: "user", EntityID: 1, Time: "2024-01-01T10:00:00Z"},
+		Entry{Actor: "bia", Operation: "update", Entity: "user", EntityID: 1, Time: "2024-01-02T10:00:00Z"},
+	))
+	require.NoError(t, log.Append(Entry{Actor: "ana", Operation: "delete", Entity: "user", EntityID: 2}))
+
+	page, err := log.List(Filter{})
+	require.NoError(t, err)
+	assert.Equal(t, 3, page.Total)
+	assert.Equal(t, []uint{3, 2, 1}, []uint{page.Entries[0].ID, page.Entries[1].ID, page.Entries[2].ID})
+	assert.NotEmpty(t, page.Entries[0].Time)
+
+	page, err = log.List(Filter{Actor: "ana", EntityID: 1})
+	require.NoError(t, err)
+	assert.Len(t, page.Entries, 1)
+	assert.Equal(t, "create", page.Entries[0].Operation)
+
+	from := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)
+	page, err = log.List(Filter{From: &from, To: &to})
+	require.NoError(t, err)
+	assert.Len(t, page.Entries, 1)
+	assert.Equal(t, "bia", page.Entries[0].Actor)
+
+	page, err = log.List(Filter{Limit: 1, Offset: 1})
+	require.NoError(t, err)
+	assert.Equal(t, 3, page.Total)
+	assert.Equal(t, uint(2), page.Entries[0].ID)
+}
@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Duarte64/go-web-meli/internal/rbac"
+	"github.com/Duarte64/go-web-meli/pkg/jwt"
+)
+
+var (
+	ErrInvalidClient = errors.New("cliente inválido")
+	ErrInvalidScope  = errors.New("escopo inválido")
+)
+
+// Client is an application allowed to request tokens. Only the hex
+// SHA-256 of its secret is kept.
+type Client struct {
+	ID         string   `json:"id"`
+	SecretHash string   `json:"secret_hash"`
+	Scopes     []string `json:"scopes,omitempty"`
+	Roles      []string `json:"roles,omitempty"`
+}
+
+// HashSecret returns the SecretHash of secret.
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// LoadClients reads a JSON list of clients.
+func LoadClients(fileName string) ([]Client, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var clients []Client
+	if err := json.Unmarshal(data, &clients); err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+// Token is an issued access token, ExpiresIn is in seconds.
+type Token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	Scope       string `json:"scope,omitempty"`
+}
+
+// Issuer issues tokens to its clients, whose ID is the subject of the token,
+// whose scopes go, space separated, in the scope claim and whose roles go
+// in the roles claim.
+type Issuer struct {
+	key     *jwt.Key
+	cfg     Config
+	clients map[string]Client
+	now     func() time.Time
+}
+
+func NewIssuer(key *jwt.Key, cfg Config, clients []Client) *Issuer {
+	i := &Issuer{key: key, cfg: cfg, clients: map[string]Client{}, now: time.Now}
+	for _, c := range clients {
+		i.clients[c.ID] = c
+	}
+	return i
+}
+
+// Issue authenticates the client and signs a token for it. scope, when not
+// empty, narrows the token to some of the client's scopes.
+func (i *Issuer) Issue(clientID, secret, scope string) (Token, error) {
+	client, ok := i.clients[clientID]
+	hash := HashSecret(secret)
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(client.SecretHash)) != 1 || !ok {
+		return Token{}, ErrInvalidClient
+	}
+	scopes := client.Scopes
+	if scope != "" {
+		scopes = strings.Fields(scope)
+		for _, s := range scopes {
+			if !contains(client.Scopes, s) {
+				return Token{}, ErrInvalidScope
+			}
+		}
+	}
+
+	extra := map[string]interface{}{}
+	if len(client.Roles) > 0 {
+		extra["roles"] = client.Roles
+	}
+	return i.issue(client.ID, scopes, extra)
+}
+
+// IssueUser signs a token for the user userID, who logged in with a
+// password. Its role is rbac.RoleSelf and its uid claim the ID.
+func (i *Issuer) IssueUser(userID uint) (Token, error) {
+	return i.issue(fmt.Sprintf("user:%d", userID), KnownScopes, map[string]interface{}{
+		"roles": []string{rbac.RoleSelf},
+		"uid":   userID,
+	})
+}
+
+func (i *Issuer) issue(subject string, scopes []string, extra map[string]interface{}) (Token, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return Token{}, err
+	}
+	now := i.now()
+	claims := jwt.Claims{
+		Subject:   subject,
+		Issuer:    i.cfg.Issuer,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(i.cfg.TTL).Unix(),
+		ID:        hex.EncodeToString(id),
+	}
+	if i.cfg.Audience != "" {
+		claims.Audience = jwt.Audience{i.cfg.Audience}
+	}
+	token := Token{TokenType: "Bearer", ExpiresIn: int(i.cfg.TTL.Seconds()), Scope: strings.Join(scopes, " ")}
+	claims.Extra = extra
+	if token.Scope != "" {
+		claims.Extra["scope"] = token.Scope
+	}
+	var err error
+	token.AccessToken, err = i.key.Sign(claims)
+	return token, err
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Duarte64/go-web-meli/pkg/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIssue(t *testing.T) {
+	key, err := jwt.NewHMACKey([]byte(strings.Repeat("k", jwt.MinSecretLength)))
+	require.NoError(t, err)
+	cfg := Config{Issuer: "meli", Audience: "users-api", TTL: time.Minute}
+	issuer := NewIssuer(key, cfg, []Client{
+		{ID: "reports", SecretHash: HashSecret("s3cret"), Scopes: []string{"users:read", "users:write"}},
+	})
+
+	token, err := issuer.Issue("reports", "s3cret", "")
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer", token.TokenType)
+	assert.Equal(t, 60, token.ExpiresIn)
+	claims, err := key.Parse(token.AccessToken, cfg.Validation())
+	require.NoError(t, err)
+	assert.Equal(t, "reports", claims.Subject)
+	assert.Equal(t, "users:read users:write", claims.String("scope"))
+
+	token, err = issuer.Issue("reports", "s3cret", "users:read")
+	require.NoError(t, err)
+	assert.Equal(t, "users:read", token.Scope)
+
+	token, err = issuer.IssueUser(7)
+	require.NoError(t, err)
+	claims, err = key.Parse(token.AccessToken, cfg.Validation())
+	require.NoError(t, err)
+	assert.Equal(t, "user:7", claims.Subject)
+	assert.Equal(t, []interface{}{"self"}, claims.Extra["roles"])
+	assert.Equal(t, float64(7), claims.Extra["uid"])
+
+	_, err = issuer.Issue("reports", "s3cret", "admin")
+	assert.ErrorIs(t, err, ErrInvalidScope)
+	_, err = issuer.Issue("reports", "wrong", "")
+	assert.ErrorIs(t, err, ErrInvalidClient)
+	_, err = issuer.Issue("unknown", "", "")
+	assert.ErrorIs(t, err, ErrInvalidClient)
+}
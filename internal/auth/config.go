@@ -0,0 +1,93 @@
+// Package auth issues and checks the tokens clients authenticate with.
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Duarte64/go-web-meli/internal/rbac"
+	"github.com/Duarte64/go-web-meli/pkg/jwt"
+)
+
+// Config describes how tokens are signed and validated.
+type Config struct {
+	Algorithm string
+	// Secret is the HS256 key, the key files are PEM for RS256 and EdDSA.
+	// Without a private key tokens can be validated but not issued.
+	Secret         string
+	PrivateKeyFile string
+	PublicKeyFile  string
+	Issuer         string
+	Audience       string
+	// ClockSkew is tolerated on the expiry and not before times.
+	ClockSkew   time.Duration
+	TTL         time.Duration
+	ClientsFile string
+	// DefaultRole is the role of the tokens without a roles claim, such as
+	// those of clients configured without roles or issued elsewhere.
+	DefaultRole string
+}
+
+// ConfigFromEnv reads the JWT_* and AUTH_CLIENTS_FILE environment
+// variables. JWT_DEFAULT_ROLE defaults to viewer.
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Algorithm:      os.Getenv("JWT_ALGORITHM"),
+		Secret:         os.Getenv("JWT_SECRET"),
+		PrivateKeyFile: os.Getenv("JWT_PRIVATE_KEY_FILE"),
+		PublicKeyFile:  os.Getenv("JWT_PUBLIC_KEY_FILE"),
+		Issuer:         os.Getenv("JWT_ISSUER"),
+		Audience:       os.Getenv("JWT_AUDIENCE"),
+		ClientsFile:    os.Getenv("AUTH_CLIENTS_FILE"),
+		DefaultRole:    os.Getenv("JWT_DEFAULT_ROLE"),
+	}
+	cfg.ClockSkew, _ = time.ParseDuration(os.Getenv("JWT_CLOCK_SKEW"))
+	cfg.TTL, _ = time.ParseDuration(os.Getenv("JWT_TTL"))
+	if cfg.Algorithm == "" {
+		cfg.Algorithm = jwt.HS256
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = time.Hour
+	}
+	if cfg.DefaultRole == "" {
+		cfg.DefaultRole = rbac.RoleViewer
+	}
+	return cfg
+}
+
+// Enabled tells whether any key is configured.
+func (cfg Config) Enabled() bool {
+	return cfg.Secret != "" || cfg.PrivateKeyFile != "" || cfg.PublicKeyFile != ""
+}
+
+func (cfg Config) Key() (*jwt.Key, error) {
+	if cfg.Algorithm == jwt.HS256 {
+		if cfg.Secret == "" {
+			return nil, errors.New("JWT_SECRET é obrigatório para HS256")
+		}
+		return jwt.NewHMACKey([]byte(cfg.Secret))
+	}
+	var private, public []byte
+	var err error
+	if cfg.PrivateKeyFile != "" {
+		if private, err = os.ReadFile(cfg.PrivateKeyFile); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.PublicKeyFile != "" {
+		if public, err = os.ReadFile(cfg.PublicKeyFile); err != nil {
+			return nil, err
+		}
+	}
+	key, err := jwt.ParseKey(cfg.Algorithm, private, public)
+	if err != nil {
+		return nil, fmt.Errorf("chave JWT inválida: %w", err)
+	}
+	return key, nil
+}
+
+func (cfg Config) Validation() jwt.Validation {
+	return jwt.Validation{Issuer: cfg.Issuer, Audience: cfg.Audience, Leeway: cfg.ClockSkew}
+}
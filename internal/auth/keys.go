@@ -0,0 +1,244 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Duarte64/go-web-meli/pkg/store"
+)
+
+// Scopes an API key or token may be granted.
+const (
+	ScopeUsersRead  = "users:read"
+	ScopeUsersWrite = "users:write"
+)
+
+var KnownScopes = []string{ScopeUsersRead, ScopeUsersWrite}
+
+// keyPrefix starts every API key, so that leaked keys are easy to search
+// for.
+const keyPrefix = "gwm_"
+
+// lastUsedInterval is how stale LastUsedAt may get, so that a busy key
+// isn't written on every request.
+const lastUsedInterval = time.Minute
+
+var (
+	ErrInvalidKey  = errors.New("chave de API inválida")
+	ErrKeyNotFound = errors.New("chave de API não encontrada")
+)
+
+// InvalidKeyRequestError is returned when a key can't be created as asked.
+type InvalidKeyRequestError struct {
+	Message string
+}
+
+func (e *InvalidKeyRequestError) Error() string {
+	return e.Message
+}
+
+// APIKey describes a key, the key itself is only known when it is created
+// or rotated. Times are RFC3339 and empty when unset.
+type APIKey struct {
+	ID         uint     `json:"id"`
+	Name       string   `json:"name"`
+	Owner      string   `json:"owner"`
+	Scopes     []string `json:"scopes"`
+	Prefix     string   `json:"prefix"`
+	CreatedAt  string   `json:"created_at"`
+	ExpiresAt  string   `json:"expires_at,omitempty"`
+	LastUsedAt string   `json:"last_used_at,omitempty"`
+	RevokedAt  string   `json:"revoked_at,omitempty"`
+	// RotatedTo is the ID of the key that replaced this one.
+	RotatedTo uint `json:"rotated_to,omitempty"`
+}
+
+func (k APIKey) GetID() uint {
+	return k.ID
+}
+
+// HasScope tells whether the key was granted scope.
+func (k APIKey) HasScope(scope string) bool {
+	return contains(k.Scopes, scope)
+}
+
+func (k APIKey) active(now time.Time) bool {
+	if k.RevokedAt != "" {
+		return false
+	}
+	if k.ExpiresAt == "" {
+		return true
+	}
+	expires, err := time.Parse(time.RFC3339, k.ExpiresAt)
+	return err == nil && now.Before(expires)
+}
+
+// storedKey is an APIKey as kept in the store, with the hash of the key.
+type storedKey struct {
+	APIKey
+	Hash string `json:"hash"`
+}
+
+// Keys manages the API keys kept in a store. Only the SHA-256 of each key
+// is stored.
+type Keys struct {
+	keys store.Collection[storedKey]
+	now  func() time.Time
+}
+
+func NewKeys(db store.Store) *Keys {
+	return &Keys{keys: store.NewCollection[storedKey](db), now: time.Now}
+}
+
+// Create stores a new key and returns it along with its secret value,
+// which can't be recovered later. expiresAt may be nil.
+func (k *Keys) Create(name, owner string, scopes []string, expiresAt *time.Time) (APIKey, string, error) {
+	if strings.TrimSpace(name) == "" {
+		return APIKey{}, "", &InvalidKeyRequestError{Message: "name é obrigatório"}
+	}
+	if len(scopes) == 0 {
+		return APIKey{}, "", &InvalidKeyRequestError{Message: "scopes é obrigatório"}
+	}
+	for _, s := range scopes {
+		if !contains(KnownScopes, s) {
+			return APIKey{}, "", &InvalidKeyRequestError{Message: fmt.Sprintf("escopo desconhecido: %s", s)}
+		}
+	}
+	now := k.now()
+	if expiresAt != nil && !expiresAt.After(now) {
+		return APIKey{}, "", &InvalidKeyRequestError{Message: "expires_at deve estar no futuro"}
+	}
+
+	key, err := k.create(APIKey{Name: name, Owner: owner, Scopes: scopes}, expiresAt)
+	return key.APIKey, key.secret, err
+}
+
+type createdKey struct {
+	APIKey
+	secret string
+}
+
+func (k *Keys) create(key APIKey, expiresAt *time.Time) (createdKey, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return createdKey{}, err
+	}
+	secret := keyPrefix + base64.RawURLEncoding.EncodeToString(random)
+	key.Prefix = secret[:len(keyPrefix)+6]
+	key.CreatedAt = k.now().Format(time.RFC3339)
+	key.ExpiresAt = ""
+	if expiresAt != nil {
+		key.ExpiresAt = expiresAt.Format(time.RFC3339)
+	}
+	key.LastUsedAt, key.RevokedAt, key.RotatedTo = "", "", 0
+
+	stored, err := k.keys.Create(func(id uint) storedKey {
+		key.ID = id
+		return storedKey{APIKey: key, Hash: HashSecret(secret)}
+	})
+	return createdKey{APIKey: stored.APIKey, secret: secret}, err
+}
+
+func (k *Keys) List() ([]APIKey, error) {
+	stored, err := k.keys.List()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]APIKey, len(stored))
+	for i, s := range stored {
+		keys[i] = s.APIKey
+	}
+	return keys, nil
+}
+
+// Revoke disables the key at once, revoking it again changes nothing.
+func (k *Keys) Revoke(id uint) (APIKey, error) {
+	stored, err := k.keys.Modify(id, func(s *storedKey) error {
+		if s.RevokedAt == "" {
+			s.RevokedAt = k.now().Format(time.RFC3339)
+		}
+		return nil
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		return APIKey{}, ErrKeyNotFound
+	}
+	return stored.APIKey, err
+}
+
+// Rotate creates a key with the name, owner, scopes and expiry of key id
+// and returns it with its secret. The old key keeps working for grace and
+// is revoked right away when grace is zero.
+func (k *Keys) Rotate(id uint, grace time.Duration) (APIKey, string, error) {
+	old, err := k.keys.Get(id)
+	if errors.Is(err, store.ErrNotFound) {
+		return APIKey{}, "", ErrKeyNotFound
+	}
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	now := k.now()
+	if !old.active(now) {
+		return APIKey{}, "", &InvalidKeyRequestError{Message: "a chave está revogada ou expirada"}
+	}
+
+	var expiresAt *time.Time
+	if old.ExpiresAt != "" {
+		t, err := time.Parse(time.RFC3339, old.ExpiresAt)
+		if err != nil {
+			return APIKey{}, "", err
+		}
+		expiresAt = &t
+	}
+	key, err := k.create(old.APIKey, expiresAt)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	_, err = k.keys.Modify(id, func(s *storedKey) error {
+		s.RotatedTo = key.ID
+		if grace <= 0 {
+			s.RevokedAt = now.Format(time.RFC3339)
+		} else if end := now.Add(grace); expiresAt == nil || end.Before(*expiresAt) {
+			s.ExpiresAt = end.Format(time.RFC3339)
+		}
+		return nil
+	})
+	return key.APIKey, key.secret, err
+}
+
+// Authenticate returns the active key whose value is secret and records
+// that it was used.
+func (k *Keys) Authenticate(secret string) (APIKey, error) {
+	if !strings.HasPrefix(secret, keyPrefix) {
+		return APIKey{}, ErrInvalidKey
+	}
+	stored, err := k.keys.List()
+	if err != nil {
+		return APIKey{}, err
+	}
+	hash := HashSecret(secret)
+	now := k.now()
+	for _, s := range stored {
+		if s.Hash != hash {
+			continue
+		}
+		if !s.active(now) {
+			return APIKey{}, ErrInvalidKey
+		}
+		if last, err := time.Parse(time.RFC3339, s.LastUsedAt); err != nil || now.Sub(last) >= lastUsedInterval {
+			// Modify rather than Put, the key may have been revoked since it
+			// was listed.
+			if s, err = k.keys.Modify(s.ID, func(s *storedKey) error {
+				s.LastUsedAt = now.Format(time.RFC3339)
+				return nil
+			}); err != nil {
+				return APIKey{}, err
+			}
+		}
+		return s.APIKey, nil
+	}
+	return APIKey{}, ErrInvalidKey
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Duarte64/go-web-meli/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeys(t *testing.T) {
+	keys := NewKeys(store.NewMemory("", 0))
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	keys.now = func() time.Time { return now }
+
+	key, secret, err := keys.Create("reports", "ana", []string{ScopeUsersRead}, nil)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(secret, key.Prefix))
+	assert.Empty(t, key.LastUsedAt)
+
+	got, err := keys.Authenticate(secret)
+	require.NoError(t, err)
+	assert.Equal(t, key.ID, got.ID)
+	assert.True(t, got.HasScope(ScopeUsersRead))
+	assert.False(t, got.HasScope(ScopeUsersWrite))
+	assert.Equal(t, now.Format(time.RFC3339), got.LastUsedAt)
+	_, err = keys.Authenticate(secret + "x")
+	assert.ErrorIs(t, err, ErrInvalidKey)
+
+	// Rotation keeps the old key working for the grace period.
+	rotated, newSecret, err := keys.Rotate(key.ID, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, []string{ScopeUsersRead}, rotated.Scopes)
+	assert.NotEqual(t, secret, newSecret)
+	_, err = keys.Authenticate(secret)
+	assert.NoError(t, err)
+	now = now.Add(2 * time.Hour)
+	_, err = keys.Authenticate(secret)
+	assert.ErrorIs(t, err, ErrInvalidKey)
+	_, err = keys.Authenticate(newSecret)
+	assert.NoError(t, err)
+
+	revoked, err := keys.Revoke(rotated.ID)
+	require.NoError(t, err)
+	assert.NotEmpty(t, revoked.RevokedAt)
+	_, err = keys.Authenticate(newSecret)
+	assert.ErrorIs(t, err, ErrInvalidKey)
+	_, _, err = keys.Rotate(rotated.ID, 0)
+	assert.Error(t, err)
+
+	list, err := keys.List()
+	require.NoError(t, err)
+	assert.Len(t, list, 2)
+	assert.Equal(t, rotated.ID, list[0].RotatedTo)
+
+	_, err = keys.Revoke(99)
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	expired := now.Add(-time.Minute)
+	_, _, err = keys.Create("old", "ana", []string{ScopeUsersRead}, &expired)
+	assert.Error(t, err)
+	_, _, err = keys.Create("admin", "ana", []string{"admin"}, nil)
+	assert.Error(t, err)
+}
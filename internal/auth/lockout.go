@@ -0,0 +1,221 @@
+package auth
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LockoutConfig sets when failed authentications slow a client down and
+// when they lock it out.
+type LockoutConfig struct {
+	// FreeAttempts are the failures allowed before any delay, each failure
+	// after them doubles the delay from BaseDelay up to MaxDelay.
+	FreeAttempts int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	// MaxFailures locks the key out for LockoutDuration.
+	MaxFailures     int
+	LockoutDuration time.Duration
+	// Window is how long a failure is remembered.
+	Window time.Duration
+}
+
+// LockoutConfigFromEnv reads the LOCKOUT_* environment variables.
+func LockoutConfigFromEnv() LockoutConfig {
+	cfg := LockoutConfig{}
+	cfg.FreeAttempts, _ = strconv.Atoi(os.Getenv("LOCKOUT_FREE_ATTEMPTS"))
+	cfg.BaseDelay, _ = time.ParseDuration(os.Getenv("LOCKOUT_BASE_DELAY"))
+	cfg.MaxDelay, _ = time.ParseDuration(os.Getenv("LOCKOUT_MAX_DELAY"))
+	cfg.MaxFailures, _ = strconv.Atoi(os.Getenv("LOCKOUT_MAX_FAILURES"))
+	cfg.LockoutDuration, _ = time.ParseDuration(os.Getenv("LOCKOUT_DURATION"))
+	cfg.Window, _ = time.ParseDuration(os.Getenv("LOCKOUT_WINDOW"))
+	return cfg
+}
+
+func (cfg LockoutConfig) withDefaults() LockoutConfig {
+	if cfg.FreeAttempts <= 0 {
+		cfg.FreeAttempts = 3
+	}
+	if cfg.BaseDelay <= 0 {
+		cfg.BaseDelay = time.Second
+	}
+	if cfg.MaxDelay <= 0 {
+		cfg.MaxDelay = time.Minute
+	}
+	if cfg.MaxFailures <= 0 {
+		cfg.MaxFailures = 10
+	}
+	if cfg.LockoutDuration <= 0 {
+		cfg.LockoutDuration = 15 * time.Minute
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = 15 * time.Minute
+	}
+	return cfg
+}
+
+// IPKey and PrincipalKey are the keys failures are tracked by.
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+func PrincipalKey(principal string) string {
+	return "principal:" + strings.ToLower(principal)
+}
+
+// LockoutEntry is the failures of a key. BlockedUntil is when it may try
+// again, Locked tells whether that is a lockout rather than a delay.
+type LockoutEntry struct {
+	Key          string    `json:"key"`
+	Failures     int       `json:"failures"`
+	LastFailure  time.Time `json:"last_failure"`
+	BlockedUntil time.Time `json:"blocked_until,omitempty"`
+	Locked       bool      `json:"locked"`
+}
+
+// Block is why a request may not try to authenticate now.
+type Block struct {
+	Key        string
+	Locked     bool
+	RetryAfter time.Duration
+}
+
+// Lockout tracks failed authentications in memory.
+type Lockout struct {
+	cfg     LockoutConfig
+	mu      sync.Mutex
+	entries map[string]*LockoutEntry
+	now     func() time.Time
+}
+
+func NewLockout(cfg LockoutConfig) *Lockout {
+	return &Lockout{cfg: cfg.withDefaults(), entries: map[string]*LockoutEntry{}, now: time.Now}
+}
+
+// Check returns the block of the first of keys that is blocked, preferring
+// lockouts, or nil.
+func (l *Lockout) Check(keys ...string) *Block {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	var block *Block
+	for _, key := range keys {
+		e := l.entry(key, now)
+		if e == nil || !now.Before(e.BlockedUntil) {
+			continue
+		}
+		if block == nil || e.Locked && !block.Locked {
+			block = &Block{Key: key, Locked: e.Locked, RetryAfter: e.BlockedUntil.Sub(now)}
+		}
+	}
+	return block
+}
+
+// Fail records a failed authentication for every key.
+func (l *Lockout) Fail(keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.prune(now)
+	for _, key := range keys {
+		e := l.entry(key, now)
+		if e == nil {
+			e = &LockoutEntry{Key: key}
+			l.entries[key] = e
+		}
+		e.Failures++
+		e.LastFailure = now
+		switch {
+		case e.Failures >= l.cfg.MaxFailures:
+			e.BlockedUntil, e.Locked = now.Add(l.cfg.LockoutDuration), true
+		case e.Failures > l.cfg.FreeAttempts:
+			delay := l.cfg.BaseDelay << (e.Failures - l.cfg.FreeAttempts - 1)
+			if delay <= 0 || delay > l.cfg.MaxDelay {
+				delay = l.cfg.MaxDelay
+			}
+			e.BlockedUntil = now.Add(delay)
+		}
+	}
+}
+
+// Succeed forgets the failures of every key.
+func (l *Lockout) Succeed(keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		delete(l.entries, key)
+	}
+}
+
+// List returns the keys with failures, locked out ones first and then
+// delayed ones.
+func (l *Lockout) List() []LockoutEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.prune(now)
+	list := make([]LockoutEntry, 0, len(l.entries))
+	for _, e := range l.entries {
+		list = append(list, *e)
+	}
+	rank := func(e LockoutEntry) int {
+		switch {
+		case e.Locked:
+			return 0
+		case now.Before(e.BlockedUntil):
+			return 1
+		}
+		return 2
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if ri, rj := rank(list[i]), rank(list[j]); ri != rj {
+			return ri < rj
+		}
+		return list[i].Key < list[j].Key
+	})
+	return list
+}
+
+// Clear forgets the failures of key and tells whether it had any.
+func (l *Lockout) Clear(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.entries[key]
+	delete(l.entries, key)
+	return ok
+}
+
+// entry returns the entry of key unless it has expired, in which case it
+// is dropped.
+func (l *Lockout) entry(key string, now time.Time) *LockoutEntry {
+	e, ok := l.entries[key]
+	if !ok {
+		return nil
+	}
+	if l.expired(e, now) {
+		delete(l.entries, key)
+		return nil
+	}
+	return e
+}
+
+// expired tells whether the lockout of e is over or, when it isn't locked,
+// its last failure is older than the window.
+func (l *Lockout) expired(e *LockoutEntry, now time.Time) bool {
+	if e.Locked {
+		return !now.Before(e.BlockedUntil)
+	}
+	return now.Sub(e.LastFailure) > l.cfg.Window && !now.Before(e.BlockedUntil)
+}
+
+func (l *Lockout) prune(now time.Time) {
+	for key, e := range l.entries {
+		if l.expired(e, now) {
+			delete(l.entries, key)
+		}
+	}
+}
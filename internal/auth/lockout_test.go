@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockout(t *testing.T) {
+	l := NewLockout(LockoutConfig{FreeAttempts: 2, BaseDelay: time.Second, MaxDelay: 4 * time.Second, MaxFailures: 6, LockoutDuration: time.Hour, Window: time.Minute})
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	ip, user := IPKey("10.0.0.1"), PrincipalKey("Ana@meli.com")
+
+	l.Fail(ip, user)
+	l.Fail(ip, user)
+	assert.Nil(t, l.Check(ip, user))
+
+	// The delay doubles with every failure after the free ones.
+	for _, delay := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		l.Fail(ip, user)
+		block := l.Check(ip, user)
+		require.NotNil(t, block)
+		assert.False(t, block.Locked)
+		assert.Equal(t, delay, block.RetryAfter)
+		now = now.Add(delay)
+		assert.Nil(t, l.Check(ip, user))
+	}
+
+	l.Fail(user)
+	block := l.Check(ip, user)
+	require.NotNil(t, block)
+	assert.True(t, block.Locked)
+	assert.Equal(t, PrincipalKey("ana@meli.com"), block.Key)
+	assert.Equal(t, time.Hour, block.RetryAfter)
+
+	list := l.List()
+	require.Len(t, list, 2)
+	assert.Equal(t, user, list[0].Key)
+	assert.Equal(t, 6, list[0].Failures)
+
+	assert.True(t, l.Clear(user))
+	assert.False(t, l.Clear(user))
+	assert.Nil(t, l.Check(user))
+
+	// Failures are forgotten after the window and on success.
+	now = now.Add(2 * time.Minute)
+	assert.Empty(t, l.List())
+	l.Fail(ip)
+	l.Succeed(ip)
+	assert.Empty(t, l.List())
+}
@@ -0,0 +1,134 @@
+// Package rbac decides what each role may do with the API.
+package rbac
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Roles of the default policy.
+const (
+	RoleAdmin    = "admin"
+	RoleOperator = "operator"
+	RoleViewer   = "viewer"
+	// RoleSelf is a user acting on its own record.
+	RoleSelf = "self"
+)
+
+// Actions a Rule may grant.
+const (
+	ActionList   = "users:list"
+	ActionRead   = "users:read"
+	ActionCreate = "users:create"
+	ActionUpdate = "users:update"
+	ActionPatch  = "users:patch"
+	ActionDelete = "users:delete"
+	// ActionPassword sets the password of a user.
+	ActionPassword = "users:password"
+	// ActionPasswordReset issues a reset token, which sets the password
+	// without the current one.
+	ActionPasswordReset = "users:password-reset"
+	// ActionAuditRead lists the audit log, which holds the changes of every
+	// user.
+	ActionAuditRead = "audit:read"
+	// ActionAdmin manages API keys, lockouts, backups and the cache.
+	ActionAdmin = "admin:*"
+	// ActionAll matches every action.
+	ActionAll = "*"
+)
+
+var actions = []string{ActionList, ActionRead, ActionCreate, ActionUpdate, ActionPatch, ActionDelete, ActionPassword,
+	ActionPasswordReset, ActionAuditRead, ActionAdmin, ActionAll}
+
+// Principal is who a request acts as. UserID is the user record the
+// principal is, zero when it isn't one.
+type Principal struct {
+	Subject string
+	Roles   []string
+	UserID  uint
+}
+
+// Rule grants actions to a role. Own rules only apply to the principal's
+// own user record.
+type Rule struct {
+	Actions []string `json:"actions"`
+	Own     bool     `json:"own,omitempty"`
+}
+
+func (r Rule) grants(action string) bool {
+	for _, a := range r.Actions {
+		if a == action || a == ActionAll {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy maps each role to the rules it is granted, a principal may do
+// whatever any of its roles allows.
+type Policy struct {
+	Roles map[string][]Rule `json:"roles"`
+}
+
+// DefaultPolicy is used when no policy file is configured. Only admins may
+// read the audit log or use the admin routes.
+func DefaultPolicy() *Policy {
+	return &Policy{Roles: map[string][]Rule{
+		RoleAdmin:    {{Actions: []string{ActionAll}}},
+		RoleOperator: {{Actions: []string{ActionList, ActionRead, ActionCreate, ActionUpdate, ActionPatch}}},
+		RoleViewer:   {{Actions: []string{ActionList, ActionRead}}},
+		RoleSelf:     {{Actions: []string{ActionRead, ActionPatch, ActionPassword}, Own: true}},
+	}}
+}
+
+// LoadPolicy reads a policy from a JSON file.
+func LoadPolicy(fileName string) (*Policy, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePolicy(data)
+}
+
+func ParsePolicy(data []byte) (*Policy, error) {
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("política inválida: %w", err)
+	}
+	for role, rules := range p.Roles {
+		for _, r := range rules {
+			for _, a := range r.Actions {
+				if !contains(actions, a) {
+					return nil, fmt.Errorf("política inválida: ação desconhecida %q no papel %s", a, role)
+				}
+			}
+		}
+	}
+	return &p, nil
+}
+
+// Allowed tells whether p may do action on the user userID, zero when the
+// action is not about a single user, such as listing or creating.
+func (p *Policy) Allowed(pr Principal, action string, userID uint) bool {
+	for _, role := range pr.Roles {
+		for _, r := range p.Roles[role] {
+			if !r.grants(action) {
+				continue
+			}
+			if !r.Own || userID != 0 && userID == pr.UserID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package rbac
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultPolicy(t *testing.T) {
+	p := DefaultPolicy()
+	admin := Principal{Subject: "root", Roles: []string{RoleAdmin}}
+	operator := Principal{Subject: "ops", Roles: []string{RoleOperator}}
+	viewer := Principal{Subject: "bi", Roles: []string{RoleViewer}}
+	self := Principal{Subject: "7", Roles: []string{RoleSelf}, UserID: 7}
+
+	tests := []struct {
+		principal Principal
+		action    string
+		userID    uint
+		want      bool
+	}{
+		{admin, ActionDelete, 1, true},
+		{operator, ActionPatch, 1, true},
+		{operator, ActionDelete, 1, false},
+		{viewer, ActionList, 0, true},
+		{viewer, ActionRead, 1, true},
+		{viewer, ActionCreate, 0, false},
+		{viewer, ActionUpdate, 1, false},
+		{self, ActionRead, 7, true},
+		{self, ActionPatch, 7, true},
+		{self, ActionPatch, 8, false},
+		{self, ActionUpdate, 7, false},
+		{self, ActionPassword, 7, true},
+		{operator, ActionPassword, 7, false},
+		{self, ActionPasswordReset, 7, false},
+		{admin, ActionPasswordReset, 7, true},
+		{self, ActionList, 0, false},
+		{Principal{Subject: "nobody"}, ActionRead, 1, false},
+		{Principal{Roles: []string{RoleViewer, RoleSelf}, UserID: 7}, ActionPatch, 7, true},
+		{admin, ActionAuditRead, 0, true},
+		{admin, ActionAdmin, 0, true},
+		{operator, ActionAuditRead, 0, false},
+		{operator, ActionAdmin, 0, false},
+		{viewer, ActionAuditRead, 0, false},
+		{viewer, ActionAdmin, 0, false},
+		{self, ActionAuditRead, 0, false},
+		{self, ActionAdmin, 7, false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, p.Allowed(tt.principal, tt.action, tt.userID), "%v %s %d", tt.principal.Roles, tt.action, tt.userID)
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	p, err := ParsePolicy([]byte(`{"roles": {
+		"support": [{"actions": ["users:list", "users:read"]}, {"actions": ["users:update"], "own": true}]
+	}}`))
+	require.NoError(t, err)
+	support := Principal{Roles: []string{"support"}, UserID: 3}
+	assert.True(t, p.Allowed(support, ActionList, 0))
+	assert.True(t, p.Allowed(support, ActionUpdate, 3))
+	assert.False(t, p.Allowed(support, ActionUpdate, 4))
+	assert.False(t, p.Allowed(Principal{Roles: []string{RoleAdmin}}, ActionRead, 1))
+
+	_, err = ParsePolicy([]byte(`{"roles": {"support": [{"actions": ["users:drop"]}]}}`))
+	assert.Error(t, err)
+}
+
+func TestSamplePolicy(t *testing.T) {
+	p, err := LoadPolicy("../../policy.sample.json")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultPolicy(), p)
+}
@@ -0,0 +1,52 @@
+package users
+
+import "github.com/Duarte64/go-web-meli/internal/audit"
+
+// auditEntity is the entity of the audit entries of users.
+const auditEntity = "user"
+
+// Operations only found in audit entries, the others share the names of
+// the batch operations.
+const (
+	OpRestore OperationType = "restore"
+	OpRevert  OperationType = "revert"
+)
+
+// record appends the audit entry of a change from before to after, either
+// is nil when the user was created or deleted.
+func (s *service) record(op OperationType, id uint, before, after *User) {
+	if s.auditLog == nil {
+		return
+	}
+	changes, err := audit.Diff(before, after)
+	if err == nil {
+		err = s.auditLog.Append(audit.Entry{
+			Actor: s.actor, Operation: string(op), Entity: auditEntity, EntityID: id, Changes: changes,
+		})
+	}
+	if err != nil && s.onAuditError != nil {
+		s.onAuditError(err)
+	}
+}
+
+func (s *service) History(id uint, f audit.Filter) (audit.Page, error) {
+	if s.auditLog == nil {
+		return audit.Page{Entries: []audit.Entry{}}, nil
+	}
+	f.Entity, f.EntityID = auditEntity, id
+	return s.auditLog.List(f)
+}
+
+// pendingAudit holds the entries of a transaction until it is saved.
+type pendingAudit struct {
+	entries []audit.Entry
+}
+
+func (p *pendingAudit) Append(entries ...audit.Entry) error {
+	p.entries = append(p.entries, entries...)
+	return nil
+}
+
+func (p *pendingAudit) List(f audit.Filter) (audit.Page, error) {
+	return audit.Page{Entries: []audit.Entry{}}, nil
+}
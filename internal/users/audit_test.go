@@ -0,0 +1,53 @@
+package users
+
+import (
+	"testing"
+
+	"github.com/Duarte64/go-web-meli/internal/audit"
+	"github.com/Duarte64/go-web-meli/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceAudit(t *testing.T) {
+	log := audit.NewLog(store.NewMemory("", 0))
+	service := NewAuditedService(NewRepository(store.NewMemory("", 0)), log, func(err error) { t.Error(err) })
+	ana := service.WithActor("ana")
+
+	u, err := ana.Store("João", "Silva", "joao@example.com", 30, 1.7, true)
+	require.NoError(t, err)
+	_, err = service.WithActor("bia").Patch(u.ID, 0, MergePatch, []byte(`{"age": 31}`))
+	require.NoError(t, err)
+	_, err = ana.Update(u.ID, 0, "João", "Souza", "joao@example.com", 31, 1.7, true)
+	require.NoError(t, err)
+	require.NoError(t, ana.Delete(u.ID, 0))
+	_, err = ana.Restore(u.ID)
+	require.NoError(t, err)
+	_, err = ana.Batch([]Operation{{Op: OpPatch, ID: u.ID, PatchType: MergePatch, Patch: []byte(`{"height": 1.8}`)}}, true)
+	require.NoError(t, err)
+
+	page, err := service.History(u.ID, audit.Filter{})
+	require.NoError(t, err)
+	operations := []string{}
+	for _, e := range page.Entries {
+		operations = append(operations, e.Operation)
+	}
+	assert.Equal(t, []string{"patch", "restore", "delete", "update", "patch", "create"}, operations)
+
+	patch := page.Entries[4]
+	assert.Equal(t, "bia", patch.Actor)
+	assert.Contains(t, patch.Changes, audit.Change{Field: "age", Before: float64(30), After: float64(31)})
+	assert.Contains(t, page.Entries[3].Changes, audit.Change{Field: "lastname", Before: "Silva", After: "Souza"})
+
+	page, err = service.History(u.ID, audit.Filter{Actor: "bia"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, page.Total)
+
+	// Nothing is recorded for a batch that was discarded.
+	results, err := ana.Batch([]Operation{{Op: OpDelete, ID: u.ID}, {Op: OpDelete, ID: 99}}, true)
+	require.NoError(t, err)
+	assert.Error(t, results[1].Err)
+	page, err = service.History(u.ID, audit.Filter{})
+	require.NoError(t, err)
+	assert.Equal(t, 6, page.Total)
+}
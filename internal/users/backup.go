@@ -0,0 +1,20 @@
+package users
+
+import (
+	"errors"
+
+	"github.com/Duarte64/go-web-meli/pkg/store"
+	"github.com/Duarte64/go-web-meli/pkg/store/backup"
+)
+
+// NewBackupManager returns the backup manager of the users kept in db. The
+// snapshots of an encrypted store hold the encrypted data.
+func NewBackupManager(db store.Store, cfg backup.Config) (*backup.Manager, error) {
+	switch s := db.(type) {
+	case *store.SQLiteStore:
+		return nil, errors.New("backups are not supported by the sqlite store")
+	case *store.EncryptedStore:
+		return backup.NewManager(s.Store, func() interface{} { return &[]store.Envelope{} }, "users", cfg), nil
+	}
+	return backup.NewManager(db, func() interface{} { return &[]User{} }, "users", cfg), nil
+}
@@ -0,0 +1,123 @@
+package users
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MaxBatchSize is the largest number of operations a batch may hold.
+const MaxBatchSize = 100
+
+type OperationType string
+
+const (
+	OpCreate OperationType = "create"
+	OpUpdate OperationType = "update"
+	OpPatch  OperationType = "patch"
+	OpDelete OperationType = "delete"
+)
+
+// Operation is one step of a batch. User holds the fields of a create or
+// an update and Patch the document of a patch. Version is checked as in
+// the single user operations.
+type Operation struct {
+	Op        OperationType
+	ID        uint
+	Version   uint
+	User      User
+	PatchType PatchType
+	Patch     []byte
+}
+
+// OperationResult is the outcome of an operation, User is the user it left
+// and is nil for a delete.
+type OperationResult struct {
+	User *User
+	Err  error
+}
+
+// ErrBatchAborted is the outcome of the operations of an atomic batch that
+// failed, other than the one that made it fail.
+var ErrBatchAborted = errors.New("operação não aplicada: o lote foi cancelado")
+
+// errRollback discards the transaction of a failed atomic batch.
+var errRollback = errors.New("rollback")
+
+func (s *service) Batch(ops []Operation, atomic bool) ([]OperationResult, error) {
+	if len(ops) > MaxBatchSize {
+		return nil, fmt.Errorf("o lote deve ter no máximo %d operações", MaxBatchSize)
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	results := make([]OperationResult, len(ops))
+	pending := &pendingAudit{}
+	err := s.repository.Transaction(func(tx Repository) error {
+		// The search index and the audit log are only updated once the
+		// transaction is saved.
+		txState := &serviceState{repository: tx, index: NewSearchIndex()}
+		if s.auditLog != nil {
+			txState.auditLog = pending
+		}
+		txService := &service{serviceState: txState, actor: s.actor}
+		for i, op := range ops {
+			results[i] = txService.run(op)
+			if atomic && results[i].Err != nil {
+				for j := range results {
+					if j != i {
+						results[j] = OperationResult{Err: ErrBatchAborted}
+					}
+				}
+				return errRollback
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errRollback) {
+		return results, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(pending.entries) > 0 {
+		if err := s.auditLog.Append(pending.entries...); err != nil && s.onAuditError != nil {
+			s.onAuditError(err)
+		}
+	}
+
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+	if s.indexed {
+		for i, op := range ops {
+			switch {
+			case results[i].Err != nil:
+			case op.Op == OpDelete:
+				s.index.Remove(op.ID)
+			default:
+				s.index.Put(*results[i].User)
+			}
+		}
+	}
+	return results, nil
+}
+
+func (s *service) run(op Operation) OperationResult {
+	var u User
+	var err error
+	switch op.Op {
+	case OpCreate:
+		u, err = s.Store(op.User.Name, op.User.Lastname, op.User.Email, op.User.Age, op.User.Height, op.User.Active)
+	case OpUpdate:
+		u, err = s.Update(op.ID, op.Version, op.User.Name, op.User.Lastname, op.User.Email, op.User.Age, op.User.Height, op.User.Active)
+	case OpPatch:
+		u, err = s.Patch(op.ID, op.Version, op.PatchType, op.Patch)
+	case OpDelete:
+		return OperationResult{Err: s.Delete(op.ID, op.Version)}
+	default:
+		err = fmt.Errorf("operação desconhecida: %s", op.Op)
+	}
+	if err != nil {
+		return OperationResult{Err: err}
+	}
+	return OperationResult{User: &u}
+}
@@ -0,0 +1,52 @@
+package users
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceBatch(t *testing.T) {
+	for name, repository := range queryRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			service := NewService(repository)
+			_, err := service.Search("x", 0)
+			require.NoError(t, err)
+
+			ops := []Operation{
+				{Op: OpCreate, User: User{Name: "Novo", Lastname: "Usuário", Email: "novo@example.com", Age: 20, Height: 1.7}},
+				{Op: OpPatch, ID: 1, PatchType: MergePatch, Patch: []byte(`{"email": "novo@example.com"}`)},
+				{Op: OpPatch, ID: 1, Version: 1, PatchType: MergePatch, Patch: []byte(`{"lastname": "Trocado"}`)},
+				{Op: OpDelete, ID: 2},
+				{Op: OpUpdate, ID: 99, User: User{Name: "Nome", Lastname: "Sobrenome", Email: "x@example.com", Age: 20, Height: 1.7}},
+			}
+
+			results, err := service.Batch(ops, true)
+			require.NoError(t, err)
+			assert.IsType(t, &ValidationError{}, results[1].Err)
+			for _, i := range []int{0, 2, 3, 4} {
+				assert.ErrorIs(t, results[i].Err, ErrBatchAborted)
+			}
+			page, err := repository.GetAll(Query{})
+			require.NoError(t, err)
+			assert.Equal(t, []uint{1, 2, 3, 4, 5}, ids(page.Users))
+
+			results, err = service.Batch(ops, false)
+			require.NoError(t, err)
+			assert.Equal(t, uint(6), results[0].User.ID)
+			assert.IsType(t, &ValidationError{}, results[1].Err)
+			assert.Equal(t, "Trocado", results[2].User.Lastname)
+			assert.NoError(t, results[3].Err)
+			assert.Nil(t, results[3].User)
+			assert.IsType(t, &NotFoundError{}, results[4].Err)
+
+			page, err = repository.GetAll(Query{})
+			require.NoError(t, err)
+			assert.Equal(t, []uint{1, 3, 4, 5, 6}, ids(page.Users))
+			found, err := service.Search("novo", 0)
+			require.NoError(t, err)
+			assert.Equal(t, []uint{6}, searchIDs(found))
+		})
+	}
+}
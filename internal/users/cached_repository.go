@@ -0,0 +1,233 @@
+package users
+
+import (
+	"container/list"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type CacheStats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+	Size   int    `json:"size"`
+}
+
+// CachedRepository keeps the most recently read users, by ID, and the full
+// list in memory in front of another Repository. Every write through it
+// clears the affected entries; when WatchFile is set the whole cache is
+// also dropped as soon as that file changes on disk, which covers writes
+// made by other processes.
+type CachedRepository struct {
+	Repository
+	WatchFile string
+
+	mu         sync.Mutex
+	capacity   int
+	lru        *list.List
+	byID       map[uint]*list.Element
+	all        []User
+	hasAll     bool
+	generation uint64
+	modTime    time.Time
+	fileSize   int64
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+func NewCachedRepository(r Repository, capacity int, watchFile string) *CachedRepository {
+	return &CachedRepository{
+		Repository: r,
+		WatchFile:  watchFile,
+		capacity:   capacity,
+		lru:        list.New(),
+		byID:       map[uint]*list.Element{},
+	}
+}
+
+func (c *CachedRepository) Stats() CacheStats {
+	c.mu.Lock()
+	size := c.lru.Len()
+	c.mu.Unlock()
+	return CacheStats{Hits: c.hits.Load(), Misses: c.misses.Load(), Size: size}
+}
+
+// GetAll runs the query in memory over the cached list of every user, the
+// trashed ones included.
+func (c *CachedRepository) GetAll(q Query) (Page, error) {
+	us, err := c.list()
+	if err != nil {
+		return Page{}, err
+	}
+	return applyQuery(us, q)
+}
+
+func (c *CachedRepository) list() ([]User, error) {
+	c.mu.Lock()
+	c.checkFile()
+	if c.hasAll {
+		us := append([]User{}, c.all...)
+		c.mu.Unlock()
+		c.hits.Add(1)
+		return us, nil
+	}
+	generation := c.generation
+	c.mu.Unlock()
+	c.misses.Add(1)
+
+	live, err := c.Repository.GetAll(Query{})
+	if err != nil {
+		return nil, err
+	}
+	trashed, err := c.Repository.GetAll(Query{Trashed: true})
+	if err != nil {
+		return nil, err
+	}
+	us := append(live.Users, trashed.Users...)
+
+	c.mu.Lock()
+	// A write that happened while the list was read may not be in it.
+	if generation == c.generation {
+		c.all = append([]User{}, us...)
+		c.hasAll = true
+	}
+	c.mu.Unlock()
+	return us, nil
+}
+
+func (c *CachedRepository) GetById(id uint) (User, error) {
+	c.mu.Lock()
+	c.checkFile()
+	if e, ok := c.byID[id]; ok {
+		c.lru.MoveToFront(e)
+		u := e.Value.(User)
+		c.mu.Unlock()
+		c.hits.Add(1)
+		return u, nil
+	}
+	generation := c.generation
+	c.mu.Unlock()
+	c.misses.Add(1)
+
+	u, err := c.Repository.GetById(id)
+	if err != nil {
+		return u, err
+	}
+
+	c.mu.Lock()
+	if generation == c.generation {
+		c.put(u)
+	}
+	c.mu.Unlock()
+	return u, nil
+}
+
+func (c *CachedRepository) Store(id uint, name, lastname, email, createdAt string, age int, height float64, active bool) (User, error) {
+	defer c.invalidate(0)
+	return c.Repository.Store(id, name, lastname, email, createdAt, age, height, active)
+}
+
+func (c *CachedRepository) StoreAll(us []User) ([]User, error) {
+	defer c.invalidate(0)
+	return c.Repository.StoreAll(us)
+}
+
+func (c *CachedRepository) Update(id, version uint, name, lastname, email string, age int, height float64, active bool) (User, error) {
+	defer c.invalidate(id)
+	return c.Repository.Update(id, version, name, lastname, email, age, height, active)
+}
+
+func (c *CachedRepository) Modify(id, version uint, fn func(u *User) error) (User, error) {
+	defer c.invalidate(id)
+	return c.Repository.Modify(id, version, fn)
+}
+
+func (c *CachedRepository) Delete(id, version uint) error {
+	defer c.invalidate(id)
+	return c.Repository.Delete(id, version)
+}
+
+func (c *CachedRepository) Restore(id uint) (User, error) {
+	defer c.invalidate(id)
+	return c.Repository.Restore(id)
+}
+
+func (c *CachedRepository) Purge(deletedBefore time.Time) (int, error) {
+	// Only trashed users are removed and those are never cached by ID.
+	defer c.invalidate(0)
+	return c.Repository.Purge(deletedBefore)
+}
+
+// Transaction runs fn against the repository behind the cache, which is
+// cleared afterwards.
+func (c *CachedRepository) Transaction(fn func(tx Repository) error) error {
+	defer c.Clear()
+	return c.Repository.Transaction(fn)
+}
+
+// Clear drops everything cached, for changes made behind the repository.
+func (c *CachedRepository) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clear()
+}
+
+// invalidate drops the cached list and the user identified by id, if any.
+func (c *CachedRepository) invalidate(id uint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.all = nil
+	c.hasAll = false
+	if e, ok := c.byID[id]; ok {
+		c.lru.Remove(e)
+		delete(c.byID, id)
+	}
+}
+
+func (c *CachedRepository) clear() {
+	c.generation++
+	c.all = nil
+	c.hasAll = false
+	c.lru.Init()
+	c.byID = map[uint]*list.Element{}
+}
+
+func (c *CachedRepository) put(u User) {
+	if c.capacity <= 0 {
+		return
+	}
+	if e, ok := c.byID[u.ID]; ok {
+		e.Value = u
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.byID[u.ID] = c.lru.PushFront(u)
+	if c.lru.Len() > c.capacity {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.byID, oldest.Value.(User).ID)
+	}
+}
+
+// checkFile clears the cache when WatchFile changed since the last check.
+// It must be called with mu held.
+func (c *CachedRepository) checkFile() {
+	if c.WatchFile == "" {
+		return
+	}
+	info, err := os.Stat(c.WatchFile)
+	if err != nil {
+		c.clear()
+		c.modTime = time.Time{}
+		c.fileSize = 0
+		return
+	}
+	if !info.ModTime().Equal(c.modTime) || info.Size() != c.fileSize {
+		c.clear()
+		c.modTime = info.ModTime()
+		c.fileSize = info.Size()
+	}
+}
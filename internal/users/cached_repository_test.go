@@ -0,0 +1,90 @@
+package users
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCachedGetById(t *testing.T) {
+	repository := NewMockRepository(t)
+	cached := NewCachedRepository(repository, 10, "")
+	stored := User{ID: 1, Name: "Jane"}
+
+	repository.On("GetById", uint(1)).Return(stored, nil).Once()
+
+	for i := 0; i < 3; i++ {
+		u, err := cached.GetById(1)
+		assert.NoError(t, err)
+		assert.Equal(t, stored, u)
+	}
+
+	assert.Equal(t, CacheStats{Hits: 2, Misses: 1, Size: 1}, cached.Stats())
+}
+
+func TestCachedInvalidatedOnWrite(t *testing.T) {
+	repository := NewMockRepository(t)
+	cached := NewCachedRepository(repository, 10, "")
+	before := User{ID: 1, Name: "Jane"}
+	after := User{ID: 1, Name: "Jane", Lastname: "Doe"}
+
+	repository.On("GetAll", Query{}).Return(Page{Users: []User{before}, Total: 1}, nil).Once()
+	repository.On("GetAll", Query{Trashed: true}).Return(Page{Users: []User{}}, nil).Twice()
+	repository.On("GetById", uint(1)).Return(before, nil).Once()
+	repository.On("Modify", uint(1), uint(0), mock.Anything).Return(after, nil).Once()
+	repository.On("GetAll", Query{}).Return(Page{Users: []User{after}, Total: 1}, nil).Once()
+	repository.On("GetById", uint(1)).Return(after, nil).Once()
+
+	_, _ = cached.GetAll(Query{})
+	_, _ = cached.GetById(1)
+	_, err := cached.Modify(1, 0, func(u *User) error { return nil })
+	require.NoError(t, err)
+
+	page, err := cached.GetAll(Query{})
+	assert.NoError(t, err)
+	assert.Equal(t, []User{after}, page.Users)
+	u, err := cached.GetById(1)
+	assert.NoError(t, err)
+	assert.Equal(t, after, u)
+}
+
+func TestCachedEvictsLeastRecentlyUsed(t *testing.T) {
+	repository := NewMockRepository(t)
+	cached := NewCachedRepository(repository, 2, "")
+
+	for id := uint(1); id <= 3; id++ {
+		repository.On("GetById", id).Return(User{ID: id}, nil).Once()
+	}
+	repository.On("GetById", uint(1)).Return(User{ID: 1}, nil).Once()
+
+	_, _ = cached.GetById(1)
+	_, _ = cached.GetById(2)
+	_, _ = cached.GetById(3)
+	_, _ = cached.GetById(3)
+	_, _ = cached.GetById(1)
+
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 4, Size: 2}, cached.Stats())
+}
+
+func TestCachedInvalidatedOnFileChange(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "users.json")
+	require.NoError(t, os.WriteFile(fileName, []byte("[]"), 0644))
+
+	repository := NewMockRepository(t)
+	cached := NewCachedRepository(repository, 10, fileName)
+	repository.On("GetById", uint(1)).Return(User{ID: 1}, nil).Twice()
+
+	_, _ = cached.GetById(1)
+	_, _ = cached.GetById(1)
+
+	require.NoError(t, os.WriteFile(fileName, []byte(`[{"id": 1}]`), 0644))
+	require.NoError(t, os.Chtimes(fileName, time.Now(), time.Now().Add(time.Second)))
+	_, _ = cached.GetById(1)
+
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 2, Size: 1}, cached.Stats())
+}
@@ -1,5 +1,7 @@
 package users
 
+import "time"
+
 type User struct {
 	ID        uint    `json:"id"`
 	Name      string  `json:"name"`
@@ -9,4 +11,19 @@ type User struct {
 	Height    float64 `json:"height"`
 	Active    bool    `json:"active"`
 	CreatedAt string  `json:"created_at"`
+	UpdatedAt string  `json:"updated_at"`
+	// DeletedAt is set while the user is in the trash.
+	DeletedAt string `json:"deleted_at,omitempty"`
+	// Version is incremented by every change, it is what the ETag of the
+	// user is made of.
+	Version uint `json:"version"`
+}
+
+func (u User) GetID() uint {
+	return u.ID
+}
+
+// now returns the current time in the format of every timestamp kept in User.
+func now() string {
+	return time.Now().Format(time.RFC3339)
 }
@@ -0,0 +1,90 @@
+package users
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/Duarte64/go-web-meli/pkg/store"
+	"github.com/Duarte64/go-web-meli/pkg/store/migrate"
+)
+
+// Migrations holds every change made to the persisted shape of User, in
+// order. New ones must only be appended.
+var Migrations = []migrate.Migration{
+	{
+		Version: 1,
+		Name:    "normalize_created_at",
+		Up: func(r migrate.Record) (bool, error) {
+			return normalizeField(r, "created_at")
+		},
+	},
+	{
+		Version: 2,
+		Name:    "add_updated_at",
+		Up: func(r migrate.Record) (bool, error) {
+			if updatedAt, ok := r["updated_at"].(string); ok && updatedAt != "" {
+				return false, nil
+			}
+			r["updated_at"] = r["created_at"]
+			return true, nil
+		},
+	},
+	{
+		Version: 3,
+		Name:    "add_version",
+		Up: func(r migrate.Record) (bool, error) {
+			if version, ok := r["version"].(float64); ok && version > 0 {
+				return false, nil
+			}
+			r["version"] = 1
+			return true, nil
+		},
+	},
+}
+
+func NewMigrationRunner(db store.Store) *migrate.Runner {
+	runner := migrate.NewRunner(db, Migrations)
+	if fs, ok := db.(*store.FileStore); ok {
+		if _, isGob := fs.Codec.(store.GobCodec); isGob {
+			runner.NewData = func() interface{} { return &[]User{} }
+		}
+	}
+	return runner
+}
+
+func normalizeField(r migrate.Record, field string) (bool, error) {
+	value, _ := r[field].(string)
+	normalized, err := normalizeTime(value)
+	if err != nil {
+		return false, err
+	}
+	if normalized == value {
+		return false, nil
+	}
+	r[field] = normalized
+	return true, nil
+}
+
+// monotonicSuffix is appended by time.Time.String when the value still
+// carries a monotonic clock reading, e.g. " m=+11.688279793".
+var monotonicSuffix = regexp.MustCompile(` m=[+-][0-9.]+$`)
+
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999 -0700 MST",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// normalizeTime converts the formats found in users.json to RFC 3339.
+// Values without a time zone are taken as UTC.
+func normalizeTime(value string) (string, error) {
+	value = monotonicSuffix.ReplaceAllString(value, "")
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.Format(time.RFC3339), nil
+		}
+	}
+	return "", fmt.Errorf("unknown time format %q", value)
+}
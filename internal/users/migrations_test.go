@@ -0,0 +1,48 @@
+package users
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Duarte64/go-web-meli/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeTime(t *testing.T) {
+	cases := map[string]string{
+		"2019-02-01 00:00:00": "2019-02-01T00:00:00Z",
+		"2024-04-12 11:04:19.42315 -0300 -03 m=+11.688279793": "2024-04-12T11:04:19-03:00",
+		"2024-04-25 16:02:01.671705 -0300 -03":                "2024-04-25T16:02:01-03:00",
+		"2024-04-12T11:04:19-03:00":                           "2024-04-12T11:04:19-03:00",
+	}
+	for value, expected := range cases {
+		normalized, err := normalizeTime(value)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, normalized, value)
+	}
+
+	_, err := normalizeTime("ontem")
+	assert.Error(t, err)
+}
+
+func TestMigrations(t *testing.T) {
+	db := store.NewMemory("", 0)
+	require.NoError(t, db.Write(json.RawMessage(file)))
+	runner := NewMigrationRunner(db)
+
+	_, err := runner.Run(false)
+	require.NoError(t, err)
+
+	var us []User
+	require.NoError(t, db.Read(&us))
+	assert.Equal(t, "2019-02-01T00:00:00Z", us[0].CreatedAt)
+	assert.Equal(t, "2019-02-01T00:00:00Z", us[0].UpdatedAt)
+	assert.Equal(t, "2024-04-12T11:04:19-03:00", us[1].CreatedAt)
+	assert.Equal(t, uint(1), us[0].Version)
+
+	current, pending, err := runner.Status()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, current)
+	assert.Empty(t, pending)
+}
@@ -0,0 +1,329 @@
+package users
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Duarte64/go-web-meli/pkg/store"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Limits of the passwords a User may have.
+const (
+	MinPasswordLength = 10
+	MaxPasswordLength = 128
+	// MaxBcryptPasswordBytes is as much of a password as bcrypt can hash.
+	MaxBcryptPasswordBytes = 72
+	// PasswordResetTTL is how long a reset token can be used.
+	PasswordResetTTL = time.Hour
+)
+
+// PasswordAlgorithm is how new passwords are hashed. Hashes made with the
+// other one are still accepted and replaced on the next login.
+type PasswordAlgorithm string
+
+const (
+	Argon2id PasswordAlgorithm = "argon2id"
+	Bcrypt   PasswordAlgorithm = "bcrypt"
+)
+
+// OpPassword is the audit operation of a password change, its entry never
+// has changes.
+const OpPassword OperationType = "password"
+
+var (
+	ErrInvalidCredentials = errors.New("email ou senha inválidos")
+	ErrInvalidResetToken  = errors.New("token de redefinição inválido ou expirado")
+)
+
+// Argon2id parameters, the OWASP recommended minimum.
+const (
+	argonTime    = 2
+	argonMemory  = 19 * 1024
+	argonThreads = 1
+	argonKeyLen  = 32
+)
+
+// credential is kept apart from the user so that the hash is never part of
+// the User JSON. ID is the ID of the user.
+type credential struct {
+	ID             uint   `json:"id"`
+	Hash           string `json:"hash"`
+	ResetHash      string `json:"reset_hash,omitempty"`
+	ResetExpiresAt string `json:"reset_expires_at,omitempty"`
+	UpdatedAt      string `json:"updated_at"`
+}
+
+func (c credential) GetID() uint {
+	return c.ID
+}
+
+func hashPassword(alg PasswordAlgorithm, password string) (string, error) {
+	if alg == Bcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		return string(hash), err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argonMemory, argonTime, argonThreads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// checkPassword tells whether password matches hash and which algorithm
+// made the hash.
+func checkPassword(hash, password string) (bool, PasswordAlgorithm) {
+	if strings.HasPrefix(hash, "$2") {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil, Bcrypt
+	}
+	var version int
+	var memory uint32
+	var iterations uint32
+	var threads uint8
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != string(Argon2id) {
+		return false, ""
+	}
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, ""
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil {
+		return false, ""
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, ""
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, ""
+	}
+	other := argon2.IDKey([]byte(password), salt, iterations, memory, threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, Argon2id
+}
+
+// validatePassword checks password against the password policy: its
+// length, that it mixes letters and digits and that it does not contain
+// the name, lastname or email of u. Passwords hashed by alg bcrypt are also
+// limited to MaxBcryptPasswordBytes.
+func validatePassword(u User, password string, alg PasswordAlgorithm) error {
+	v := &validator{}
+	if n := utf8.RuneCountInString(password); n < MinPasswordLength || n > MaxPasswordLength {
+		v.add("password", fmt.Sprintf("deve ter entre %d e %d caracteres", MinPasswordLength, MaxPasswordLength))
+	} else if alg == Bcrypt && len(password) > MaxBcryptPasswordBytes {
+		v.add("password", fmt.Sprintf("deve ter no máximo %d bytes", MaxBcryptPasswordBytes))
+	}
+	letter := strings.IndexFunc(password, unicode.IsLetter) >= 0
+	digit := strings.IndexFunc(password, unicode.IsDigit) >= 0
+	if !letter || !digit {
+		v.add("password", "deve ter letras e números")
+	}
+	lower := strings.ToLower(password)
+	local, _, _ := strings.Cut(u.Email, "@")
+	for _, s := range []string{u.Name, u.Lastname, local} {
+		if s = strings.ToLower(strings.TrimSpace(s)); len(s) >= 3 && strings.Contains(lower, s) {
+			v.add("password", "não deve conter o nome ou o email do usuário")
+			break
+		}
+	}
+	return v.err()
+}
+
+func (s *service) SetPassword(id uint, password string) error {
+	u, err := s.repository.GetById(id)
+	if err != nil {
+		return err
+	}
+	return s.setPassword(u, password)
+}
+
+func (s *service) setPassword(u User, password string) error {
+	if err := validatePassword(u, password, s.passwordAlgorithm); err != nil {
+		return err
+	}
+	hash, err := hashPassword(s.passwordAlgorithm, password)
+	if err != nil {
+		return err
+	}
+	// Setting the password also discards any reset token.
+	if err := s.credentials.Put(credential{ID: u.ID, Hash: hash, UpdatedAt: now()}); err != nil {
+		return err
+	}
+	s.record(OpPassword, u.ID, &u, &u)
+	return nil
+}
+
+func (s *service) ChangePassword(id uint, current, password string) error {
+	u, err := s.repository.GetById(id)
+	if err != nil {
+		return err
+	}
+	c, err := s.credentials.Get(id)
+	if errors.Is(err, store.ErrNotFound) {
+		return ErrInvalidCredentials
+	}
+	if err != nil {
+		return err
+	}
+	if ok, _ := checkPassword(c.Hash, current); !ok {
+		return ErrInvalidCredentials
+	}
+	if current == password {
+		return &ValidationError{Fields: []FieldError{{Field: "password", Message: "deve ser diferente da senha atual"}}}
+	}
+	return s.setPassword(u, password)
+}
+
+var (
+	// dummyHash is checked when there is no user to check the password of,
+	// so that unknown emails take as long as wrong passwords.
+	dummyHash     string
+	dummyHashOnce sync.Once
+)
+
+func (s *service) Authenticate(email, password string) (User, error) {
+	page, err := s.repository.GetAll(Query{Email: email})
+	if err != nil {
+		return User{}, err
+	}
+	var c credential
+	if len(page.Users) == 1 && page.Users[0].Active {
+		if c, err = s.credentials.Get(page.Users[0].ID); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return User{}, err
+		}
+	}
+	if c.Hash == "" {
+		dummyHashOnce.Do(func() { dummyHash, _ = hashPassword(Argon2id, "dummy password 0") })
+		checkPassword(dummyHash, password)
+		return User{}, ErrInvalidCredentials
+	}
+	ok, alg := checkPassword(c.Hash, password)
+	if !ok {
+		return User{}, ErrInvalidCredentials
+	}
+	if alg != s.passwordAlgorithm {
+		if hash, err := hashPassword(s.passwordAlgorithm, password); err == nil {
+			_, _ = s.credentials.Modify(c.ID, func(c *credential) error {
+				c.Hash = hash
+				return nil
+			})
+		}
+	}
+	return page.Users[0], nil
+}
+
+func (s *service) PasswordResetToken(id uint) (string, time.Time, error) {
+	if _, err := s.repository.GetById(id); err != nil {
+		return "", time.Time{}, err
+	}
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", time.Time{}, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(random)
+	expiresAt := time.Now().Add(PasswordResetTTL).Truncate(time.Second)
+
+	c, err := s.credentials.Get(id)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return "", time.Time{}, err
+	}
+	// Users without a password get one through a reset as well.
+	c.ID, c.ResetHash, c.ResetExpiresAt = id, resetHash(token), expiresAt.Format(time.RFC3339)
+	if err := s.credentials.Put(c); err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+func (s *service) ResetPassword(token, password string) (User, error) {
+	list, err := s.credentials.List()
+	if err != nil {
+		return User{}, err
+	}
+	hash := resetHash(token)
+	var id uint
+	for _, c := range list {
+		if c.resets(hash) {
+			id = c.ID
+			break
+		}
+	}
+	if id == 0 {
+		return User{}, ErrInvalidResetToken
+	}
+	u, err := s.repository.GetById(id)
+	var notFoundErr *NotFoundError
+	if errors.As(err, &notFoundErr) {
+		return User{}, ErrInvalidResetToken
+	}
+	if err != nil {
+		return User{}, err
+	}
+	if err := validatePassword(u, password, s.passwordAlgorithm); err != nil {
+		return User{}, err
+	}
+	passwordHash, err := hashPassword(s.passwordAlgorithm, password)
+	if err != nil {
+		return User{}, err
+	}
+	// The token is checked again in the write that clears it, so that two
+	// requests with the same token can't both use it.
+	_, err = s.credentials.Modify(id, func(c *credential) error {
+		if !c.resets(hash) {
+			return ErrInvalidResetToken
+		}
+		*c = credential{ID: c.ID, Hash: passwordHash, UpdatedAt: now()}
+		return nil
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		return User{}, ErrInvalidResetToken
+	}
+	if err != nil {
+		return User{}, err
+	}
+	s.record(OpPassword, u.ID, &u, &u)
+	return u, nil
+}
+
+// resets tells whether c has the unexpired reset token of hash.
+func (c credential) resets(hash string) bool {
+	if c.ResetHash == "" || subtle.ConstantTimeCompare([]byte(c.ResetHash), []byte(hash)) != 1 {
+		return false
+	}
+	expires, err := time.Parse(time.RFC3339, c.ResetExpiresAt)
+	return err == nil && time.Now().Before(expires)
+}
+
+func resetHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// purgeCredentials drops the credentials of the users that no longer
+// exist, so that a later user given the same ID doesn't inherit them.
+func (s *service) purgeCredentials() error {
+	ids := map[uint]bool{}
+	for _, trashed := range []bool{false, true} {
+		if err := s.Export(Query{Trashed: trashed}, func(u User) error {
+			ids[u.ID] = true
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	_, err := s.credentials.DeleteFunc(func(c credential) bool { return !ids[c.ID] })
+	return err
+}
@@ -0,0 +1,144 @@
+package users
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Duarte64/go-web-meli/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashPassword(t *testing.T) {
+	for _, alg := range []PasswordAlgorithm{Argon2id, Bcrypt} {
+		hash, err := hashPassword(alg, "correct horse 1")
+		require.NoError(t, err)
+		ok, got := checkPassword(hash, "correct horse 1")
+		assert.True(t, ok, alg)
+		assert.Equal(t, alg, got)
+		ok, _ = checkPassword(hash, "correct horse 2")
+		assert.False(t, ok, alg)
+	}
+	ok, _ := checkPassword("plain", "plain")
+	assert.False(t, ok)
+}
+
+func TestValidatePassword(t *testing.T) {
+	u := User{Name: "Gabriel", Lastname: "Duarte", Email: "gdu@meli.com"}
+	assert.NoError(t, validatePassword(u, "tr0ub4dor&3x", Argon2id))
+	for _, password := range []string{"short1", "onlyletters", "1234567890", "gabriel2024!", "xGDU12345678", strings.Repeat("a1", 65)} {
+		assert.Error(t, validatePassword(u, password, Argon2id), password)
+	}
+	// bcrypt can't hash more than 72 bytes, which the policy would allow.
+	long := strings.Repeat("b2", 40)
+	assert.NoError(t, validatePassword(u, long, Argon2id))
+	assert.Error(t, validatePassword(u, long, Bcrypt))
+	assert.NoError(t, validatePassword(u, long[:MaxBcryptPasswordBytes], Bcrypt))
+}
+
+func TestServicePasswords(t *testing.T) {
+	credentials := store.NewMemory("", 0)
+	s := NewServiceWithCredentials(NewRepository(store.NewMemory("", 0)), nil, nil, credentials, Argon2id)
+	u, err := s.Store("Gabriel", "Duarte", "gabriel@meli.com", 30, 1.8, true)
+	require.NoError(t, err)
+
+	_, err = s.Authenticate(u.Email, "tr0ub4dor&3x")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	var validationErr *ValidationError
+	assert.ErrorAs(t, s.SetPassword(u.ID, "weak"), &validationErr)
+	require.NoError(t, s.SetPassword(u.ID, "tr0ub4dor&3x"))
+	got, err := s.Authenticate("GABRIEL@meli.com", "tr0ub4dor&3x")
+	require.NoError(t, err)
+	assert.Equal(t, u.ID, got.ID)
+	_, err = s.Authenticate(u.Email, "tr0ub4dor&3y")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = s.Authenticate("nobody@meli.com", "tr0ub4dor&3x")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	// The hash is never part of the user.
+	data, _ := json.Marshal(got)
+	assert.NotContains(t, string(data), "argon2id")
+
+	assert.ErrorIs(t, s.ChangePassword(u.ID, "wrong", "n3w passphrase"), ErrInvalidCredentials)
+	assert.ErrorAs(t, s.ChangePassword(u.ID, "tr0ub4dor&3x", "tr0ub4dor&3x"), &validationErr)
+	require.NoError(t, s.ChangePassword(u.ID, "tr0ub4dor&3x", "n3w passphrase"))
+	_, err = s.Authenticate(u.Email, "n3w passphrase")
+	assert.NoError(t, err)
+
+	token, expiresAt, err := s.PasswordResetToken(u.ID)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(PasswordResetTTL), expiresAt, time.Minute)
+	_, err = s.ResetPassword("other", "r3set passphrase")
+	assert.ErrorIs(t, err, ErrInvalidResetToken)
+	_, err = s.ResetPassword(token, "weak")
+	assert.ErrorAs(t, err, &validationErr)
+	got, err = s.ResetPassword(token, "r3set passphrase")
+	require.NoError(t, err)
+	assert.Equal(t, u.ID, got.ID)
+	// A token is only used once.
+	_, err = s.ResetPassword(token, "an0ther passphrase")
+	assert.ErrorIs(t, err, ErrInvalidResetToken)
+	_, err = s.Authenticate(u.Email, "r3set passphrase")
+	assert.NoError(t, err)
+
+	// Deleted users can't log in and purged ones lose their password.
+	require.NoError(t, s.Delete(u.ID, 0))
+	_, err = s.Authenticate(u.Email, "r3set passphrase")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	n, err := s.Purge(-time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	var left []credential
+	require.NoError(t, credentials.Read(&left))
+	assert.Empty(t, left)
+}
+
+func TestServiceResetPasswordOnce(t *testing.T) {
+	s := NewServiceWithCredentials(NewRepository(store.NewMemory("", 0)), nil, nil, store.NewMemory("", 0), Argon2id)
+	u, err := s.Store("Gabriel", "Duarte", "gabriel@meli.com", 30, 1.8, true)
+	require.NoError(t, err)
+	token, _, err := s.PasswordResetToken(u.ID)
+	require.NoError(t, err)
+
+	const n = 8
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			_, err := s.ResetPassword(token, fmt.Sprintf("r3set passphrase %d", i))
+			errs <- err
+		}(i)
+	}
+	succeeded := 0
+	for i := 0; i < n; i++ {
+		if err := <-errs; err == nil {
+			succeeded++
+		} else {
+			assert.ErrorIs(t, err, ErrInvalidResetToken)
+		}
+	}
+	assert.Equal(t, 1, succeeded)
+}
+
+func TestServicePasswordRehash(t *testing.T) {
+	credentials := store.NewMemory("", 0)
+	repo := NewRepository(store.NewMemory("", 0))
+	old := NewServiceWithCredentials(repo, nil, nil, credentials, Bcrypt)
+	u, err := old.Store("Gabriel", "Duarte", "gabriel@meli.com", 30, 1.8, true)
+	require.NoError(t, err)
+	var validationErr *ValidationError
+	assert.ErrorAs(t, old.SetPassword(u.ID, strings.Repeat("tr0ub4dor&3x", 7)), &validationErr)
+	require.NoError(t, old.SetPassword(u.ID, "tr0ub4dor&3x"))
+
+	s := NewServiceWithCredentials(repo, nil, nil, credentials, Argon2id)
+	_, err = s.Authenticate(u.Email, "tr0ub4dor&3x")
+	require.NoError(t, err)
+	var list []credential
+	require.NoError(t, credentials.Read(&list))
+	assert.True(t, strings.HasPrefix(list[0].Hash, "$argon2id$"))
+	_, err = s.Authenticate(u.Email, "tr0ub4dor&3x")
+	assert.NoError(t, err)
+}
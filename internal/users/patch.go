@@ -0,0 +1,79 @@
+package users
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/Duarte64/go-web-meli/pkg/jsonpatch"
+)
+
+// PatchType is the media type of a patch document.
+type PatchType string
+
+const (
+	MergePatch PatchType = "application/merge-patch+json"
+	JSONPatch  PatchType = "application/json-patch+json"
+)
+
+// PatchError is returned for a patch that cannot be applied, it wraps
+// jsonpatch.ErrTestFailed when a test operation did not match.
+type PatchError struct {
+	Err error
+}
+
+func (e *PatchError) Error() string {
+	return "patch inválido: " + e.Err.Error()
+}
+
+func (e *PatchError) Unwrap() error {
+	return e.Err
+}
+
+// applyPatch returns u with the patch applied. The fields kept by the
+// repository may appear in the patch but not change.
+func applyPatch(u User, patchType PatchType, patch []byte) (User, error) {
+	doc, err := json.Marshal(u)
+	if err != nil {
+		return User{}, err
+	}
+	var out []byte
+	switch patchType {
+	case MergePatch:
+		out, err = jsonpatch.Merge(doc, patch)
+	case JSONPatch:
+		out, err = jsonpatch.Apply(doc, patch)
+	default:
+		return User{}, &PatchError{Err: jsonpatch.ErrInvalidPatch}
+	}
+	if err != nil {
+		return User{}, &PatchError{Err: err}
+	}
+
+	var patched User
+	dec := json.NewDecoder(bytes.NewReader(out))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&patched); err != nil {
+		return User{}, &PatchError{Err: err}
+	}
+
+	v := &validator{}
+	if patched.ID != u.ID {
+		v.add("id", "não pode ser alterado")
+	}
+	if patched.CreatedAt != u.CreatedAt {
+		v.add("created_at", "não pode ser alterado")
+	}
+	if patched.UpdatedAt != u.UpdatedAt {
+		v.add("updated_at", "não pode ser alterado")
+	}
+	if patched.Version != u.Version {
+		v.add("version", "não pode ser alterado")
+	}
+	if patched.DeletedAt != u.DeletedAt {
+		v.add("deleted_at", "não pode ser alterado")
+	}
+	if err := v.err(); err != nil {
+		return User{}, err
+	}
+	return patched, nil
+}
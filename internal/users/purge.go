@@ -0,0 +1,26 @@
+package users
+
+import (
+	"context"
+	"time"
+)
+
+// RunPurge empties the trash of the users deleted more than retention ago
+// every interval until ctx is done, errors are sent to onError.
+func RunPurge(ctx context.Context, s Service, retention, interval time.Duration, onError func(error)) {
+	if retention <= 0 || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := s.Purge(retention); err != nil && onError != nil {
+				onError(err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package users
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MaxLimit is the largest page a Query may ask for.
+const MaxLimit = 1000
+
+// Query selects, orders and pages the users returned by GetAll. Zero values
+// do not filter; a zero Limit returns every matching user.
+type Query struct {
+	// Name and Lastname match the start of the field, ignoring case.
+	Name     string
+	Lastname string
+	// Email matches the whole field, ignoring case.
+	Email       string
+	MinAge      *int
+	MaxAge      *int
+	MinHeight   *float64
+	MaxHeight   *float64
+	Active      *bool
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	// Trashed selects the deleted users instead of the live ones.
+	Trashed bool
+
+	// Sort orders by each key in turn, the ID always breaks ties.
+	Sort   []SortKey
+	Limit  int
+	Offset int
+	// Cursor continues from the page that returned it, it cannot be used
+	// with Offset.
+	Cursor string
+}
+
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+// Page is one page of the users matching a Query. Total counts every match
+// and Offset is the position of the first user of the page among them.
+// Next and Prev are the cursors of the neighbouring pages, empty when there
+// is none.
+type Page struct {
+	Users  []User
+	Total  int
+	Offset int
+	Next   string
+	Prev   string
+}
+
+type InvalidQueryError struct {
+	Message string
+}
+
+func (e *InvalidQueryError) Error() string {
+	return e.Message
+}
+
+type sortField struct {
+	compare func(a, b User) int
+	// column is the SQL expression the field is ordered by.
+	column string
+	value  func(u User) interface{}
+}
+
+var sortFields = map[string]sortField{
+	"id":         {func(a, b User) int { return cmp.Compare(a.ID, b.ID) }, "id", func(u User) interface{} { return u.ID }},
+	"name":       {func(a, b User) int { return cmp.Compare(a.Name, b.Name) }, "name", func(u User) interface{} { return u.Name }},
+	"lastname":   {func(a, b User) int { return cmp.Compare(a.Lastname, b.Lastname) }, "lastname", func(u User) interface{} { return u.Lastname }},
+	"email":      {func(a, b User) int { return cmp.Compare(a.Email, b.Email) }, "email", func(u User) interface{} { return u.Email }},
+	"age":        {func(a, b User) int { return cmp.Compare(a.Age, b.Age) }, "age", func(u User) interface{} { return u.Age }},
+	"height":     {func(a, b User) int { return cmp.Compare(a.Height, b.Height) }, "height", func(u User) interface{} { return u.Height }},
+	"active":     {func(a, b User) int { return cmp.Compare(boolInt(a.Active), boolInt(b.Active)) }, "active", func(u User) interface{} { return u.Active }},
+	"created_at": {func(a, b User) int { return compareTimes(a.CreatedAt, b.CreatedAt) }, "julianday(created_at)", func(u User) interface{} { return u.CreatedAt }},
+	"updated_at": {func(a, b User) int { return compareTimes(a.UpdatedAt, b.UpdatedAt) }, "julianday(updated_at)", func(u User) interface{} { return u.UpdatedAt }},
+	"deleted_at": {func(a, b User) int { return compareTimes(a.DeletedAt, b.DeletedAt) }, "julianday(deleted_at)", func(u User) interface{} { return u.DeletedAt }},
+}
+
+// ParseSort reads a comma separated list of fields, each optionally
+// prefixed by "-" for descending order, e.g. "-age,name".
+func ParseSort(s string) ([]SortKey, error) {
+	var keys []SortKey
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		key := SortKey{Field: strings.TrimPrefix(field, "-"), Desc: strings.HasPrefix(field, "-")}
+		if _, ok := sortFields[key.Field]; !ok {
+			return nil, &InvalidQueryError{"campo de ordenação inválido: " + key.Field}
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func formatSort(keys []SortKey) string {
+	fields := make([]string, len(keys))
+	for i, k := range keys {
+		fields[i] = k.Field
+		if k.Desc {
+			fields[i] = "-" + k.Field
+		}
+	}
+	return strings.Join(fields, ",")
+}
+
+// cursor marks the user a page ends (or, with Before, starts) at. Only the
+// fields the users are sorted by are kept.
+type cursor struct {
+	Before bool                   `json:"b,omitempty"`
+	Sort   string                 `json:"s"`
+	User   map[string]interface{} `json:"u"`
+}
+
+func encodeCursor(u User, keys []SortKey, before bool) (string, error) {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return "", err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return "", err
+	}
+	c := cursor{Before: before, Sort: formatSort(keys), User: map[string]interface{}{}}
+	for _, k := range keys {
+		c.User[k.Field] = fields[k.Field]
+	}
+	data, err = json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeCursor(s string, keys []SortKey) (User, bool, error) {
+	invalid := &InvalidQueryError{"cursor inválido"}
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return User{}, false, invalid
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil || c.Sort != formatSort(keys) {
+		return User{}, false, invalid
+	}
+	data, err = json.Marshal(c.User)
+	if err != nil {
+		return User{}, false, invalid
+	}
+	var u User
+	if err := json.Unmarshal(data, &u); err != nil {
+		return User{}, false, invalid
+	}
+	return u, c.Before, nil
+}
+
+// sortKeys validates the query and returns its sort keys with the ID
+// appended as the last tie breaker.
+func (q Query) sortKeys() ([]SortKey, error) {
+	switch {
+	case q.Limit < 0 || q.Limit > MaxLimit:
+		return nil, &InvalidQueryError{"limit deve estar entre 1 e 1000"}
+	case q.Offset < 0:
+		return nil, &InvalidQueryError{"offset não pode ser negativo"}
+	case q.Offset > 0 && q.Cursor != "":
+		return nil, &InvalidQueryError{"offset e cursor não podem ser usados juntos"}
+	case q.MinAge != nil && q.MaxAge != nil && *q.MinAge > *q.MaxAge,
+		q.MinHeight != nil && q.MaxHeight != nil && *q.MinHeight > *q.MaxHeight,
+		q.CreatedFrom != nil && q.CreatedTo != nil && q.CreatedFrom.After(*q.CreatedTo):
+		return nil, &InvalidQueryError{"intervalo inválido"}
+	}
+
+	keys := make([]SortKey, 0, len(q.Sort)+1)
+	hasID := false
+	for _, k := range q.Sort {
+		if _, ok := sortFields[k.Field]; !ok {
+			return nil, &InvalidQueryError{"campo de ordenação inválido: " + k.Field}
+		}
+		hasID = hasID || k.Field == "id"
+		keys = append(keys, k)
+	}
+	if !hasID {
+		keys = append(keys, SortKey{Field: "id"})
+	}
+	return keys, nil
+}
+
+func (q Query) matches(u User) bool {
+	switch {
+	case q.Trashed != (u.DeletedAt != ""),
+		q.Name != "" && !hasPrefixFold(u.Name, q.Name),
+		q.Lastname != "" && !hasPrefixFold(u.Lastname, q.Lastname),
+		q.Email != "" && !strings.EqualFold(u.Email, q.Email),
+		q.MinAge != nil && u.Age < *q.MinAge,
+		q.MaxAge != nil && u.Age > *q.MaxAge,
+		q.MinHeight != nil && u.Height < *q.MinHeight,
+		q.MaxHeight != nil && u.Height > *q.MaxHeight,
+		q.Active != nil && u.Active != *q.Active:
+		return false
+	}
+	if q.CreatedFrom != nil || q.CreatedTo != nil {
+		createdAt, err := time.Parse(time.RFC3339, u.CreatedAt)
+		if err != nil {
+			return false
+		}
+		if q.CreatedFrom != nil && createdAt.Before(*q.CreatedFrom) || q.CreatedTo != nil && createdAt.After(*q.CreatedTo) {
+			return false
+		}
+	}
+	return true
+}
+
+func compareUsers(a, b User, keys []SortKey) int {
+	for _, k := range keys {
+		c := sortFields[k.Field].compare(a, b)
+		if k.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// applyQuery runs q over every user in memory, for the repositories that
+// cannot push it down to their storage.
+func applyQuery(all []User, q Query) (Page, error) {
+	keys, err := q.sortKeys()
+	if err != nil {
+		return Page{}, err
+	}
+
+	us := []User{}
+	for _, u := range all {
+		if q.matches(u) {
+			us = append(us, u)
+		}
+	}
+	sort.SliceStable(us, func(i, j int) bool { return compareUsers(us[i], us[j], keys) < 0 })
+
+	start, end := q.Offset, len(us)
+	if q.Cursor != "" {
+		at, before, err := decodeCursor(q.Cursor, keys)
+		if err != nil {
+			return Page{}, err
+		}
+		// The first user sorted after the cursor, or the cursor itself when
+		// paging backwards.
+		i := sort.Search(len(us), func(i int) bool {
+			c := compareUsers(us[i], at, keys)
+			return c > 0 || before && c == 0
+		})
+		start = i
+		if before {
+			end = i
+			start = 0
+			if q.Limit > 0 && end-q.Limit > 0 {
+				start = end - q.Limit
+			}
+		}
+	}
+	start = min(start, len(us))
+	if q.Limit > 0 && start+q.Limit < end {
+		end = start + q.Limit
+	}
+	return newPage(us[start:end], len(us), start, keys)
+}
+
+func newPage(us []User, total, offset int, keys []SortKey) (Page, error) {
+	page := Page{Users: us, Total: total, Offset: offset}
+	if len(us) == 0 {
+		return page, nil
+	}
+	var err error
+	if offset+len(us) < total {
+		if page.Next, err = encodeCursor(us[len(us)-1], keys, false); err != nil {
+			return Page{}, err
+		}
+	}
+	if offset > 0 {
+		if page.Prev, err = encodeCursor(us[0], keys, true); err != nil {
+			return Page{}, err
+		}
+	}
+	return page, nil
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return strings.HasPrefix(strings.ToLower(s), strings.ToLower(prefix))
+}
+
+// compareTimes orders RFC3339 timestamps by instant, falling back to the
+// text for anything that does not parse.
+func compareTimes(a, b string) int {
+	ta, errA := time.Parse(time.RFC3339, a)
+	tb, errB := time.Parse(time.RFC3339, b)
+	if errA != nil || errB != nil {
+		return cmp.Compare(a, b)
+	}
+	return ta.Compare(tb)
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,122 @@
+package users
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Duarte64/go-web-meli/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// queryRepositories returns the in memory and the SQL implementation with
+// the same users, every query must give the same result on both.
+func queryRepositories(t *testing.T) map[string]Repository {
+	db := store.NewSQLite(filepath.Join(t.TempDir(), "users.db"))
+	t.Cleanup(func() { db.Close() })
+	conn, err := db.DB()
+	require.NoError(t, err)
+	sqlRepository, err := NewSQLRepository(conn)
+	require.NoError(t, err)
+
+	repositories := map[string]Repository{
+		"memory": NewRepository(store.NewMemory("", 0)),
+		"sql":    sqlRepository,
+	}
+	seed := []User{
+		{Name: "Ana", Lastname: "Silva", Email: "ana@example.com", Age: 30, Height: 1.6, Active: true, CreatedAt: "2024-01-01T10:00:00Z"},
+		{Name: "André", Lastname: "Souza", Email: "andre@example.com", Age: 25, Height: 1.8, Active: false, CreatedAt: "2024-02-01T10:00:00-03:00"},
+		{Name: "Bruno", Lastname: "Silva", Email: "Bruno@Example.com", Age: 30, Height: 1.75, Active: true, CreatedAt: "2024-03-01T10:00:00Z"},
+		{Name: "Carla", Lastname: "Lima", Email: "carla@example.com", Age: 41, Height: 1.7, Active: true, CreatedAt: "2024-04-01T10:00:00Z"},
+		{Name: "anabel", Lastname: "Costa", Email: "anabel@example.com", Age: 19, Height: 1.55, Active: false, CreatedAt: "2024-05-01T10:00:00Z"},
+	}
+	for _, r := range repositories {
+		for i, u := range seed {
+			_, err := r.Store(uint(i+1), u.Name, u.Lastname, u.Email, u.CreatedAt, u.Age, u.Height, u.Active)
+			require.NoError(t, err)
+		}
+	}
+	return repositories
+}
+
+func ids(us []User) []uint {
+	out := []uint{}
+	for _, u := range us {
+		out = append(out, u.ID)
+	}
+	return out
+}
+
+func TestQueryFilters(t *testing.T) {
+	age := 30
+	active := true
+	from := time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		query Query
+		want  []uint
+	}{
+		{"name prefix ignores case", Query{Name: "ANA"}, []uint{1, 5}},
+		{"email ignores case", Query{Email: "bruno@example.com"}, []uint{3}},
+		{"age range", Query{MinAge: &age, MaxAge: &age}, []uint{1, 3}},
+		{"active", Query{Active: &active, Lastname: "silva"}, []uint{1, 3}},
+		{"created from uses the instant", Query{CreatedFrom: &from}, []uint{2, 3, 4, 5}},
+		{"multi key sort", Query{Sort: []SortKey{{Field: "age", Desc: true}, {Field: "name"}}}, []uint{4, 1, 3, 2, 5}},
+	}
+	for name, r := range queryRepositories(t) {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				page, err := r.GetAll(tt.query)
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, ids(page.Users))
+				assert.Equal(t, len(tt.want), page.Total)
+			})
+		}
+	}
+}
+
+func TestQueryPagination(t *testing.T) {
+	sortByHeight := []SortKey{{Field: "height", Desc: true}}
+	for name, r := range queryRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			page, err := r.GetAll(Query{Sort: sortByHeight, Limit: 2, Offset: 2})
+			require.NoError(t, err)
+			assert.Equal(t, []uint{4, 1}, ids(page.Users))
+			assert.Equal(t, 5, page.Total)
+			assert.Equal(t, 2, page.Offset)
+
+			first, err := r.GetAll(Query{Sort: sortByHeight, Limit: 2})
+			require.NoError(t, err)
+			assert.Equal(t, []uint{2, 3}, ids(first.Users))
+			assert.Empty(t, first.Prev)
+
+			second, err := r.GetAll(Query{Sort: sortByHeight, Limit: 2, Cursor: first.Next})
+			require.NoError(t, err)
+			assert.Equal(t, []uint{4, 1}, ids(second.Users))
+			assert.Equal(t, 2, second.Offset)
+
+			last, err := r.GetAll(Query{Sort: sortByHeight, Limit: 2, Cursor: second.Next})
+			require.NoError(t, err)
+			assert.Equal(t, []uint{5}, ids(last.Users))
+			assert.Empty(t, last.Next)
+
+			back, err := r.GetAll(Query{Sort: sortByHeight, Limit: 2, Cursor: last.Prev})
+			require.NoError(t, err)
+			assert.Equal(t, []uint{4, 1}, ids(back.Users))
+			assert.Equal(t, 2, back.Offset)
+
+			_, err = r.GetAll(Query{Limit: 2, Cursor: first.Next})
+			assert.IsType(t, &InvalidQueryError{}, err)
+		})
+	}
+}
+
+func TestParseSort(t *testing.T) {
+	keys, err := ParseSort("-age, name")
+	assert.NoError(t, err)
+	assert.Equal(t, []SortKey{{Field: "age", Desc: true}, {Field: "name"}}, keys)
+
+	_, err = ParseSort("password")
+	assert.IsType(t, &InvalidQueryError{}, err)
+}
@@ -1,11 +1,29 @@
 package users
 
 import (
+	"errors"
+	"fmt"
+	"time"
+
 	"github.com/Duarte64/go-web-meli/pkg/store"
 )
 
 type repository struct {
-	db store.Store
+	db      store.Store
+	users   store.Collection[User]
+	history store.Collection[snapshot]
+}
+
+// snapshot is a past version of a user. A write discarded after its
+// snapshot was taken may leave several snapshots of one version, the
+// latest is the one that was kept.
+type snapshot struct {
+	ID   uint `json:"id"`
+	User User `json:"user"`
+}
+
+func (s snapshot) GetID() uint {
+	return s.ID
 }
 
 type NotFoundError struct{}
@@ -14,134 +32,290 @@ func (n *NotFoundError) Error() string {
 	return "Usuário não encontrado"
 }
 
+// ConflictError is returned by a write made against a version of the user
+// that is no longer the current one.
+type ConflictError struct {
+	ID       uint
+	Expected uint
+	Actual   uint
+}
+
+func (c *ConflictError) Error() string {
+	return fmt.Sprintf("Usuário %d foi alterado: versão esperada %d, versão atual %d", c.ID, c.Expected, c.Actual)
+}
+
+// VersionNotFoundError is returned for a version of the user that never
+// existed or was not kept.
+type VersionNotFoundError struct {
+	ID      uint
+	Version uint
+}
+
+func (v *VersionNotFoundError) Error() string {
+	return fmt.Sprintf("Versão %d do usuário %d não encontrada", v.Version, v.ID)
+}
+
+// Repository writes that take a version only apply when the user is still
+// at that version, a zero version skips the check. Every write increments
+// the version.
 type Repository interface {
-	GetAll() ([]User, error)
+	GetAll(q Query) (Page, error)
 	GetById(id uint) (User, error)
-	Delete(id uint) error
+	// GetVersion returns the user as it was at version, every version a
+	// write replaces is kept until the user is purged.
+	GetVersion(id, version uint) (User, error)
+	// Delete moves the user to the trash.
+	Delete(id, version uint) error
+	Restore(id uint) (User, error)
+	// Purge permanently removes the users deleted before the given time.
+	Purge(deletedBefore time.Time) (int, error)
 	Store(id uint, name, lastname, email, createdAt string, age int, height float64, active bool) (User, error)
-	Update(id uint, name, lastname, email string, age int, height float64, active bool) (User, error)
-	Patch(id uint, lastname string, age int) (User, error)
+	// StoreAll creates every user, with consecutive IDs from the next free
+	// one, or none of them. Only the fields a Store takes are kept.
+	StoreAll(us []User) ([]User, error)
+	Update(id, version uint, name, lastname, email string, age int, height float64, active bool) (User, error)
+	// Modify calls fn with the stored user and saves what it leaves, in one
+	// atomic step. ID, CreatedAt, DeletedAt and Version cannot be changed by
+	// fn and UpdatedAt is set to the current time.
+	Modify(id, version uint, fn func(u *User) error) (User, error)
 	LastId() (uint, error)
+	// Transaction runs fn with a Repository whose changes are all saved when
+	// fn returns nil and all discarded otherwise.
+	Transaction(fn func(tx Repository) error) error
 }
 
+// NewRepository returns a Repository keeping the past versions of the
+// users in memory, they are lost when the process exits.
 func NewRepository(db store.Store) Repository {
+	return NewRepositoryWithHistory(db, store.NewMemory("", 0))
+}
+
+// NewRepositoryWithHistory returns a Repository keeping the past versions
+// of the users in history.
+func NewRepositoryWithHistory(db, history store.Store) Repository {
 	return &repository{
-		db: db,
+		db:      db,
+		users:   store.NewCollection[User](db),
+		history: store.NewCollection[snapshot](history),
+	}
+}
+
+// notFound translates the store sentinel into the error handlers look for.
+func notFound(err error) error {
+	if errors.Is(err, store.ErrNotFound) {
+		return &NotFoundError{}
 	}
+	return err
 }
 
 func (r *repository) LastId() (uint, error) {
-	var us []User
-	if err := r.db.Read(&us); err != nil {
+	next, err := r.users.NextID()
+	if err != nil {
 		return 0, err
 	}
-	if len(us) == 0 {
-		return 0, nil
-	}
+	return next - 1, nil
+}
 
-	maiorId := us[0].ID
-	for _, user := range us {
-		if user.ID > maiorId {
-			maiorId = user.ID
+func (r *repository) Store(id uint, name, lastname, email, createdAt string, age int, height float64, active bool) (User, error) {
+	return r.users.Create(func(next uint) User {
+		// The id was chosen before the lock was taken, a concurrent Store
+		// may already have used it.
+		if id < next {
+			id = next
 		}
-	}
-	return maiorId, nil
+		return User{
+			ID: id, Name: name, Lastname: lastname, Email: email, Age: age, Height: height, Active: active,
+			CreatedAt: createdAt, UpdatedAt: createdAt, Version: 1,
+		}
+	})
 }
 
-func (r *repository) Store(id uint, name, lastname, email, createdAt string, age int, height float64, active bool) (User, error) {
-	var us []User
-	if err := r.db.Read(&us); err != nil {
-		return User{}, err
+func (r *repository) StoreAll(us []User) ([]User, error) {
+	return r.users.CreateAll(func(next uint) []User {
+		created := make([]User, len(us))
+		for i, u := range us {
+			created[i] = User{
+				ID: next + uint(i), Name: u.Name, Lastname: u.Lastname, Email: u.Email, Age: u.Age, Height: u.Height, Active: u.Active,
+				CreatedAt: u.CreatedAt, UpdatedAt: u.CreatedAt, Version: 1,
+			}
+		}
+		return created
+	})
+}
+
+// checkLive fails for users in the trash and for users no longer at version.
+func checkLive(user *User, version uint) error {
+	if user.DeletedAt != "" {
+		return store.ErrNotFound
 	}
-	u := User{id, name, lastname, email, age, height, active, createdAt}
-	us = append(us, u)
-	if err := r.db.Write(us); err != nil {
-		return User{}, err
+	if version != 0 && user.Version != version {
+		return &ConflictError{ID: user.ID, Expected: version, Actual: user.Version}
 	}
-	return u, nil
+	return nil
 }
 
-func (r *repository) Update(id uint, name, lastname, email string, age int, height float64, active bool) (User, error) {
-	var us []User
-	if err := r.db.Read(&us); err != nil {
-		return User{}, err
+// keep saves the user as a past version, it is called before every write
+// that increments the version.
+func (r *repository) keep(u User) error {
+	_, err := r.history.Create(func(id uint) snapshot {
+		return snapshot{ID: id, User: u}
+	})
+	return err
+}
+
+func (r *repository) GetVersion(id, version uint) (User, error) {
+	u, err := r.users.Get(id)
+	if err != nil {
+		return User{}, notFound(err)
 	}
-	updatedUser := User{Name: name, Lastname: lastname, Email: email, Age: age, Height: height, Active: active}
-	for index, user := range us {
-		if user.ID == id {
-			updatedUser.ID = user.ID
-			updatedUser.CreatedAt = user.CreatedAt
-			us[index] = updatedUser
-			if err := r.db.Write(us); err != nil {
-				return User{}, err
-			} else {
-				return updatedUser, nil
+	if u.Version == version {
+		return u, nil
+	}
+	if version < u.Version {
+		snapshots, err := r.history.List()
+		if err != nil {
+			return User{}, err
+		}
+		for i := len(snapshots) - 1; i >= 0; i-- {
+			if s := snapshots[i].User; s.ID == id && s.Version == version {
+				return s, nil
 			}
 		}
 	}
-	return User{}, &NotFoundError{}
+	return User{}, &VersionNotFoundError{ID: id, Version: version}
 }
 
-func (r *repository) Delete(id uint) error {
-	var us []User
-	if err := r.db.Read(&us); err != nil {
-		return err
-	}
-	for index, user := range us {
-		if user.ID == id {
-			us = append(us[:index], us[index+1:]...)
-			if err := r.db.Write(us); err != nil {
-				return err
-			} else {
-				return nil
-			}
+func (r *repository) Update(id, version uint, name, lastname, email string, age int, height float64, active bool) (User, error) {
+	u, err := r.users.Modify(id, func(user *User) error {
+		if err := checkLive(user, version); err != nil {
+			return err
+		}
+		if err := r.keep(*user); err != nil {
+			return err
+		}
+		*user = User{
+			ID: user.ID, Name: name, Lastname: lastname, Email: email, Age: age, Height: height, Active: active,
+			CreatedAt: user.CreatedAt, UpdatedAt: now(), Version: user.Version + 1,
+		}
+		return nil
+	})
+	return u, notFound(err)
+}
+
+func (r *repository) Delete(id, version uint) error {
+	_, err := r.users.Modify(id, func(user *User) error {
+		if err := checkLive(user, version); err != nil {
+			return err
+		}
+		if err := r.keep(*user); err != nil {
+			return err
 		}
+		user.DeletedAt = now()
+		user.Version++
+		return nil
+	})
+	return notFound(err)
+}
+
+func (r *repository) Restore(id uint) (User, error) {
+	u, err := r.users.Modify(id, func(user *User) error {
+		if user.DeletedAt == "" {
+			return store.ErrNotFound
+		}
+		if err := r.keep(*user); err != nil {
+			return err
+		}
+		user.DeletedAt = ""
+		user.Version++
+		return nil
+	})
+	return u, notFound(err)
+}
+
+func (r *repository) Purge(deletedBefore time.Time) (int, error) {
+	purged := map[uint]bool{}
+	n, err := r.users.DeleteFunc(func(u User) bool {
+		if u.DeletedAt == "" {
+			return false
+		}
+		deletedAt, err := time.Parse(time.RFC3339, u.DeletedAt)
+		purged[u.ID] = err == nil && deletedAt.Before(deletedBefore)
+		return purged[u.ID]
+	})
+	if err != nil || n == 0 {
+		return n, err
 	}
-	return &NotFoundError{}
+	_, err = r.history.DeleteFunc(func(s snapshot) bool { return purged[s.User.ID] })
+	return n, err
 }
 
-func (r *repository) GetAll() ([]User, error) {
-	var us []User
-	if err := r.db.Read(&us); err != nil {
-		return []User{}, err
+func (r *repository) GetAll(q Query) (Page, error) {
+	us, err := r.users.List()
+	if err != nil {
+		return Page{}, err
 	}
-	return us, nil
+	return applyQuery(us, q)
 }
 
 func (r *repository) GetById(id uint) (User, error) {
-	var us []User
-	if err := r.db.Read(&us); err != nil {
-		return User{}, err
-	}
-	for _, user := range us {
-		if user.ID == id {
-			return user, nil
-		}
+	u, err := r.users.Get(id)
+	if err == nil && u.DeletedAt != "" {
+		return User{}, &NotFoundError{}
 	}
+	return u, notFound(err)
+}
 
-	return User{}, &NotFoundError{}
+func (r *repository) Modify(id, version uint, fn func(u *User) error) (User, error) {
+	u, err := r.users.Modify(id, func(user *User) error {
+		if err := checkLive(user, version); err != nil {
+			return err
+		}
+		changed := *user
+		if err := fn(&changed); err != nil {
+			return err
+		}
+		if err := r.keep(*user); err != nil {
+			return err
+		}
+		changed.ID, changed.CreatedAt, changed.DeletedAt = user.ID, user.CreatedAt, user.DeletedAt
+		changed.UpdatedAt = now()
+		changed.Version = user.Version + 1
+		*user = changed
+		return nil
+	})
+	return u, notFound(err)
 }
 
-func (r *repository) Patch(id uint, lastname string, age int) (User, error) {
+// Transaction runs fn against a copy of the users held in memory and saves
+// it in a single write, the store stays locked meanwhile.
+func (r *repository) Transaction(fn func(tx Repository) error) error {
 	var us []User
-	if err := r.db.Read(&us); err != nil {
-		return User{}, err
-	}
-	for index, user := range us {
-		if user.ID == id {
-			if lastname != "" {
-				user.Lastname = lastname
-			}
-			if age != 0 {
-				user.Age = age
-			}
-			us[index] = user
-			if err := r.db.Write(us); err != nil {
-				return User{}, err
-			} else {
-				return us[index], nil
-			}
+	return r.db.Update(&us, func() error {
+		copied := store.NewMemory("", 0)
+		if err := copied.Write(us); err != nil {
+			return err
 		}
-	}
-	return User{}, &NotFoundError{}
+		// The copy starts from the IDs the store already gave, and the ones it
+		// gives are saved before the users are.
+		last, err := store.LastID(r.db)
+		if err != nil {
+			return err
+		}
+		if err := store.AdvanceLastID(copied, last); err != nil {
+			return err
+		}
+		// Snapshots are saved right away, a discarded transaction only leaves
+		// snapshots of versions that are superseded by later ones.
+		tx := &repository{db: copied, users: store.NewCollection[User](copied), history: r.history}
+		if err := fn(tx); err != nil {
+			return err
+		}
+		if last, err = store.LastID(copied); err != nil {
+			return err
+		}
+		if err := store.AdvanceLastID(r.db, last); err != nil {
+			return err
+		}
+		return copied.Read(&us)
+	})
 }
@@ -1,23 +1,27 @@
 package users
 
-import mock "github.com/stretchr/testify/mock"
+import (
+	time "time"
+
+	mock "github.com/stretchr/testify/mock"
+)
 
 // MockRepository is an autogenerated mock type for the Repository type
 type MockRepository struct {
 	mock.Mock
 }
 
-// Delete provides a mock function with given fields: id
-func (_m *MockRepository) Delete(id uint) error {
-	ret := _m.Called(id)
+// Delete provides a mock function with given fields: id, version
+func (_m *MockRepository) Delete(id uint, version uint) error {
+	ret := _m.Called(id, version)
 
 	if len(ret) == 0 {
 		panic("no return value specified for Delete")
 	}
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(uint) error); ok {
-		r0 = rf(id)
+	if rf, ok := ret.Get(0).(func(uint, uint) error); ok {
+		r0 = rf(id, version)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -25,29 +29,27 @@ func (_m *MockRepository) Delete(id uint) error {
 	return r0
 }
 
-// GetAll provides a mock function with given fields:
-func (_m *MockRepository) GetAll() ([]User, error) {
-	ret := _m.Called()
+// GetAll provides a mock function with given fields: q
+func (_m *MockRepository) GetAll(q Query) (Page, error) {
+	ret := _m.Called(q)
 
 	if len(ret) == 0 {
 		panic("no return value specified for GetAll")
 	}
 
-	var r0 []User
+	var r0 Page
 	var r1 error
-	if rf, ok := ret.Get(0).(func() ([]User, error)); ok {
-		return rf()
+	if rf, ok := ret.Get(0).(func(Query) (Page, error)); ok {
+		return rf(q)
 	}
-	if rf, ok := ret.Get(0).(func() []User); ok {
-		r0 = rf()
+	if rf, ok := ret.Get(0).(func(Query) Page); ok {
+		r0 = rf(q)
 	} else {
-		if ret.Get(0) != nil {
-			r0 = ret.Get(0).([]User)
-		}
+		r0 = ret.Get(0).(Page)
 	}
 
-	if rf, ok := ret.Get(1).(func() error); ok {
-		r1 = rf()
+	if rf, ok := ret.Get(1).(func(Query) error); ok {
+		r1 = rf(q)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -83,6 +85,34 @@ func (_m *MockRepository) GetById(id uint) (User, error) {
 	return r0, r1
 }
 
+// GetVersion provides a mock function with given fields: id, version
+func (_m *MockRepository) GetVersion(id uint, version uint) (User, error) {
+	ret := _m.Called(id, version)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetVersion")
+	}
+
+	var r0 User
+	var r1 error
+	if rf, ok := ret.Get(0).(func(uint, uint) (User, error)); ok {
+		return rf(id, version)
+	}
+	if rf, ok := ret.Get(0).(func(uint, uint) User); ok {
+		r0 = rf(id, version)
+	} else {
+		r0 = ret.Get(0).(User)
+	}
+
+	if rf, ok := ret.Get(1).(func(uint, uint) error); ok {
+		r1 = rf(id, version)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // LastId provides a mock function with given fields:
 func (_m *MockRepository) LastId() (uint, error) {
 	ret := _m.Called()
@@ -111,27 +141,83 @@ func (_m *MockRepository) LastId() (uint, error) {
 	return r0, r1
 }
 
-// Patch provides a mock function with given fields: id, lastname, age
-func (_m *MockRepository) Patch(id uint, lastname string, age int) (User, error) {
-	ret := _m.Called(id, lastname, age)
+// Modify provides a mock function with given fields: id, version, fn
+func (_m *MockRepository) Modify(id uint, version uint, fn func(*User) error) (User, error) {
+	ret := _m.Called(id, version, fn)
 
 	if len(ret) == 0 {
-		panic("no return value specified for Patch")
+		panic("no return value specified for Modify")
 	}
 
 	var r0 User
 	var r1 error
-	if rf, ok := ret.Get(0).(func(uint, string, int) (User, error)); ok {
-		return rf(id, lastname, age)
+	if rf, ok := ret.Get(0).(func(uint, uint, func(*User) error) (User, error)); ok {
+		return rf(id, version, fn)
 	}
-	if rf, ok := ret.Get(0).(func(uint, string, int) User); ok {
-		r0 = rf(id, lastname, age)
+	if rf, ok := ret.Get(0).(func(uint, uint, func(*User) error) User); ok {
+		r0 = rf(id, version, fn)
 	} else {
 		r0 = ret.Get(0).(User)
 	}
 
-	if rf, ok := ret.Get(1).(func(uint, string, int) error); ok {
-		r1 = rf(id, lastname, age)
+	if rf, ok := ret.Get(1).(func(uint, uint, func(*User) error) error); ok {
+		r1 = rf(id, version, fn)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Purge provides a mock function with given fields: deletedBefore
+func (_m *MockRepository) Purge(deletedBefore time.Time) (int, error) {
+	ret := _m.Called(deletedBefore)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Purge")
+	}
+
+	var r0 int
+	var r1 error
+	if rf, ok := ret.Get(0).(func(time.Time) (int, error)); ok {
+		return rf(deletedBefore)
+	}
+	if rf, ok := ret.Get(0).(func(time.Time) int); ok {
+		r0 = rf(deletedBefore)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	if rf, ok := ret.Get(1).(func(time.Time) error); ok {
+		r1 = rf(deletedBefore)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Restore provides a mock function with given fields: id
+func (_m *MockRepository) Restore(id uint) (User, error) {
+	ret := _m.Called(id)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Restore")
+	}
+
+	var r0 User
+	var r1 error
+	if rf, ok := ret.Get(0).(func(uint) (User, error)); ok {
+		return rf(id)
+	}
+	if rf, ok := ret.Get(0).(func(uint) User); ok {
+		r0 = rf(id)
+	} else {
+		r0 = ret.Get(0).(User)
+	}
+
+	if rf, ok := ret.Get(1).(func(uint) error); ok {
+		r1 = rf(id)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -167,9 +253,57 @@ func (_m *MockRepository) Store(id uint, name string, lastname string, email str
 	return r0, r1
 }
 
-// Update provides a mock function with given fields: id, name, lastname, email, age, height, active
-func (_m *MockRepository) Update(id uint, name string, lastname string, email string, age int, height float64, active bool) (User, error) {
-	ret := _m.Called(id, name, lastname, email, age, height, active)
+// StoreAll provides a mock function with given fields: us
+func (_m *MockRepository) StoreAll(us []User) ([]User, error) {
+	ret := _m.Called(us)
+
+	if len(ret) == 0 {
+		panic("no return value specified for StoreAll")
+	}
+
+	var r0 []User
+	var r1 error
+	if rf, ok := ret.Get(0).(func([]User) ([]User, error)); ok {
+		return rf(us)
+	}
+	if rf, ok := ret.Get(0).(func([]User) []User); ok {
+		r0 = rf(us)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]User)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func([]User) error); ok {
+		r1 = rf(us)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Transaction provides a mock function with given fields: fn
+func (_m *MockRepository) Transaction(fn func(Repository) error) error {
+	ret := _m.Called(fn)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Transaction")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(func(Repository) error) error); ok {
+		r0 = rf(fn)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Update provides a mock function with given fields: id, version, name, lastname, email, age, height, active
+func (_m *MockRepository) Update(id uint, version uint, name string, lastname string, email string, age int, height float64, active bool) (User, error) {
+	ret := _m.Called(id, version, name, lastname, email, age, height, active)
 
 	if len(ret) == 0 {
 		panic("no return value specified for Update")
@@ -177,17 +311,17 @@ func (_m *MockRepository) Update(id uint, name string, lastname string, email st
 
 	var r0 User
 	var r1 error
-	if rf, ok := ret.Get(0).(func(uint, string, string, string, int, float64, bool) (User, error)); ok {
-		return rf(id, name, lastname, email, age, height, active)
+	if rf, ok := ret.Get(0).(func(uint, uint, string, string, string, int, float64, bool) (User, error)); ok {
+		return rf(id, version, name, lastname, email, age, height, active)
 	}
-	if rf, ok := ret.Get(0).(func(uint, string, string, string, int, float64, bool) User); ok {
-		r0 = rf(id, name, lastname, email, age, height, active)
+	if rf, ok := ret.Get(0).(func(uint, uint, string, string, string, int, float64, bool) User); ok {
+		r0 = rf(id, version, name, lastname, email, age, height, active)
 	} else {
 		r0 = ret.Get(0).(User)
 	}
 
-	if rf, ok := ret.Get(1).(func(uint, string, string, string, int, float64, bool) error); ok {
-		r1 = rf(id, name, lastname, email, age, height, active)
+	if rf, ok := ret.Get(1).(func(uint, uint, string, string, string, int, float64, bool) error); ok {
+		r1 = rf(id, version, name, lastname, email, age, height, active)
 	} else {
 		r1 = ret.Error(1)
 	}
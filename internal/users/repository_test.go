@@ -3,8 +3,10 @@ package users
 import (
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var file = []byte(`[
@@ -43,10 +45,21 @@ func (s StoreStub) Write(interface{}) error {
 	return nil
 }
 
+func (s *StoreStub) Update(data interface{}, fn func() error) error {
+	if err := s.Read(data); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	return s.Write(data)
+}
+
 func TestGetAll(t *testing.T) {
 	store := StoreStub{readWasCalled: false}
 	repository := NewRepository(&store)
-	var us, err = repository.GetAll()
+	var page, err = repository.GetAll(Query{})
+	us := page.Users
 
 	expectFirstId := uint(1)
 	expectSecondId := uint(2)
@@ -75,7 +88,7 @@ func TestUpdate(t *testing.T) {
 	assert.False(t, store.readWasCalled)
 
 	expectNameAfter := "After Update"
-	us, err := repository.Update(1, "After Update", "Test", "test@test.com", 22, 1.7, true)
+	us, err := repository.Update(1, 0, "After Update", "Test", "test@test.com", 22, 1.7, true)
 
 	assert.Nil(t, err)
 	assert.True(t, store.readWasCalled)
@@ -88,27 +101,34 @@ func TestUpdateNotFound(t *testing.T) {
 
 	assert.False(t, store.readWasCalled)
 
-	_, err := repository.Update(30, "After Update", "Test", "test@test.com", 22, 1.7, true)
+	_, err := repository.Update(30, 0, "After Update", "Test", "test@test.com", 22, 1.7, true)
 
 	assert.Error(t, err)
 }
 
-func TestPatch(t *testing.T) {
+func TestModify(t *testing.T) {
 	store := StoreStub{readWasCalled: false}
 	repository := NewRepository(&store)
 
 	expectNameAfter := "After Update"
-	us, err := repository.Patch(1, "After Update", 45)
+	us, err := repository.Modify(1, 0, func(u *User) error {
+		u.Lastname = "After Update"
+		u.Active = false
+		u.ID = 7
+		return nil
+	})
 
 	assert.Nil(t, err)
 	assert.Equal(t, us.Lastname, expectNameAfter, "devem ser iguais")
+	assert.False(t, us.Active)
+	assert.Equal(t, uint(1), us.ID, "o ID não pode ser alterado")
 }
 
-func TestPatchNotFound(t *testing.T) {
+func TestModifyNotFound(t *testing.T) {
 	store := StoreStub{readWasCalled: false}
 	repository := NewRepository(&store)
 
-	_, err := repository.Patch(20, "After Update", 45)
+	_, err := repository.Modify(20, 0, func(u *User) error { return nil })
 
 	assert.Error(t, err)
 }
@@ -117,7 +137,7 @@ func TestDelete(t *testing.T) {
 	store := StoreStub{readWasCalled: false}
 	repository := NewRepository(&store)
 
-	err := repository.Delete(uint(1))
+	err := repository.Delete(uint(1), 0)
 
 	assert.Nil(t, err)
 }
@@ -126,7 +146,7 @@ func TestDeleteError(t *testing.T) {
 	store := StoreStub{readWasCalled: false}
 	repository := NewRepository(&store)
 
-	err := repository.Delete(uint(20))
+	err := repository.Delete(uint(20), 0)
 
 	assert.Error(t, err)
 }
@@ -159,3 +179,142 @@ func TestStore(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, us.ID, uint(3), "devem ser iguais")
 }
+
+func TestStoreTakenId(t *testing.T) {
+	store := StoreStub{readWasCalled: false}
+	repository := NewRepository(&store)
+
+	us, err := repository.Store(uint(2), "test", "test", "test", "test", 22, 1.7, true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, us.ID, uint(3), "o ID já usado deve ser substituído pelo próximo livre")
+}
+
+func TestSoftDelete(t *testing.T) {
+	for name, repository := range queryRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, repository.Delete(2, 0))
+
+			_, err := repository.GetById(2)
+			assert.IsType(t, &NotFoundError{}, err)
+			_, err = repository.Modify(2, 0, func(u *User) error { return nil })
+			assert.IsType(t, &NotFoundError{}, err)
+			assert.IsType(t, &NotFoundError{}, repository.Delete(2, 0))
+
+			live, err := repository.GetAll(Query{})
+			require.NoError(t, err)
+			assert.Equal(t, []uint{1, 3, 4, 5}, ids(live.Users))
+			trash, err := repository.GetAll(Query{Trashed: true})
+			require.NoError(t, err)
+			assert.Equal(t, []uint{2}, ids(trash.Users))
+			assert.NotEmpty(t, trash.Users[0].DeletedAt)
+
+			u, err := repository.Restore(2)
+			require.NoError(t, err)
+			assert.Empty(t, u.DeletedAt)
+			_, err = repository.Restore(2)
+			assert.IsType(t, &NotFoundError{}, err)
+
+			require.NoError(t, repository.Delete(3, 0))
+			purged, err := repository.Purge(time.Now().Add(-time.Hour))
+			require.NoError(t, err)
+			assert.Equal(t, 0, purged)
+			purged, err = repository.Purge(time.Now().Add(time.Hour))
+			require.NoError(t, err)
+			assert.Equal(t, 1, purged)
+
+			trash, err = repository.GetAll(Query{Trashed: true})
+			require.NoError(t, err)
+			assert.Empty(t, trash.Users)
+		})
+	}
+}
+
+func TestTransactionPurgedIDsNotReused(t *testing.T) {
+	for name, repository := range queryRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, repository.Delete(5, 0))
+			_, err := repository.Purge(time.Now().Add(time.Hour))
+			require.NoError(t, err)
+
+			var created []User
+			require.NoError(t, repository.Transaction(func(tx Repository) error {
+				created, err = tx.StoreAll([]User{{Name: "Rui", Lastname: "Lima", Email: "rui@example.com", CreatedAt: "2024-06-01T10:00:00Z"}})
+				return err
+			}))
+			assert.Equal(t, uint(6), created[0].ID)
+
+			// The IDs the transaction gave are remembered outside it.
+			require.NoError(t, repository.Delete(6, 0))
+			_, err = repository.Purge(time.Now().Add(time.Hour))
+			require.NoError(t, err)
+			id, err := repository.LastId()
+			require.NoError(t, err)
+			assert.Equal(t, uint(6), id)
+		})
+	}
+}
+
+func TestStaleWrites(t *testing.T) {
+	for name, repository := range queryRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			u, err := repository.GetById(1)
+			require.NoError(t, err)
+			assert.Equal(t, uint(1), u.Version)
+
+			u, err = repository.Update(1, 1, u.Name, u.Lastname, u.Email, u.Age, u.Height, u.Active)
+			require.NoError(t, err)
+			assert.Equal(t, uint(2), u.Version)
+
+			_, err = repository.Update(1, 1, u.Name, u.Lastname, u.Email, u.Age, u.Height, u.Active)
+			var conflictErr *ConflictError
+			require.ErrorAs(t, err, &conflictErr)
+			assert.Equal(t, ConflictError{ID: 1, Expected: 1, Actual: 2}, *conflictErr)
+			_, err = repository.Modify(1, 1, func(u *User) error { return nil })
+			assert.ErrorAs(t, err, &conflictErr)
+			assert.ErrorAs(t, repository.Delete(1, 1), &conflictErr)
+
+			u, err = repository.Modify(1, 2, func(u *User) error { u.Age++; return nil })
+			require.NoError(t, err)
+			assert.Equal(t, uint(3), u.Version)
+			assert.NoError(t, repository.Delete(1, 3))
+			_, err = repository.Update(1, 4, u.Name, u.Lastname, u.Email, u.Age, u.Height, u.Active)
+			assert.IsType(t, &NotFoundError{}, err)
+		})
+	}
+}
+
+func TestVersionHistory(t *testing.T) {
+	for name, repository := range queryRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			original, err := repository.GetById(1)
+			require.NoError(t, err)
+			_, err = repository.Update(1, 0, "Outro", original.Lastname, original.Email, 40, original.Height, original.Active)
+			require.NoError(t, err)
+			_, err = repository.Modify(1, 0, func(u *User) error { u.Age = 41; return nil })
+			require.NoError(t, err)
+
+			v1, err := repository.GetVersion(1, 1)
+			require.NoError(t, err)
+			assert.Equal(t, original, v1)
+			v2, err := repository.GetVersion(1, 2)
+			require.NoError(t, err)
+			assert.Equal(t, "Outro", v2.Name)
+			assert.Equal(t, 40, v2.Age)
+			v3, err := repository.GetVersion(1, 3)
+			require.NoError(t, err)
+			assert.Equal(t, 41, v3.Age)
+
+			_, err = repository.GetVersion(1, 4)
+			assert.IsType(t, &VersionNotFoundError{}, err)
+			_, err = repository.GetVersion(99, 1)
+			assert.IsType(t, &NotFoundError{}, err)
+
+			require.NoError(t, repository.Delete(1, 0))
+			_, err = repository.Purge(time.Now().Add(time.Hour))
+			require.NoError(t, err)
+			_, err = repository.GetVersion(1, 1)
+			assert.IsType(t, &NotFoundError{}, err)
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package users
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+type SearchResult struct {
+	User  User    `json:"user"`
+	Score float64 `json:"score"`
+}
+
+// Weights of a match by field and by how close the term is to the query.
+const (
+	nameWeight     = 3
+	lastnameWeight = 2
+	emailWeight    = 1
+
+	exactMatch  = 1.0
+	prefixMatch = 0.6
+	typoMatch   = 0.3
+)
+
+// SearchIndex is an inverted index over the name, lastname and email of
+// every user. Terms are folded to lower case without accents, so "jose"
+// finds "José", and query terms also match terms they prefix or that are
+// one or two edits away.
+type SearchIndex struct {
+	mu sync.RWMutex
+	// postings maps a term to the users that have it and the weight of the
+	// best field it appears in.
+	postings map[string]map[uint]float64
+	terms    map[uint][]string
+	users    map[uint]User
+}
+
+func NewSearchIndex() *SearchIndex {
+	return &SearchIndex{
+		postings: map[string]map[uint]float64{},
+		terms:    map[uint][]string{},
+		users:    map[uint]User{},
+	}
+}
+
+// Reset replaces the whole index with us.
+func (s *SearchIndex) Reset(us []User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.postings = map[string]map[uint]float64{}
+	s.terms = map[uint][]string{}
+	s.users = map[uint]User{}
+	for _, u := range us {
+		s.put(u)
+	}
+}
+
+// Put adds u to the index or replaces the indexed copy of it.
+func (s *SearchIndex) Put(u User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(u.ID)
+	s.put(u)
+}
+
+func (s *SearchIndex) Remove(id uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(id)
+}
+
+// Search returns up to limit users matching every term of query, the best
+// matches first. A zero limit returns every match.
+func (s *SearchIndex) Search(query string, limit int) []SearchResult {
+	queryTerms := tokenize(query)
+	if len(queryTerms) == 0 {
+		return []SearchResult{}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var scores map[uint]float64
+	for _, q := range queryTerms {
+		// The best score of each user for this query term.
+		best := map[uint]float64{}
+		for term, users := range s.postings {
+			closeness := matchTerm(q, term)
+			if closeness == 0 {
+				continue
+			}
+			for id, weight := range users {
+				if score := closeness * weight; score > best[id] {
+					best[id] = score
+				}
+			}
+		}
+		if scores == nil {
+			scores = best
+			continue
+		}
+		for id := range scores {
+			if score, ok := best[id]; ok {
+				scores[id] += score
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+
+	results := make([]SearchResult, 0, len(scores))
+	for id, score := range scores {
+		results = append(results, SearchResult{User: s.users[id], Score: score})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].User.ID < results[j].User.ID
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+func (s *SearchIndex) put(u User) {
+	fields := []struct {
+		text   string
+		weight float64
+	}{{u.Name, nameWeight}, {u.Lastname, lastnameWeight}, {u.Email, emailWeight}}
+
+	for _, f := range fields {
+		for _, term := range tokenize(f.text) {
+			users, ok := s.postings[term]
+			if !ok {
+				users = map[uint]float64{}
+				s.postings[term] = users
+			}
+			if _, seen := users[u.ID]; !seen {
+				s.terms[u.ID] = append(s.terms[u.ID], term)
+			}
+			users[u.ID] = max(users[u.ID], f.weight)
+		}
+	}
+	s.users[u.ID] = u
+}
+
+func (s *SearchIndex) remove(id uint) {
+	for _, term := range s.terms[id] {
+		delete(s.postings[term], id)
+		if len(s.postings[term]) == 0 {
+			delete(s.postings, term)
+		}
+	}
+	delete(s.terms, id)
+	delete(s.users, id)
+}
+
+var foldAccents = transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+
+// tokenize splits text into lower case terms without accents, anything that
+// is not a letter or a digit separates terms.
+func tokenize(text string) []string {
+	folded, _, err := transform.String(foldAccents, text)
+	if err != nil {
+		folded = text
+	}
+	return strings.FieldsFunc(strings.ToLower(folded), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// matchTerm tells how well the query term q matches an indexed term, zero
+// when it does not.
+func matchTerm(q, term string) float64 {
+	switch {
+	case q == term:
+		return exactMatch
+	case len(q) >= 2 && strings.HasPrefix(term, q):
+		return prefixMatch
+	}
+	allowed := typoAllowance(len([]rune(q)))
+	if allowed > 0 && editDistance(q, term, allowed) <= allowed {
+		return typoMatch
+	}
+	return 0
+}
+
+// typoAllowance is how many edits a query term of n runes tolerates.
+func typoAllowance(n int) int {
+	switch {
+	case n >= 8:
+		return 2
+	case n >= 4:
+		return 1
+	}
+	return 0
+}
+
+// editDistance is the Damerau-Levenshtein distance (with adjacent
+// transpositions) between a and b, it stops counting past limit.
+func editDistance(a, b string, limit int) int {
+	ra, rb := []rune(a), []rune(b)
+	if d := len(ra) - len(rb); d > limit || -d > limit {
+		return limit + 1
+	}
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+			rowMin = min(rowMin, cur[j])
+		}
+		if rowMin > limit {
+			return limit + 1
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(rb)]
+}
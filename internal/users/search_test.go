@@ -0,0 +1,80 @@
+package users
+
+import (
+	"testing"
+
+	"github.com/Duarte64/go-web-meli/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newSearchIndexTest() *SearchIndex {
+	index := NewSearchIndex()
+	index.Reset([]User{
+		{ID: 1, Name: "José", Lastname: "Araújo", Email: "jose.araujo@example.com"},
+		{ID: 2, Name: "Joseph", Lastname: "Müller", Email: "joseph@example.com"},
+		{ID: 3, Name: "María", Lastname: "Gonzalez", Email: "maria@example.com"},
+		{ID: 4, Name: "Ana", Lastname: "José", Email: "ana@example.com"},
+	})
+	return index
+}
+
+func searchIDs(results []SearchResult) []uint {
+	out := []uint{}
+	for _, r := range results {
+		out = append(out, r.User.ID)
+	}
+	return out
+}
+
+func TestSearchIgnoresAccents(t *testing.T) {
+	index := newSearchIndexTest()
+
+	assert.Equal(t, []uint{3}, searchIDs(index.Search("maria", 0)))
+	assert.Equal(t, []uint{3}, searchIDs(index.Search("MARÍA", 0)))
+	assert.Equal(t, []uint{1}, searchIDs(index.Search("araujo", 0)))
+}
+
+func TestSearchRanking(t *testing.T) {
+	index := newSearchIndexTest()
+
+	// An exact name beats an exact lastname, which beats a prefix.
+	assert.Equal(t, []uint{1, 4, 2}, searchIDs(index.Search("jose", 0)))
+	assert.Equal(t, []uint{1}, searchIDs(index.Search("jose", 1)))
+}
+
+func TestSearchToleratesTypos(t *testing.T) {
+	index := newSearchIndexTest()
+
+	assert.Equal(t, []uint{3}, searchIDs(index.Search("gonzlaez", 0)))
+	assert.Equal(t, []uint{2}, searchIDs(index.Search("muler", 0)))
+	assert.Empty(t, index.Search("xyz", 0))
+}
+
+func TestSearchMatchesEveryTerm(t *testing.T) {
+	index := newSearchIndexTest()
+
+	assert.Equal(t, []uint{4}, searchIDs(index.Search("ana jose", 0)))
+	assert.Equal(t, []uint{1}, searchIDs(index.Search("jose.araujo@example", 0)))
+}
+
+func TestServiceKeepsSearchIndexUpdated(t *testing.T) {
+	service := NewService(NewRepository(store.NewMemory("", 0)))
+
+	u, err := service.Store("João", "Silva", "joao@example.com", 30, 1.7, true)
+	require.NoError(t, err)
+	results, err := service.Search("joao", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{u.ID}, searchIDs(results))
+
+	_, err = service.Update(u.ID, 0, "Pedro", "Silva", "pedro@example.com", 30, 1.7, true)
+	require.NoError(t, err)
+	results, _ = service.Search("joao", 0)
+	assert.Empty(t, results)
+	results, _ = service.Search("pedro", 0)
+	assert.Equal(t, []uint{u.ID}, searchIDs(results))
+
+	require.NoError(t, service.Delete(u.ID, 0))
+	results, _ = service.Search("pedro", 0)
+	assert.Empty(t, results)
+}
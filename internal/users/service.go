@@ -1,27 +1,104 @@
 package users
 
-import "time"
+import (
+	"sync"
+	"time"
+
+	"github.com/Duarte64/go-web-meli/internal/audit"
+	"github.com/Duarte64/go-web-meli/pkg/store"
+)
 
 type Service interface {
-	GetAll() ([]User, error)
+	GetAll(q Query) (Page, error)
 	GetById(id uint) (User, error)
 	Store(name, lastname, email string, age int, height float64, active bool) (User, error)
-	Update(id uint, name, lastname, email string, age int, height float64, active bool) (User, error)
-	Patch(id uint, lastname string, age int) (User, error)
-	Delete(id uint) error
+	// Update, Patch and Delete fail with a ConflictError when version is not
+	// zero and the user is no longer at it.
+	Update(id, version uint, name, lastname, email string, age int, height float64, active bool) (User, error)
+	Patch(id, version uint, patchType PatchType, patch []byte) (User, error)
+	Delete(id, version uint) error
+	Restore(id uint) (User, error)
+	// GetVersion returns the user as it was at version.
+	GetVersion(id, version uint) (User, error)
+	// Revert saves the values the user had at target as a new version, they
+	// are validated as an Update would. version is checked as in Update.
+	Revert(id, version, target uint) (User, error)
+	// Purge permanently removes the users in the trash for longer than
+	// retention.
+	Purge(retention time.Duration) (int, error)
+	Search(query string, limit int) ([]SearchResult, error)
+	// Import stores the users read from an import file, each as Store would,
+	// and reports what happened to every row. When atomic is set a single
+	// failing row fails the import and nothing is stored.
+	Import(rows []ImportRow, atomic bool) (ImportReport, error)
+	// Export calls fn with every user matching q, in its order, reading them
+	// a page at a time. The pagination of q is ignored.
+	Export(q Query, fn func(u User) error) error
+	// Batch runs the operations in a single transaction, every one of them
+	// as the matching method would. When atomic is set the first failure
+	// discards the whole batch.
+	Batch(ops []Operation, atomic bool) ([]OperationResult, error)
+	// Reindex rebuilds the search index, for changes made behind the service.
+	Reindex() error
+	// History lists the audit entries of the user, newest first.
+	History(id uint, f audit.Filter) (audit.Page, error)
+	// SetPassword replaces the password of the user once it passes the
+	// password policy, discarding any reset token.
+	SetPassword(id uint, password string) error
+	// ChangePassword is SetPassword for a user who knows the current
+	// password, it fails with ErrInvalidCredentials otherwise.
+	ChangePassword(id uint, current, password string) error
+	// Authenticate returns the active user with email and password, or
+	// ErrInvalidCredentials.
+	Authenticate(email, password string) (User, error)
+	// PasswordResetToken returns a single use token ResetPassword accepts
+	// until it expires, replacing any earlier one.
+	PasswordResetToken(id uint) (string, time.Time, error)
+	// ResetPassword sets the password of the user the token was issued to.
+	ResetPassword(token, password string) (User, error)
+	// WithActor returns the service recording actor as the author of the
+	// changes made through it.
+	WithActor(actor string) Service
+}
+
+// AuditLog receives an entry for every change made through the service.
+type AuditLog interface {
+	Append(entries ...audit.Entry) error
+	List(f audit.Filter) (audit.Page, error)
 }
 
 type service struct {
+	*serviceState
+	actor string
+}
+
+// serviceState is shared by the services WithActor returns.
+type serviceState struct {
 	repository Repository
+	// writeMu makes the email uniqueness check and the write that follows it
+	// atomic for the changes made through this service.
+	writeMu sync.Mutex
+
+	// The search index is built from the repository on the first search and
+	// kept up to date by every change made through the service afterwards.
+	index   *SearchIndex
+	indexMu sync.Mutex
+	indexed bool
+
+	auditLog     AuditLog
+	onAuditError func(error)
+
+	credentials       store.Collection[credential]
+	passwordAlgorithm PasswordAlgorithm
 }
 
-func (s *service) GetAll() ([]User, error) {
-	us, err := s.repository.GetAll()
+func (s *service) GetAll(q Query) (Page, error) {
+	page, err := s.repository.GetAll(q)
 	if err != nil {
-		return nil, err
+		return Page{}, err
 	}
 
-	return us, nil
+	return page, nil
 }
 
 func (s *service) GetById(id uint) (User, error) {
@@ -34,8 +111,15 @@ func (s *service) GetById(id uint) (User, error) {
 }
 
 func (s *service) Store(name, lastname, email string, age int, height float64, active bool) (User, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if err := s.validateUser(User{Name: name, Lastname: lastname, Email: email, Age: age, Height: height}); err != nil {
+		return User{}, err
+	}
+
 	lastId, err := s.repository.LastId()
-	date := time.Now().String()
+	date := now()
 	if err != nil {
 		return User{}, err
 	}
@@ -46,27 +130,238 @@ func (s *service) Store(name, lastname, email string, age int, height float64, a
 	if err != nil {
 		return User{}, err
 	}
+	s.indexUser(u)
+	s.record(OpCreate, u.ID, nil, &u)
 	return u, nil
 }
 
-func (s *service) Update(id uint, name, lastname, email string, age int, height float64, active bool) (User, error) {
-	return s.repository.Update(id, name, lastname, email, age, height, active)
+func (s *service) Update(id, version uint, name, lastname, email string, age int, height float64, active bool) (User, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if err := s.validateUser(User{ID: id, Name: name, Lastname: lastname, Email: email, Age: age, Height: height}); err != nil {
+		return User{}, err
+	}
+
+	var before *User
+	if s.auditLog != nil {
+		if current, err := s.repository.GetById(id); err == nil {
+			before = &current
+		}
+	}
+	u, err := s.repository.Update(id, version, name, lastname, email, age, height, active)
+	if err != nil {
+		return User{}, err
+	}
+	s.indexUser(u)
+	s.record(OpUpdate, id, before, &u)
+	return u, nil
 }
 
-func (s *service) Patch(id uint, lastname string, age int) (User, error) {
-	return s.repository.Patch(id, lastname, age)
+// Patch applies a merge patch or a JSON patch to the whole user. The result
+// is validated before anything is saved, and the patch is applied again to
+// the stored user inside Modify so that it is atomic with the write.
+func (s *service) Patch(id, version uint, patchType PatchType, patch []byte) (User, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	current, err := s.repository.GetById(id)
+	if err != nil {
+		return User{}, err
+	}
+	if version != 0 && current.Version != version {
+		return User{}, &ConflictError{ID: id, Expected: version, Actual: current.Version}
+	}
+	patched, err := applyPatch(current, patchType, patch)
+	if err != nil {
+		return User{}, err
+	}
+	if err := s.validateUser(patched); err != nil {
+		return User{}, err
+	}
+
+	u, err := s.repository.Modify(id, version, func(user *User) error {
+		result, err := applyPatch(*user, patchType, patch)
+		if err != nil {
+			return err
+		}
+		if err := validateFields(result).err(); err != nil {
+			return err
+		}
+		*user = result
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+	s.indexUser(u)
+	s.record(OpPatch, id, &current, &u)
+	return u, nil
 }
 
-func (s *service) Delete(id uint) error {
-	if err := s.repository.Delete(id); err != nil {
+func (s *service) Delete(id, version uint) error {
+	// Held like in the other writes so that an update finishing before the
+	// delete can't put the user back in the index after it.
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	var before *User
+	if s.auditLog != nil {
+		if current, err := s.repository.GetById(id); err == nil {
+			before = &current
+		}
+	}
+	if err := s.repository.Delete(id, version); err != nil {
 		return err
 	}
+	s.record(OpDelete, id, before, nil)
 
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+	if s.indexed {
+		s.index.Remove(id)
+	}
 	return nil
 }
 
-func NewService(r Repository) Service {
-	return &service{
-		repository: r,
+// Restore brings a user back from the trash unless another user took its
+// email meanwhile.
+func (s *service) Restore(id uint) (User, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	trash, err := s.repository.GetAll(Query{Trashed: true})
+	if err != nil {
+		return User{}, err
+	}
+	var before *User
+	for _, trashed := range trash.Users {
+		if trashed.ID != id {
+			continue
+		}
+		before = &trashed
+		v := &validator{}
+		if err := s.checkEmailUnique(v, trashed); err != nil {
+			return User{}, err
+		}
+		if err := v.err(); err != nil {
+			return User{}, err
+		}
+	}
+
+	u, err := s.repository.Restore(id)
+	if err != nil {
+		return User{}, err
 	}
+	s.indexUser(u)
+	s.record(OpRestore, id, before, &u)
+	return u, nil
+}
+
+func (s *service) GetVersion(id, version uint) (User, error) {
+	return s.repository.GetVersion(id, version)
+}
+
+func (s *service) Revert(id, version, target uint) (User, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	current, err := s.repository.GetById(id)
+	if err != nil {
+		return User{}, err
+	}
+	if version != 0 && current.Version != version {
+		return User{}, &ConflictError{ID: id, Expected: version, Actual: current.Version}
+	}
+	past, err := s.repository.GetVersion(id, target)
+	if err != nil {
+		return User{}, err
+	}
+	if err := s.validateUser(User{ID: id, Name: past.Name, Lastname: past.Lastname, Email: past.Email, Age: past.Age, Height: past.Height}); err != nil {
+		return User{}, err
+	}
+
+	u, err := s.repository.Update(id, current.Version, past.Name, past.Lastname, past.Email, past.Age, past.Height, past.Active)
+	if err != nil {
+		return User{}, err
+	}
+	s.indexUser(u)
+	s.record(OpRevert, id, &current, &u)
+	return u, nil
+}
+
+func (s *service) Purge(retention time.Duration) (int, error) {
+	n, err := s.repository.Purge(time.Now().Add(-retention))
+	if err != nil || n == 0 {
+		return n, err
+	}
+	return n, s.purgeCredentials()
+}
+
+func (s *service) Search(query string, limit int) ([]SearchResult, error) {
+	if err := s.loadIndex(); err != nil {
+		return nil, err
+	}
+	return s.index.Search(query, limit), nil
+}
+
+func (s *service) Reindex() error {
+	s.indexMu.Lock()
+	s.indexed = false
+	s.indexMu.Unlock()
+	return s.loadIndex()
+}
+
+// loadIndex builds the index when it was not yet. The lock is held while
+// the users are read, so a change that completes meanwhile is either in the
+// list read or applied to the index after it.
+func (s *service) loadIndex() error {
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+	if s.indexed {
+		return nil
+	}
+	page, err := s.repository.GetAll(Query{})
+	if err != nil {
+		return err
+	}
+	s.index.Reset(page.Users)
+	s.indexed = true
+	return nil
+}
+
+func (s *service) indexUser(u User) {
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+	if s.indexed {
+		s.index.Put(u)
+	}
+}
+
+func NewService(r Repository) Service {
+	return NewAuditedService(r, nil, nil)
+}
+
+// NewAuditedService returns a Service appending an entry to log for every
+// change. A change is not undone when its entry can't be appended, the
+// error is passed to onError instead.
+func NewAuditedService(r Repository, log AuditLog, onError func(error)) Service {
+	return NewServiceWithCredentials(r, log, onError, store.NewMemory("", 0), Argon2id)
+}
+
+// NewServiceWithCredentials is NewAuditedService keeping the password
+// hashes of the users, made with alg, in credentials.
+func NewServiceWithCredentials(r Repository, log AuditLog, onError func(error), credentials store.Store, alg PasswordAlgorithm) Service {
+	return &service{serviceState: &serviceState{
+		repository:        r,
+		index:             NewSearchIndex(),
+		auditLog:          log,
+		onAuditError:      onError,
+		credentials:       store.NewCollection[credential](credentials),
+		passwordAlgorithm: alg,
+	}}
+}
+
+func (s *service) WithActor(actor string) Service {
+	return &service{serviceState: s.serviceState, actor: actor}
 }
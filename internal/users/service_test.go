@@ -4,8 +4,11 @@ import (
 	"errors"
 	"testing"
 
+	"github.com/Duarte64/go-web-meli/pkg/jsonpatch"
+	"github.com/Duarte64/go-web-meli/pkg/store"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func TestUpdateMock(t *testing.T) {
@@ -22,9 +25,10 @@ func TestUpdateMock(t *testing.T) {
 		Active:   true,
 	}
 
-	repository.On("Update", updatedUser.ID, updatedUser.Name, updatedUser.Lastname, updatedUser.Email, updatedUser.Age, updatedUser.Height, updatedUser.Active).Return(updatedUser, nil).Once()
+	repository.On("GetAll", Query{Email: updatedUser.Email}).Return(Page{Users: []User{updatedUser}, Total: 1}, nil).Once()
+	repository.On("Update", updatedUser.ID, uint(0), updatedUser.Name, updatedUser.Lastname, updatedUser.Email, updatedUser.Age, updatedUser.Height, updatedUser.Active).Return(updatedUser, nil).Once()
 
-	result, err := service.Update(updatedUser.ID, updatedUser.Name, updatedUser.Lastname, updatedUser.Email, updatedUser.Age, updatedUser.Height, updatedUser.Active)
+	result, err := service.Update(updatedUser.ID, 0, updatedUser.Name, updatedUser.Lastname, updatedUser.Email, updatedUser.Age, updatedUser.Height, updatedUser.Active)
 
 	repository.AssertExpectations(t)
 	assert.NoError(t, err)
@@ -35,9 +39,9 @@ func TestDeleteMock(t *testing.T) {
 	repository := NewMockRepository(t)
 	service := NewService(repository)
 
-	repository.On("Delete", uint(1)).Return(nil).Once()
+	repository.On("Delete", uint(1), uint(0)).Return(nil).Once()
 
-	err := service.Delete(uint(1))
+	err := service.Delete(uint(1), 0)
 
 	repository.AssertExpectations(t)
 	assert.NoError(t, err)
@@ -47,9 +51,9 @@ func TestDeleteMockError(t *testing.T) {
 	repository := NewMockRepository(t)
 	service := NewService(repository)
 
-	repository.On("Delete", uint(1)).Return(errors.New("unable to delete")).Once()
+	repository.On("Delete", uint(1), uint(0)).Return(errors.New("unable to delete")).Once()
 
-	err := service.Delete(uint(1))
+	err := service.Delete(uint(1), 0)
 
 	repository.AssertExpectations(t)
 	assert.Error(t, err)
@@ -70,6 +74,7 @@ func TestStoreMock(t *testing.T) {
 		CreatedAt: "2021-01-01 00:00:00",
 	}
 
+	repository.On("GetAll", Query{Email: storeUser.Email}).Return(Page{Users: []User{}}, nil).Once()
 	repository.On("LastId").Return(uint(1), nil).Once()
 	repository.On("Store", storeUser.ID, storeUser.Name, storeUser.Lastname, storeUser.Email, mock.AnythingOfType("string"), storeUser.Age, storeUser.Height, storeUser.Active).Return(storeUser, nil).Once()
 
@@ -95,6 +100,7 @@ func TestStoreMockWithLastIdError(t *testing.T) {
 		CreatedAt: "2021-01-01 00:00:00",
 	}
 
+	repository.On("GetAll", Query{Email: storeUser.Email}).Return(Page{Users: []User{}}, nil).Once()
 	repository.On("LastId").Return(uint(0), errors.New("error getting last id")).Once()
 
 	_, err := service.Store(storeUser.Name, storeUser.Lastname, storeUser.Email, storeUser.Age, storeUser.Height, storeUser.Active)
@@ -119,6 +125,7 @@ func TestStoreMockWithError(t *testing.T) {
 		CreatedAt: "2021-01-01 00:00:00",
 	}
 
+	repository.On("GetAll", Query{Email: storeUser.Email}).Return(Page{Users: []User{}}, nil).Once()
 	repository.On("LastId").Return(uint(1), nil).Once()
 	repository.On("Store", storeUser.ID, storeUser.Name, storeUser.Lastname, storeUser.Email, mock.AnythingOfType("string"), storeUser.Age, storeUser.Height, storeUser.Active).Return(User{}, errors.New("unable to create")).Once()
 
@@ -156,23 +163,23 @@ func TestGetAllMock(t *testing.T) {
 		},
 	}
 
-	repository.On("GetAll").Return(storedUsers, nil).Once()
+	repository.On("GetAll", Query{}).Return(Page{Users: storedUsers, Total: 2}, nil).Once()
 
-	users, err := service.GetAll()
+	page, err := service.GetAll(Query{})
 
 	repository.AssertExpectations(t)
 	assert.NoError(t, err)
-	assert.Equal(t, users[0].ID, uint(1))
-	assert.Equal(t, users[1].ID, uint(2))
+	assert.Equal(t, page.Users[0].ID, uint(1))
+	assert.Equal(t, page.Users[1].ID, uint(2))
 }
 
 func TestGetAllMockError(t *testing.T) {
 	repository := NewMockRepository(t)
 	service := NewService(repository)
 
-	repository.On("GetAll").Return([]User{}, errors.New("error")).Once()
+	repository.On("GetAll", Query{}).Return(Page{}, errors.New("error")).Once()
 
-	_, err := service.GetAll()
+	_, err := service.GetAll(Query{})
 
 	repository.AssertExpectations(t)
 	assert.Error(t, err)
@@ -221,11 +228,113 @@ func TestPatchMock(t *testing.T) {
 	repository := NewMockRepository(t)
 	service := NewService(repository)
 
-	repository.On("Patch", uint(1), "test", 20).Return(User{}, nil).Once()
+	stored := User{ID: 1, Name: "Jane", Lastname: "Doe", Email: "jane@example.com", Age: 28, Height: 1.7, Active: true}
+	repository.On("GetById", uint(1)).Return(stored, nil).Once()
+	repository.On("GetAll", Query{Email: stored.Email}).Return(Page{Users: []User{stored}, Total: 1}, nil).Once()
+	repository.On("Modify", uint(1), uint(0), mock.Anything).Return(func(id, version uint, fn func(*User) error) (User, error) {
+		u := stored
+		err := fn(&u)
+		return u, err
+	}).Once()
 
-	us, err := service.Patch(uint(1), "test", 20)
+	us, err := service.Patch(uint(1), 0, MergePatch, []byte(`{"lastname": "test", "age": 20, "active": false}`))
 
 	repository.AssertExpectations(t)
 	assert.NoError(t, err)
-	assert.Equal(t, us, User{})
+	assert.Equal(t, "test", us.Lastname)
+	assert.Equal(t, 20, us.Age)
+	assert.False(t, us.Active)
+}
+
+func TestPatchJSONPatch(t *testing.T) {
+	repository := NewMockRepository(t)
+	service := NewService(repository)
+
+	stored := User{ID: 1, Name: "Jane", Lastname: "Doe", Email: "jane@example.com", Age: 28, Height: 1.7, Active: true}
+	repository.On("GetById", uint(1)).Return(stored, nil)
+
+	_, err := service.Patch(uint(1), 0, JSONPatch, []byte(`[{"op": "test", "path": "/age", "value": 30}, {"op": "replace", "path": "/age", "value": 31}]`))
+	assert.ErrorIs(t, err, jsonpatch.ErrTestFailed)
+
+	_, err = service.Patch(uint(1), 0, JSONPatch, []byte(`[{"op": "replace", "path": "/id", "value": 2}]`))
+	assert.IsType(t, &ValidationError{}, err)
+
+	_, err = service.Patch(uint(1), 0, JSONPatch, []byte(`[{"op": "add", "path": "/nickname", "value": "jd"}]`))
+	assert.IsType(t, &PatchError{}, err)
+}
+
+func TestStoreValidation(t *testing.T) {
+	repository := NewMockRepository(t)
+	service := NewService(repository)
+
+	repository.On("GetAll", Query{Email: "not-an-email"}).Return(Page{Users: []User{}}, nil).Once()
+
+	_, err := service.Store("J", "Doe", "not-an-email", 0, 4.5, false)
+
+	var validationErr *ValidationError
+	assert.ErrorAs(t, err, &validationErr)
+	fields := []string{}
+	for _, f := range validationErr.Fields {
+		fields = append(fields, f.Field)
+	}
+	assert.Equal(t, []string{"name", "email", "age", "height"}, fields)
+}
+
+func TestStoreEmailTaken(t *testing.T) {
+	repository := NewMockRepository(t)
+	service := NewService(repository)
+
+	taken := User{ID: 1, Email: "Jane.Doe@Example.com"}
+	repository.On("GetAll", Query{Email: "jane.doe@example.com"}).Return(Page{Users: []User{taken}, Total: 1}, nil).Once()
+
+	_, err := service.Store("Jane", "Doe", "jane.doe@example.com", 28, 1.7, false)
+
+	var validationErr *ValidationError
+	assert.ErrorAs(t, err, &validationErr)
+	assert.Equal(t, []FieldError{{Field: "email", Message: "já está em uso"}}, validationErr.Fields)
+}
+
+func TestPatchValidation(t *testing.T) {
+	repository := NewMockRepository(t)
+	service := NewService(repository)
+
+	stored := User{ID: 1, Name: "Jane", Lastname: "Doe", Email: "jane@example.com", Age: 28, Height: 1.7, Active: true}
+	repository.On("GetById", uint(1)).Return(stored, nil).Once()
+	repository.On("GetAll", Query{Email: stored.Email}).Return(Page{Users: []User{stored}, Total: 1}, nil).Once()
+
+	_, err := service.Patch(1, 0, MergePatch, []byte(`{"age": 200, "name": null}`))
+
+	var validationErr *ValidationError
+	assert.ErrorAs(t, err, &validationErr)
+	assert.Len(t, validationErr.Fields, 2)
+}
+
+func TestServiceRevert(t *testing.T) {
+	service := NewService(NewRepository(store.NewMemory("", 0)))
+	u, err := service.Store("João", "Silva", "joao@example.com", 30, 1.7, true)
+	require.NoError(t, err)
+	_, err = service.Update(u.ID, 0, "Pedro", "Souza", "pedro@example.com", 31, 1.8, false)
+	require.NoError(t, err)
+	other, err := service.Store("Maria", "Silva", "maria@example.com", 30, 1.7, true)
+	require.NoError(t, err)
+
+	reverted, err := service.Revert(u.ID, 2, 1)
+	require.NoError(t, err)
+	assert.Equal(t, uint(3), reverted.Version)
+	assert.Equal(t, "João", reverted.Name)
+	assert.Equal(t, "joao@example.com", reverted.Email)
+	assert.True(t, reverted.Active)
+
+	_, err = service.Revert(u.ID, 2, 1)
+	assert.IsType(t, &ConflictError{}, err)
+	_, err = service.Revert(u.ID, 0, 9)
+	assert.IsType(t, &VersionNotFoundError{}, err)
+
+	// Going back is validated, the email of version 1 is taken now.
+	_, err = service.Update(u.ID, 0, "João", "Silva", "joao2@example.com", 30, 1.7, true)
+	require.NoError(t, err)
+	_, err = service.Update(other.ID, 0, "Maria", "Silva", "joao@example.com", 30, 1.7, true)
+	require.NoError(t, err)
+	_, err = service.Revert(u.ID, 0, 1)
+	assert.IsType(t, &ValidationError{}, err)
 }
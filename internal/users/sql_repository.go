@@ -0,0 +1,548 @@
+package users
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const createUsersTable = `CREATE TABLE IF NOT EXISTS users (
+	id INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	lastname TEXT NOT NULL,
+	email TEXT NOT NULL,
+	age INTEGER NOT NULL,
+	height REAL NOT NULL,
+	active INTEGER NOT NULL,
+	created_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS users_email ON users (email);`
+
+// createUserVersions keeps every version of a user an update replaces, the
+// table has the columns of users so the same queries read both.
+const createUserVersions = `CREATE TABLE IF NOT EXISTS user_versions (
+	id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	lastname TEXT NOT NULL,
+	email TEXT NOT NULL,
+	age INTEGER NOT NULL,
+	height REAL NOT NULL,
+	active INTEGER NOT NULL,
+	created_at TEXT NOT NULL,
+	updated_at TEXT NOT NULL,
+	deleted_at TEXT NOT NULL,
+	version INTEGER NOT NULL,
+	PRIMARY KEY (id, version)
+);
+CREATE TRIGGER IF NOT EXISTS users_keep_version BEFORE UPDATE OF version ON users
+WHEN NEW.version != OLD.version
+BEGIN
+	INSERT OR REPLACE INTO user_versions (id, name, lastname, email, age, height, active, created_at, updated_at, deleted_at, version)
+	VALUES (OLD.id, OLD.name, OLD.lastname, OLD.email, OLD.age, OLD.height, OLD.active, OLD.created_at, OLD.updated_at, OLD.deleted_at, OLD.version);
+END;
+CREATE TRIGGER IF NOT EXISTS users_purge_versions AFTER DELETE ON users
+BEGIN
+	DELETE FROM user_versions WHERE id = OLD.id;
+END;`
+
+const userColumns = `id, name, lastname, email, age, height, active, created_at, updated_at, deleted_at, version`
+
+// autoincrementUsers rebuilds the users table with AUTOINCREMENT, so that
+// the ID of a purged user is never given to another one. Dropping the table
+// drops its index and triggers, they are created again.
+const autoincrementUsers = `CREATE TABLE users_autoincrement (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	lastname TEXT NOT NULL,
+	email TEXT NOT NULL,
+	age INTEGER NOT NULL,
+	height REAL NOT NULL,
+	active INTEGER NOT NULL,
+	created_at TEXT NOT NULL,
+	updated_at TEXT NOT NULL DEFAULT '',
+	deleted_at TEXT NOT NULL DEFAULT '',
+	version INTEGER NOT NULL DEFAULT 1
+);
+INSERT INTO users_autoincrement (` + userColumns + `) SELECT ` + userColumns + ` FROM users;
+DROP TABLE users;
+ALTER TABLE users_autoincrement RENAME TO users;
+CREATE INDEX IF NOT EXISTS users_email ON users (email);
+` + createUserVersions
+
+// nextSQLID is the ID following the greatest one the users table ever had.
+const nextSQLID = `(SELECT COALESCE(MAX(seq), 0) + 1 FROM sqlite_sequence WHERE name = 'users')`
+
+// sqlMigrations bring the users table to the current schema, the database
+// user_version holds how many of them were applied. New ones must only be
+// appended.
+var sqlMigrations = []func(tx *sql.Tx) error{
+	execSQL(createUsersTable),
+	execSQL(`ALTER TABLE users ADD COLUMN updated_at TEXT NOT NULL DEFAULT ''`),
+	normalizeSQLTimes,
+	execSQL(`ALTER TABLE users ADD COLUMN deleted_at TEXT NOT NULL DEFAULT ''`),
+	execSQL(`ALTER TABLE users ADD COLUMN version INTEGER NOT NULL DEFAULT 1`),
+	execSQL(createUserVersions),
+	execSQL(autoincrementUsers),
+}
+
+type sqlRepository struct {
+	db *sql.DB
+	// tx is set on the repository a Transaction runs its callback with.
+	tx *sql.Tx
+}
+
+// querier is the subset of *sql.DB and *sql.Tx the repository uses.
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func (r *sqlRepository) conn() querier {
+	if r.tx != nil {
+		return r.tx
+	}
+	return r.db
+}
+
+// inTx runs fn in a transaction, or in a savepoint of the one the
+// repository is part of, committing only when fn succeeds.
+func (r *sqlRepository) inTx(fn func(q querier) error) error {
+	if r.tx != nil {
+		if _, err := r.tx.Exec(`SAVEPOINT nested`); err != nil {
+			return err
+		}
+		if err := fn(r.tx); err != nil {
+			if _, rollbackErr := r.tx.Exec(`ROLLBACK TO nested; RELEASE nested`); rollbackErr != nil {
+				return rollbackErr
+			}
+			return err
+		}
+		_, err := r.tx.Exec(`RELEASE nested`)
+		return err
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *sqlRepository) Transaction(fn func(tx Repository) error) error {
+	return r.inTx(func(q querier) error {
+		tx := q.(*sql.Tx)
+		return fn(&sqlRepository{db: r.db, tx: tx})
+	})
+}
+
+// NewSQLRepository returns a Repository backed by the users table of db,
+// creating or migrating the table when needed.
+func NewSQLRepository(db *sql.DB) (Repository, error) {
+	if err := migrateSQL(db); err != nil {
+		return nil, err
+	}
+	return &sqlRepository{
+		db: db,
+	}, nil
+}
+
+func migrateSQL(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	for ; version < len(sqlMigrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := sqlMigrations[version](tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("sql migration %d: %w", version+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func execSQL(query string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(query)
+		return err
+	}
+}
+
+// normalizeSQLTimes is the SQL counterpart of the normalize_created_at and
+// add_updated_at migrations of the file stores.
+func normalizeSQLTimes(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT id, created_at FROM users`)
+	if err != nil {
+		return err
+	}
+	createdAt := map[uint]string{}
+	for rows.Next() {
+		var id uint
+		var value string
+		if err := rows.Scan(&id, &value); err != nil {
+			rows.Close()
+			return err
+		}
+		createdAt[id] = value
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, value := range createdAt {
+		normalized, err := normalizeTime(value)
+		if err != nil {
+			return fmt.Errorf("user %d: %w", id, err)
+		}
+		_, err = tx.Exec(`UPDATE users SET created_at = ?, updated_at = CASE WHEN updated_at = '' THEN ? ELSE updated_at END
+			WHERE id = ?`, normalized, normalized, id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(row scanner) (User, error) {
+	var u User
+	err := row.Scan(&u.ID, &u.Name, &u.Lastname, &u.Email, &u.Age, &u.Height, &u.Active, &u.CreatedAt, &u.UpdatedAt, &u.DeletedAt, &u.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, &NotFoundError{}
+	}
+	return u, err
+}
+
+func (r *sqlRepository) LastId() (uint, error) {
+	var id uint
+	if err := r.conn().QueryRow(`SELECT ` + nextSQLID + ` - 1`).Scan(&id); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (r *sqlRepository) Store(id uint, name, lastname, email, createdAt string, age int, height float64, active bool) (User, error) {
+	// A concurrent Store may already have taken id, fall back to the next free one.
+	row := r.conn().QueryRow(`INSERT INTO users (`+userColumns+`)
+		VALUES (MAX(?, `+nextSQLID+`), ?, ?, ?, ?, ?, ?, ?, ?, '', 1)
+		RETURNING `+userColumns, id, name, lastname, email, age, height, active, createdAt, createdAt)
+	return scanUser(row)
+}
+
+func (r *sqlRepository) StoreAll(us []User) ([]User, error) {
+	created := make([]User, len(us))
+	err := r.inTx(func(q querier) error {
+		for i, u := range us {
+			row := q.QueryRow(`INSERT INTO users (`+userColumns+`)
+				VALUES (`+nextSQLID+`, ?, ?, ?, ?, ?, ?, ?, ?, '', 1)
+				RETURNING `+userColumns, u.Name, u.Lastname, u.Email, u.Age, u.Height, u.Active, u.CreatedAt, u.CreatedAt)
+			var err error
+			if created[i], err = scanUser(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// liveAt selects the user that is not in the trash and, unless version is
+// zero, still at version.
+const liveAt = `id = ? AND deleted_at = '' AND (? = 0 OR version = ?)`
+
+// missed tells why a write to the live user id at version changed nothing.
+func (r *sqlRepository) missed(id, version uint) error {
+	var actual uint
+	err := r.conn().QueryRow(`SELECT version FROM users WHERE id = ? AND deleted_at = ''`, id).Scan(&actual)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &NotFoundError{}
+	}
+	if err != nil {
+		return err
+	}
+	return &ConflictError{ID: id, Expected: version, Actual: actual}
+}
+
+func (r *sqlRepository) Update(id, version uint, name, lastname, email string, age int, height float64, active bool) (User, error) {
+	row := r.conn().QueryRow(`UPDATE users SET name = ?, lastname = ?, email = ?, age = ?, height = ?, active = ?, updated_at = ?,
+		version = version + 1 WHERE `+liveAt+` RETURNING `+userColumns,
+		name, lastname, email, age, height, active, now(), id, version, version)
+	u, err := scanUser(row)
+	var notFoundErr *NotFoundError
+	if errors.As(err, &notFoundErr) {
+		return User{}, r.missed(id, version)
+	}
+	return u, err
+}
+
+func (r *sqlRepository) Delete(id, version uint) error {
+	res, err := r.conn().Exec(`UPDATE users SET deleted_at = ?, version = version + 1 WHERE `+liveAt, now(), id, version, version)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return r.missed(id, version)
+	}
+	return nil
+}
+
+func (r *sqlRepository) Restore(id uint) (User, error) {
+	row := r.conn().QueryRow(`UPDATE users SET deleted_at = '', version = version + 1 WHERE id = ? AND deleted_at != ''
+		RETURNING `+userColumns, id)
+	return scanUser(row)
+}
+
+func (r *sqlRepository) Purge(deletedBefore time.Time) (int, error) {
+	res, err := r.conn().Exec(`DELETE FROM users WHERE deleted_at != '' AND julianday(deleted_at) < julianday(?)`,
+		deletedBefore.Format(time.RFC3339Nano))
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// GetAll runs the whole query in SQL, paging by cursor is done with
+// keyset conditions on the sort columns.
+func (r *sqlRepository) GetAll(q Query) (Page, error) {
+	keys, err := q.sortKeys()
+	if err != nil {
+		return Page{}, err
+	}
+	where, args := sqlFilters(q)
+
+	var total int
+	if err := r.conn().QueryRow(`SELECT COUNT(*) FROM users WHERE `+where, args...).Scan(&total); err != nil {
+		return Page{}, err
+	}
+
+	pageWhere, pageArgs := where, args
+	before := false
+	if q.Cursor != "" {
+		var at User
+		at, before, err = decodeCursor(q.Cursor, keys)
+		if err != nil {
+			return Page{}, err
+		}
+		cond, condArgs := keysetCondition(keys, at, before)
+		pageWhere = where + ` AND ` + cond
+		pageArgs = append(append([]interface{}{}, args...), condArgs...)
+	}
+
+	query := `SELECT ` + userColumns + ` FROM users WHERE ` + pageWhere + ` ORDER BY ` + orderBy(keys, before)
+	if q.Limit > 0 {
+		query += fmt.Sprintf(` LIMIT %d`, q.Limit)
+	}
+	if q.Offset > 0 {
+		if q.Limit == 0 {
+			query += ` LIMIT -1`
+		}
+		query += fmt.Sprintf(` OFFSET %d`, q.Offset)
+	}
+	us, err := r.queryUsers(query, pageArgs...)
+	if err != nil {
+		return Page{}, err
+	}
+	if before {
+		for i, j := 0, len(us)-1; i < j; i, j = i+1, j-1 {
+			us[i], us[j] = us[j], us[i]
+		}
+	}
+
+	offset := q.Offset
+	if q.Cursor != "" {
+		// The position of the page is how many users sort before its first one.
+		offset = 0
+		if len(us) > 0 {
+			cond, condArgs := keysetCondition(keys, us[0], true)
+			err := r.conn().QueryRow(`SELECT COUNT(*) FROM users WHERE `+where+` AND `+cond,
+				append(append([]interface{}{}, args...), condArgs...)...).Scan(&offset)
+			if err != nil {
+				return Page{}, err
+			}
+		} else if !before {
+			offset = total
+		}
+	}
+	return newPage(us, total, offset, keys)
+}
+
+func (r *sqlRepository) queryUsers(query string, args ...interface{}) ([]User, error) {
+	rows, err := r.conn().Query(query, args...)
+	if err != nil {
+		return []User{}, err
+	}
+	defer rows.Close()
+
+	us := []User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return []User{}, err
+		}
+		us = append(us, u)
+	}
+	return us, rows.Err()
+}
+
+func sqlFilters(q Query) (string, []interface{}) {
+	conds := []string{"deleted_at = ''"}
+	if q.Trashed {
+		conds[0] = "deleted_at != ''"
+	}
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		conds = append(conds, cond)
+		args = append(args, arg)
+	}
+	if q.Name != "" {
+		add(`name LIKE ? ESCAPE '\'`, escapeLike(q.Name)+"%")
+	}
+	if q.Lastname != "" {
+		add(`lastname LIKE ? ESCAPE '\'`, escapeLike(q.Lastname)+"%")
+	}
+	if q.Email != "" {
+		add(`email = ? COLLATE NOCASE`, q.Email)
+	}
+	if q.MinAge != nil {
+		add(`age >= ?`, *q.MinAge)
+	}
+	if q.MaxAge != nil {
+		add(`age <= ?`, *q.MaxAge)
+	}
+	if q.MinHeight != nil {
+		add(`height >= ?`, *q.MinHeight)
+	}
+	if q.MaxHeight != nil {
+		add(`height <= ?`, *q.MaxHeight)
+	}
+	if q.Active != nil {
+		add(`active = ?`, *q.Active)
+	}
+	if q.CreatedFrom != nil {
+		add(`julianday(created_at) >= julianday(?)`, q.CreatedFrom.Format(time.RFC3339Nano))
+	}
+	if q.CreatedTo != nil {
+		add(`julianday(created_at) <= julianday(?)`, q.CreatedTo.Format(time.RFC3339Nano))
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// keysetCondition selects the users sorted after at, or before it.
+func keysetCondition(keys []SortKey, at User, before bool) (string, []interface{}) {
+	var ors []string
+	var args []interface{}
+	for i, k := range keys {
+		var ands []string
+		for _, prev := range keys[:i] {
+			f := sortFields[prev.Field]
+			ands = append(ands, f.column+` = `+placeholder(prev.Field))
+			args = append(args, f.value(at))
+		}
+		op := ">"
+		if k.Desc != before {
+			op = "<"
+		}
+		f := sortFields[k.Field]
+		ands = append(ands, f.column+` `+op+` `+placeholder(k.Field))
+		args = append(args, f.value(at))
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+	return "(" + strings.Join(ors, " OR ") + ")", args
+}
+
+func placeholder(field string) string {
+	if field == "created_at" || field == "updated_at" || field == "deleted_at" {
+		return "julianday(?)"
+	}
+	return "?"
+}
+
+func orderBy(keys []SortKey, reverse bool) string {
+	terms := make([]string, len(keys))
+	for i, k := range keys {
+		terms[i] = sortFields[k.Field].column
+		if k.Desc != reverse {
+			terms[i] += " DESC"
+		}
+	}
+	return strings.Join(terms, ", ")
+}
+
+func (r *sqlRepository) GetById(id uint) (User, error) {
+	return scanUser(r.conn().QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ? AND deleted_at = ''`, id))
+}
+
+func (r *sqlRepository) GetVersion(id, version uint) (User, error) {
+	u, err := scanUser(r.conn().QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ? AND version = ?
+		UNION ALL SELECT `+userColumns+` FROM user_versions WHERE id = ? AND version = ?`, id, version, id, version))
+	var notFoundErr *NotFoundError
+	if !errors.As(err, &notFoundErr) {
+		return u, err
+	}
+	var exists bool
+	if err := r.conn().QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE id = ?)`, id).Scan(&exists); err != nil {
+		return User{}, err
+	}
+	if !exists {
+		return User{}, &NotFoundError{}
+	}
+	return User{}, &VersionNotFoundError{ID: id, Version: version}
+}
+
+func (r *sqlRepository) Modify(id, version uint, fn func(u *User) error) (User, error) {
+	var u User
+	err := r.inTx(func(q querier) error {
+		var err error
+		u, err = scanUser(q.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ? AND deleted_at = ''`, id))
+		if err != nil {
+			return err
+		}
+		if version != 0 && u.Version != version {
+			return &ConflictError{ID: id, Expected: version, Actual: u.Version}
+		}
+		if err := fn(&u); err != nil {
+			return err
+		}
+		row := q.QueryRow(`UPDATE users SET name = ?, lastname = ?, email = ?, age = ?, height = ?, active = ?, updated_at = ?,
+			version = version + 1 WHERE id = ? RETURNING `+userColumns, u.Name, u.Lastname, u.Email, u.Age, u.Height, u.Active, now(), id)
+		u, err = scanUser(row)
+		return err
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return u, nil
+}
@@ -0,0 +1,150 @@
+package users
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Duarte64/go-web-meli/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newSQLRepositoryTest(t *testing.T) Repository {
+	db := store.NewSQLite(filepath.Join(t.TempDir(), "users.db"))
+	t.Cleanup(func() { db.Close() })
+
+	conn, err := db.DB()
+	require.NoError(t, err)
+	repository, err := NewSQLRepository(conn)
+	require.NoError(t, err)
+
+	_, err = repository.Store(1, "Jane", "Doe", "jane.doe@gmail.com", "2019-02-01 00:00:00", 28, 1.7, true)
+	require.NoError(t, err)
+	_, err = repository.Store(2, "Gabriel", "Duarte", "gabriel.figueiredo@mercadolivre.com", "2024-04-12 11:04:19", 23, 1.7, true)
+	require.NoError(t, err)
+	return repository
+}
+
+func TestSQLGetAll(t *testing.T) {
+	repository := newSQLRepositoryTest(t)
+
+	page, err := repository.GetAll(Query{})
+	us := page.Users
+
+	assert.NoError(t, err)
+	assert.Len(t, us, 2)
+	assert.Equal(t, uint(1), us[0].ID)
+	assert.Equal(t, uint(2), us[1].ID)
+}
+
+func TestSQLLastId(t *testing.T) {
+	repository := newSQLRepositoryTest(t)
+
+	id, err := repository.LastId()
+
+	assert.NoError(t, err)
+	assert.Equal(t, uint(2), id)
+}
+
+func TestSQLGetById(t *testing.T) {
+	repository := newSQLRepositoryTest(t)
+
+	us, err := repository.GetById(2)
+	assert.NoError(t, err)
+	assert.Equal(t, "Gabriel", us.Name)
+
+	_, err = repository.GetById(20)
+	assert.IsType(t, &NotFoundError{}, err)
+}
+
+func TestSQLUpdate(t *testing.T) {
+	repository := newSQLRepositoryTest(t)
+
+	us, err := repository.Update(1, 0, "After Update", "Test", "test@test.com", 22, 1.8, false)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "After Update", us.Name)
+	assert.Equal(t, "2019-02-01 00:00:00", us.CreatedAt)
+	assert.False(t, us.Active)
+
+	_, err = repository.Update(30, 0, "After Update", "Test", "test@test.com", 22, 1.8, false)
+	assert.IsType(t, &NotFoundError{}, err)
+}
+
+func TestSQLModify(t *testing.T) {
+	repository := newSQLRepositoryTest(t)
+
+	us, err := repository.Modify(1, 0, func(u *User) error {
+		u.Age = 45
+		u.Active = false
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Doe", us.Lastname)
+	assert.Equal(t, 45, us.Age)
+	assert.False(t, us.Active)
+
+	_, err = repository.Modify(1, 0, func(u *User) error { return errors.New("aborted") })
+	assert.EqualError(t, err, "aborted")
+	us, _ = repository.GetById(1)
+	assert.Equal(t, 45, us.Age)
+
+	_, err = repository.Modify(20, 0, func(u *User) error { return nil })
+	assert.IsType(t, &NotFoundError{}, err)
+}
+
+func TestSQLDelete(t *testing.T) {
+	repository := newSQLRepositoryTest(t)
+
+	assert.NoError(t, repository.Delete(1, 0))
+	assert.IsType(t, &NotFoundError{}, repository.Delete(1, 0))
+
+	page, err := repository.GetAll(Query{})
+	assert.NoError(t, err)
+	assert.Len(t, page.Users, 1)
+}
+
+func TestSQLPurgedIDsNotReused(t *testing.T) {
+	repository := newSQLRepositoryTest(t)
+
+	require.NoError(t, repository.Delete(2, 0))
+	n, err := repository.Purge(time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	id, err := repository.LastId()
+	assert.NoError(t, err)
+	assert.Equal(t, uint(2), id)
+	u, err := repository.Store(2, "Ana", "Souza", "ana@meli.com", "2024-05-01T00:00:00Z", 30, 1.6, true)
+	require.NoError(t, err)
+	assert.Equal(t, uint(3), u.ID)
+	us, err := repository.StoreAll([]User{{Name: "Rui", Lastname: "Lima", Email: "rui@meli.com", CreatedAt: "2024-05-01T00:00:00Z"}})
+	require.NoError(t, err)
+	assert.Equal(t, uint(4), us[0].ID)
+}
+
+func TestSQLMigratesExistingTable(t *testing.T) {
+	db := store.NewSQLite(filepath.Join(t.TempDir(), "users.db"))
+	t.Cleanup(func() { db.Close() })
+	conn, err := db.DB()
+	require.NoError(t, err)
+
+	_, err = conn.Exec(createUsersTable)
+	require.NoError(t, err)
+	_, err = conn.Exec(`INSERT INTO users VALUES (1, 'Jane', 'Doe', 'jane.doe@gmail.com', 28, 1.7, 1, '2019-02-01 00:00:00')`)
+	require.NoError(t, err)
+
+	repository, err := NewSQLRepository(conn)
+	require.NoError(t, err)
+
+	us, err := repository.GetById(1)
+	assert.NoError(t, err)
+	assert.Equal(t, "2019-02-01T00:00:00Z", us.CreatedAt)
+	assert.Equal(t, "2019-02-01T00:00:00Z", us.UpdatedAt)
+
+	_, err = NewSQLRepository(conn)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,390 @@
+package users
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Format is a file format users are imported from and exported to.
+type Format string
+
+const (
+	CSV Format = "csv"
+	// JSONL is JSON Lines, one user object per line.
+	JSONL Format = "jsonl"
+)
+
+// MaxImportRows is the largest number of users a single import may hold.
+const MaxImportRows = 10000
+
+// importFields are the columns an import must have, after mapping. Any
+// other column is ignored, so that an export can be imported back.
+var importFields = []string{"name", "lastname", "email", "age", "height", "active"}
+
+// InvalidImportError is returned when the file as a whole can't be read.
+type InvalidImportError struct {
+	Message string
+}
+
+func (e *InvalidImportError) Error() string {
+	return e.Message
+}
+
+// ImportRow is a user read from an import file. Line is where the row
+// starts in the file and Err is set when its values could not be parsed.
+type ImportRow struct {
+	Line int
+	User User
+	Err  error
+}
+
+// ReadImport parses every row of r. mapping renames the columns of a CSV
+// header, or the keys of each JSON line, to the user fields they hold.
+func ReadImport(r io.Reader, format Format, mapping map[string]string) ([]ImportRow, error) {
+	for column, field := range mapping {
+		if !isImportField(field) {
+			return nil, &InvalidImportError{Message: fmt.Sprintf("mapeamento inválido: %s:%s", column, field)}
+		}
+	}
+	rename := func(column string) string {
+		column = strings.TrimSpace(column)
+		if field, ok := mapping[column]; ok {
+			return field
+		}
+		return column
+	}
+
+	switch format {
+	case CSV:
+		return readCSV(r, rename)
+	case JSONL:
+		return readJSONL(r, rename)
+	}
+	return nil, &InvalidImportError{Message: fmt.Sprintf("formato desconhecido: %s", format)}
+}
+
+func isImportField(field string) bool {
+	for _, f := range importFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+func readCSV(r io.Reader, rename func(string) string) ([]ImportRow, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, &InvalidImportError{Message: "arquivo vazio"}
+	}
+	if err != nil {
+		return nil, &InvalidImportError{Message: err.Error()}
+	}
+	columns := map[string]int{}
+	for i, column := range header {
+		// Spreadsheets often save CSV with a byte order mark.
+		columns[rename(strings.TrimPrefix(column, "\ufeff"))] = i
+	}
+	for _, field := range importFields {
+		if _, ok := columns[field]; !ok {
+			return nil, &InvalidImportError{Message: fmt.Sprintf("coluna obrigatória ausente: %s", field)}
+		}
+	}
+
+	rows := []ImportRow{}
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+		if err != nil && !errors.Is(err, csv.ErrFieldCount) {
+			return nil, &InvalidImportError{Message: err.Error()}
+		}
+		if len(rows) == MaxImportRows {
+			return nil, &InvalidImportError{Message: fmt.Sprintf("o arquivo deve ter no máximo %d usuários", MaxImportRows)}
+		}
+		line, _ := reader.FieldPos(0)
+		if err != nil {
+			rows = append(rows, ImportRow{Line: line, Err: fmt.Errorf("a linha deve ter %d colunas", len(header))})
+			continue
+		}
+		values := map[string]string{}
+		for field, i := range columns {
+			values[field] = record[i]
+		}
+		rows = append(rows, parseRow(line, values))
+	}
+}
+
+func readJSONL(r io.Reader, rename func(string) string) ([]ImportRow, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	rows := []ImportRow{}
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		if len(rows) == MaxImportRows {
+			return nil, &InvalidImportError{Message: fmt.Sprintf("o arquivo deve ter no máximo %d usuários", MaxImportRows)}
+		}
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(text, &object); err != nil {
+			rows = append(rows, ImportRow{Line: line, Err: fmt.Errorf("JSON inválido: %w", err)})
+			continue
+		}
+		values := map[string]string{}
+		for key, raw := range object {
+			// Strings are unquoted so that every value is parsed the same way
+			// as a CSV field.
+			var s string
+			if err := json.Unmarshal(raw, &s); err != nil {
+				s = string(raw)
+			}
+			values[rename(key)] = s
+		}
+		rows = append(rows, parseRow(line, values))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, &InvalidImportError{Message: err.Error()}
+	}
+	return rows, nil
+}
+
+// parseRow converts the values of a row, by user field, into a user.
+func parseRow(line int, values map[string]string) ImportRow {
+	v := &validator{}
+	u := User{}
+	value := func(field string) (string, bool) {
+		s, ok := values[field]
+		s = strings.TrimSpace(s)
+		if !ok || s == "" {
+			v.add(field, "é obrigatório")
+			return "", false
+		}
+		return s, true
+	}
+
+	if s, ok := value("name"); ok {
+		u.Name = s
+	}
+	if s, ok := value("lastname"); ok {
+		u.Lastname = s
+	}
+	if s, ok := value("email"); ok {
+		u.Email = s
+	}
+	if s, ok := value("age"); ok {
+		age, err := strconv.Atoi(s)
+		if err != nil {
+			v.add("age", "deve ser um número inteiro")
+		}
+		u.Age = age
+	}
+	if s, ok := value("height"); ok {
+		height, err := strconv.ParseFloat(strings.Replace(s, ",", ".", 1), 64)
+		if err != nil {
+			v.add("height", "deve ser um número")
+		}
+		u.Height = height
+	}
+	if s, ok := value("active"); ok {
+		active, err := strconv.ParseBool(s)
+		if err != nil {
+			v.add("active", "deve ser true ou false")
+		}
+		u.Active = active
+	}
+	return ImportRow{Line: line, User: u, Err: v.err()}
+}
+
+// Exporter writes users to a file in one of the export formats.
+type Exporter struct {
+	format Format
+	csv    *csv.Writer
+	json   *json.Encoder
+	header bool
+}
+
+var exportColumns = []string{"id", "name", "lastname", "email", "age", "height", "active", "created_at", "updated_at", "version"}
+
+func NewExporter(w io.Writer, format Format) (*Exporter, error) {
+	switch format {
+	case CSV:
+		return &Exporter{format: format, csv: csv.NewWriter(w)}, nil
+	case JSONL:
+		return &Exporter{format: format, json: json.NewEncoder(w)}, nil
+	}
+	return nil, fmt.Errorf("formato desconhecido: %s", format)
+}
+
+func (e *Exporter) Write(u User) error {
+	if e.format == JSONL {
+		return e.json.Encode(u)
+	}
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	return e.csv.Write([]string{
+		strconv.FormatUint(uint64(u.ID), 10), u.Name, u.Lastname, u.Email, strconv.Itoa(u.Age),
+		strconv.FormatFloat(u.Height, 'f', -1, 64), strconv.FormatBool(u.Active), u.CreatedAt, u.UpdatedAt,
+		strconv.FormatUint(uint64(u.Version), 10),
+	})
+}
+
+// Flush writes anything buffered, a CSV export always has its header even
+// when there are no users.
+func (e *Exporter) Flush() error {
+	if e.format == JSONL {
+		return nil
+	}
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	e.csv.Flush()
+	return e.csv.Error()
+}
+
+func (e *Exporter) writeHeader() error {
+	if e.header {
+		return nil
+	}
+	e.header = true
+	return e.csv.Write(exportColumns)
+}
+
+// Import row statuses.
+const (
+	ImportCreated = "created"
+	ImportFailed  = "failed"
+	// ImportSkipped rows were valid but not stored because the atomic import
+	// they were part of failed.
+	ImportSkipped = "skipped"
+)
+
+type ImportResult struct {
+	Line    int          `json:"line"`
+	Status  string       `json:"status"`
+	ID      uint         `json:"id,omitempty"`
+	Error   string       `json:"error,omitempty"`
+	Details []FieldError `json:"details,omitempty"`
+}
+
+type ImportReport struct {
+	Created int            `json:"created"`
+	Failed  int            `json:"failed"`
+	Rows    []ImportResult `json:"rows"`
+}
+
+func (r *ImportReport) add(line int, u User, err error) {
+	result := ImportResult{Line: line, Status: ImportCreated, ID: u.ID}
+	if err != nil {
+		result = ImportResult{Line: line, Status: ImportFailed, Error: err.Error()}
+		var validationErr *ValidationError
+		if errors.As(err, &validationErr) {
+			result.Details = validationErr.Fields
+		}
+		r.Failed++
+	} else {
+		r.Created++
+	}
+	r.Rows = append(r.Rows, result)
+}
+
+func (s *service) Import(rows []ImportRow, atomic bool) (ImportReport, error) {
+	report := ImportReport{Rows: []ImportResult{}}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	// The rows are checked against each other as well. When not atomic the
+	// earlier row is created, so the email of the later one is in use.
+	emails := map[string]int{}
+	errs := make([]error, len(rows))
+	failed := false
+	for i, row := range rows {
+		if errs[i] = row.Err; errs[i] == nil {
+			v := validateFields(row.User)
+			if err := s.checkEmailUnique(v, row.User); err != nil {
+				return report, err
+			}
+			email := strings.ToLower(row.User.Email)
+			if line, ok := emails[email]; ok && atomic {
+				v.add("email", fmt.Sprintf("repetido na linha %d", line))
+			} else if ok {
+				v.add("email", "já está em uso")
+			}
+			if errs[i] = v.err(); errs[i] == nil {
+				emails[email] = row.Line
+			}
+		}
+		failed = failed || errs[i] != nil
+	}
+	if atomic && failed {
+		for i, row := range rows {
+			if errs[i] != nil {
+				report.add(row.Line, User{}, errs[i])
+			} else {
+				report.Rows = append(report.Rows, ImportResult{Line: row.Line, Status: ImportSkipped})
+			}
+		}
+		return report, nil
+	}
+
+	// The valid rows are stored in a single write, rather than one per row.
+	date := now()
+	us := []User{}
+	for i, row := range rows {
+		if errs[i] == nil {
+			u := row.User
+			u.CreatedAt = date
+			us = append(us, u)
+		}
+	}
+	var created []User
+	if len(us) > 0 {
+		var err error
+		if created, err = s.repository.StoreAll(us); err != nil {
+			return report, err
+		}
+	}
+	for i, row := range rows {
+		if errs[i] != nil {
+			report.add(row.Line, User{}, errs[i])
+			continue
+		}
+		u := created[0]
+		created = created[1:]
+		s.indexUser(u)
+		s.record(OpCreate, u.ID, nil, &u)
+		report.add(row.Line, u, nil)
+	}
+	return report, nil
+}
+
+func (s *service) Export(q Query, fn func(u User) error) error {
+	q.Limit, q.Offset, q.Cursor = MaxLimit, 0, ""
+	for {
+		page, err := s.repository.GetAll(q)
+		if err != nil {
+			return err
+		}
+		for _, u := range page.Users {
+			if err := fn(u); err != nil {
+				return err
+			}
+		}
+		if page.Next == "" {
+			return nil
+		}
+		q.Cursor = page.Next
+	}
+}
@@ -0,0 +1,153 @@
+package users
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/Duarte64/go-web-meli/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadImportCSV(t *testing.T) {
+	file := "nome,sobrenome,email,idade,altura,active,extra\n" +
+		"José,Silva,jose@example.com,30,\"1,75\",true,x\n" +
+		"Ana,,ana@example.com,trinta,1.6,sim,x\n" +
+		"Bia,Souza\n"
+
+	rows, err := ReadImport(strings.NewReader(file), CSV, map[string]string{
+		"nome": "name", "sobrenome": "lastname", "idade": "age", "altura": "height",
+	})
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+
+	assert.Equal(t, ImportRow{Line: 2, User: User{
+		Name: "José", Lastname: "Silva", Email: "jose@example.com", Age: 30, Height: 1.75, Active: true,
+	}}, rows[0])
+	assert.Equal(t, 3, rows[1].Line)
+	assert.Equal(t, &ValidationError{Fields: []FieldError{
+		{Field: "lastname", Message: "é obrigatório"},
+		{Field: "age", Message: "deve ser um número inteiro"},
+		{Field: "active", Message: "deve ser true ou false"},
+	}}, rows[1].Err)
+	assert.Equal(t, 4, rows[2].Line)
+	assert.Error(t, rows[2].Err)
+
+	_, err = ReadImport(strings.NewReader("name,email\n"), CSV, nil)
+	assert.IsType(t, &InvalidImportError{}, err)
+	_, err = ReadImport(strings.NewReader(file), CSV, map[string]string{"nome": "id"})
+	assert.IsType(t, &InvalidImportError{}, err)
+}
+
+func TestReadImportJSONL(t *testing.T) {
+	file := `{"name": "José", "lastname": "Silva", "email": "jose@example.com", "age": 30, "height": 1.75, "active": false, "id": 9}
+
+{"name": "Ana"
+`
+	rows, err := ReadImport(strings.NewReader(file), JSONL, nil)
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	assert.Equal(t, ImportRow{Line: 1, User: User{
+		Name: "José", Lastname: "Silva", Email: "jose@example.com", Age: 30, Height: 1.75,
+	}}, rows[0])
+	assert.Equal(t, 3, rows[1].Line)
+	assert.Error(t, rows[1].Err)
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	for _, format := range []Format{CSV, JSONL} {
+		var buf bytes.Buffer
+		exporter, err := NewExporter(&buf, format)
+		require.NoError(t, err)
+		u := User{ID: 1, Name: "José", Lastname: "Silva, Jr", Email: "jose@example.com", Age: 30, Height: 1.75, Active: true, Version: 2}
+		require.NoError(t, exporter.Write(u))
+		require.NoError(t, exporter.Flush())
+
+		rows, err := ReadImport(&buf, format, nil)
+		require.NoError(t, err, format)
+		require.Len(t, rows, 1, format)
+		assert.NoError(t, rows[0].Err)
+		assert.Equal(t, User{Name: u.Name, Lastname: u.Lastname, Email: u.Email, Age: u.Age, Height: u.Height, Active: true}, rows[0].User)
+	}
+}
+
+func importRows(emails ...string) []ImportRow {
+	rows := make([]ImportRow, len(emails))
+	for i, email := range emails {
+		rows[i] = ImportRow{Line: i + 2, User: User{Name: "Nome", Lastname: "Sobrenome", Email: email, Age: 30, Height: 1.7, Active: true}}
+	}
+	return rows
+}
+
+// countingStore counts the updates of the store it wraps.
+type countingStore struct {
+	store.Store
+	updates int
+}
+
+func (s *countingStore) Update(data interface{}, fn func() error) error {
+	s.updates++
+	return s.Store.Update(data, fn)
+}
+
+func TestServiceImport(t *testing.T) {
+	db := &countingStore{Store: store.NewMemory("", 0)}
+	service := NewService(NewRepository(db))
+
+	report, err := service.Import(importRows("a@example.com", "b@example.com", "A@example.com"), false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, db.updates, "the valid rows are stored in a single write")
+	assert.Equal(t, 2, report.Created)
+	assert.Equal(t, 1, report.Failed)
+	assert.Equal(t, ImportResult{Line: 4, Status: ImportFailed, Error: "dados inválidos: email: já está em uso",
+		Details: []FieldError{{Field: "email", Message: "já está em uso"}}}, report.Rows[2])
+
+	results, err := service.Search("nome", 0)
+	require.NoError(t, err)
+	assert.Len(t, results, 2)
+}
+
+func TestServiceImportAtomic(t *testing.T) {
+	for name, repository := range queryRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			service := NewService(repository)
+			before, err := repository.GetAll(Query{})
+			require.NoError(t, err)
+
+			report, err := service.Import(importRows("c@example.com", "d@example.com", "C@example.com"), true)
+			require.NoError(t, err)
+			assert.Equal(t, 0, report.Created)
+			assert.Equal(t, []string{ImportSkipped, ImportSkipped, ImportFailed},
+				[]string{report.Rows[0].Status, report.Rows[1].Status, report.Rows[2].Status})
+			assert.Equal(t, []FieldError{{Field: "email", Message: "repetido na linha 2"}}, report.Rows[2].Details)
+			after, err := repository.GetAll(Query{})
+			require.NoError(t, err)
+			assert.Equal(t, before.Total, after.Total)
+
+			report, err = service.Import(importRows("c@example.com", "d@example.com"), true)
+			require.NoError(t, err)
+			assert.Equal(t, 2, report.Created)
+			assert.Equal(t, uint(before.Total+2), report.Rows[1].ID)
+			u, err := repository.GetById(report.Rows[1].ID)
+			require.NoError(t, err)
+			assert.Equal(t, "d@example.com", u.Email)
+			assert.Equal(t, uint(1), u.Version)
+		})
+	}
+}
+
+func TestServiceExport(t *testing.T) {
+	for name, repository := range queryRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			service := NewService(repository)
+			var exported []User
+			err := service.Export(Query{Sort: []SortKey{{Field: "id", Desc: true}}, Limit: 1}, func(u User) error {
+				exported = append(exported, u)
+				return nil
+			})
+			require.NoError(t, err)
+			assert.Equal(t, []uint{5, 4, 3, 2, 1}, ids(exported))
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package users
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+)
+
+// Limits of the values a User may hold.
+const (
+	MinNameLength  = 2
+	MaxNameLength  = 100
+	MaxEmailLength = 254
+	MinAge         = 1
+	MaxAge         = 150
+	MinHeight      = 0.3
+	MaxHeight      = 3.0
+)
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every field of a user that failed validation.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		messages[i] = f.Field + ": " + f.Message
+	}
+	return "dados inválidos: " + strings.Join(messages, "; ")
+}
+
+type validator struct {
+	fields []FieldError
+}
+
+func (v *validator) add(field, message string) {
+	v.fields = append(v.fields, FieldError{Field: field, Message: message})
+}
+
+func (v *validator) err() error {
+	if len(v.fields) == 0 {
+		return nil
+	}
+	return &ValidationError{Fields: v.fields}
+}
+
+func (v *validator) name(field, value string) {
+	if n := utf8.RuneCountInString(strings.TrimSpace(value)); n < MinNameLength || n > MaxNameLength {
+		v.add(field, fmt.Sprintf("deve ter entre %d e %d caracteres", MinNameLength, MaxNameLength))
+	}
+}
+
+func (v *validator) email(value string) {
+	addr, err := mail.ParseAddress(value)
+	switch {
+	case len(value) > MaxEmailLength:
+		v.add("email", fmt.Sprintf("deve ter no máximo %d caracteres", MaxEmailLength))
+	case err != nil || addr.Address != value || !strings.Contains(value[strings.LastIndex(value, "@"):], "."):
+		v.add("email", "formato inválido")
+	}
+}
+
+func (v *validator) age(value int) {
+	if value < MinAge || value > MaxAge {
+		v.add("age", fmt.Sprintf("deve estar entre %d e %d", MinAge, MaxAge))
+	}
+}
+
+func (v *validator) height(value float64) {
+	if value < MinHeight || value > MaxHeight {
+		v.add("height", fmt.Sprintf("deve estar entre %.1f e %.1f", MinHeight, MaxHeight))
+	}
+}
+
+// validateUser checks every field of u, including that no other live user
+// has the same email, ignoring case.
+func (s *service) validateUser(u User) error {
+	v := validateFields(u)
+	if err := s.checkEmailUnique(v, u); err != nil {
+		return err
+	}
+	return v.err()
+}
+
+func validateFields(u User) *validator {
+	v := &validator{}
+	v.name("name", u.Name)
+	v.name("lastname", u.Lastname)
+	v.email(u.Email)
+	v.age(u.Age)
+	v.height(u.Height)
+	return v
+}
+
+func (s *service) checkEmailUnique(v *validator, u User) error {
+	if u.Email == "" {
+		return nil
+	}
+	page, err := s.repository.GetAll(Query{Email: u.Email})
+	if err != nil {
+		return err
+	}
+	for _, other := range page.Users {
+		if other.ID != u.ID {
+			v.add("email", "já está em uso")
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,329 @@
+// Package jwt signs and verifies JSON Web Tokens (RFC 7519) in the compact
+// JWS form, with HS256, RS256 or EdDSA (Ed25519) signatures.
+package jwt
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Algorithms.
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+)
+
+// MinSecretLength is the shortest HS256 secret accepted, the size of the
+// SHA-256 output.
+const MinSecretLength = 32
+
+var (
+	ErrMalformed = errors.New("jwt: malformed token")
+	// ErrAlgorithm is returned when the token is not signed with the
+	// algorithm of the key, which also rejects unsigned "none" tokens.
+	ErrAlgorithm   = errors.New("jwt: unexpected signing algorithm")
+	ErrSignature   = errors.New("jwt: invalid signature")
+	ErrExpired     = errors.New("jwt: token is expired")
+	ErrNotYetValid = errors.New("jwt: token is not valid yet")
+	ErrIssuer      = errors.New("jwt: invalid issuer")
+	ErrAudience    = errors.New("jwt: invalid audience")
+	ErrCannotSign  = errors.New("jwt: key cannot sign")
+)
+
+// Audience is the aud claim, a single string or a list of them.
+type Audience []string
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*a = Audience{s}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(a))
+}
+
+func (a Audience) contains(aud string) bool {
+	for _, s := range a {
+		if s == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// Claims are the registered claims of a token, times are Unix seconds and
+// zero when absent. Extra holds every other claim.
+type Claims struct {
+	Subject   string                 `json:"sub,omitempty"`
+	Issuer    string                 `json:"iss,omitempty"`
+	Audience  Audience               `json:"aud,omitempty"`
+	ExpiresAt int64                  `json:"exp,omitempty"`
+	NotBefore int64                  `json:"nbf,omitempty"`
+	IssuedAt  int64                  `json:"iat,omitempty"`
+	ID        string                 `json:"jti,omitempty"`
+	Extra     map[string]interface{} `json:"-"`
+}
+
+type registered Claims
+
+func (c Claims) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(registered(c))
+	if err != nil || len(c.Extra) == 0 {
+		return data, err
+	}
+	m := map[string]interface{}{}
+	for k, v := range c.Extra {
+		m[k] = v
+	}
+	// The registered claims win over extra ones with the same name.
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return json.Marshal(m)
+}
+
+func (c *Claims) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, (*registered)(c)); err != nil {
+		return err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	for _, k := range []string{"sub", "iss", "aud", "exp", "nbf", "iat", "jti"} {
+		delete(m, k)
+	}
+	c.Extra = nil
+	if len(m) > 0 {
+		c.Extra = m
+	}
+	return nil
+}
+
+// String returns the extra claim name when it is a string.
+func (c Claims) String(name string) string {
+	s, _ := c.Extra[name].(string)
+	return s
+}
+
+// Validation is what Parse checks besides the signature. A token must
+// always carry an expiry.
+type Validation struct {
+	// Issuer and Audience are required in the token when set.
+	Issuer   string
+	Audience string
+	// Leeway is the clock skew tolerated on exp and nbf.
+	Leeway time.Duration
+	// Now defaults to time.Now.
+	Now func() time.Time
+}
+
+func (v Validation) check(c Claims) error {
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+	if c.ExpiresAt == 0 || !now.Before(time.Unix(c.ExpiresAt, 0).Add(v.Leeway)) {
+		return ErrExpired
+	}
+	if c.NotBefore != 0 && now.Add(v.Leeway).Before(time.Unix(c.NotBefore, 0)) {
+		return ErrNotYetValid
+	}
+	if v.Issuer != "" && c.Issuer != v.Issuer {
+		return ErrIssuer
+	}
+	if v.Audience != "" && !c.Audience.contains(v.Audience) {
+		return ErrAudience
+	}
+	return nil
+}
+
+// Key signs and verifies tokens with a single algorithm. Keys loaded from
+// a public key only can verify but not sign.
+type Key struct {
+	Algorithm string
+	secret    []byte
+	private   crypto.Signer
+	public    crypto.PublicKey
+}
+
+func NewHMACKey(secret []byte) (*Key, error) {
+	if len(secret) < MinSecretLength {
+		return nil, fmt.Errorf("jwt: the secret must have at least %d bytes", MinSecretLength)
+	}
+	return &Key{Algorithm: HS256, secret: secret}, nil
+}
+
+// ParseKey reads the PEM encoded keys of an RS256 or EdDSA key, either may
+// be empty. Private keys are PKCS #8 or, for RSA, PKCS #1, public keys are
+// PKIX.
+func ParseKey(alg string, privatePEM, publicPEM []byte) (*Key, error) {
+	if alg != RS256 && alg != EdDSA {
+		return nil, fmt.Errorf("jwt: unknown algorithm %q", alg)
+	}
+	k := &Key{Algorithm: alg}
+	if len(privatePEM) > 0 {
+		block, _ := pem.Decode(privatePEM)
+		if block == nil {
+			return nil, errors.New("jwt: invalid private key PEM")
+		}
+		private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil && alg == RS256 {
+			private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("jwt: invalid private key: %w", err)
+		}
+		signer, ok := private.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("jwt: unsupported private key")
+		}
+		k.private, k.public = signer, signer.Public()
+	}
+	if len(publicPEM) > 0 {
+		block, _ := pem.Decode(publicPEM)
+		if block == nil {
+			return nil, errors.New("jwt: invalid public key PEM")
+		}
+		public, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("jwt: invalid public key: %w", err)
+		}
+		k.public = public
+	}
+	if k.public == nil {
+		return nil, errors.New("jwt: no key given")
+	}
+	switch k.public.(type) {
+	case *rsa.PublicKey:
+		if alg == RS256 {
+			return k, nil
+		}
+	case ed25519.PublicKey:
+		if alg == EdDSA {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("jwt: the key is not a %s key", alg)
+}
+
+func (k *Key) CanSign() bool {
+	return k.secret != nil || k.private != nil
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+}
+
+func (k *Key) Sign(c Claims) (string, error) {
+	if !k.CanSign() {
+		return "", ErrCannotSign
+	}
+	h, err := json.Marshal(header{Alg: k.Algorithm, Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	input := encode(h) + "." + encode(payload)
+
+	var signature []byte
+	switch k.Algorithm {
+	case HS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write([]byte(input))
+		signature = mac.Sum(nil)
+	case RS256:
+		digest := sha256.Sum256([]byte(input))
+		signature, err = k.private.Sign(rand.Reader, digest[:], crypto.SHA256)
+	case EdDSA:
+		signature, err = k.private.Sign(rand.Reader, []byte(input), crypto.Hash(0))
+	}
+	if err != nil {
+		return "", err
+	}
+	return input + "." + encode(signature), nil
+}
+
+// Parse verifies the signature of token and then its claims.
+func (k *Key) Parse(token string, v Validation) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, ErrMalformed
+	}
+	var h header
+	if err := decodeJSON(parts[0], &h); err != nil {
+		return Claims{}, err
+	}
+	if h.Alg != k.Algorithm {
+		return Claims{}, ErrAlgorithm
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, ErrMalformed
+	}
+	if !k.verify(parts[0]+"."+parts[1], signature) {
+		return Claims{}, ErrSignature
+	}
+
+	var c Claims
+	if err := decodeJSON(parts[1], &c); err != nil {
+		return Claims{}, err
+	}
+	return c, v.check(c)
+}
+
+func (k *Key) verify(input string, signature []byte) bool {
+	switch k.Algorithm {
+	case HS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write([]byte(input))
+		return hmac.Equal(signature, mac.Sum(nil))
+	case RS256:
+		public, ok := k.public.(*rsa.PublicKey)
+		digest := sha256.Sum256([]byte(input))
+		return ok && rsa.VerifyPKCS1v15(public, crypto.SHA256, digest[:], signature) == nil
+	case EdDSA:
+		public, ok := k.public.(ed25519.PublicKey)
+		return ok && ed25519.Verify(public, []byte(input), signature)
+	}
+	return false
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeJSON(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return ErrMalformed
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func pemBlock(t *testing.T, kind string, der []byte, err error) []byte {
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der})
+}
+
+func TestSignAndParse(t *testing.T) {
+	hmacKey, err := NewHMACKey([]byte(strings.Repeat("s", MinSecretLength)))
+	require.NoError(t, err)
+
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	rsaKey, err := ParseKey(RS256, pemBlock(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaPrivate), nil), nil)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&rsaPrivate.PublicKey)
+	rsaPublic, err := ParseKey(RS256, nil, pemBlock(t, "PUBLIC KEY", der, err))
+	require.NoError(t, err)
+
+	edPublicKey, edPrivateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err = x509.MarshalPKCS8PrivateKey(edPrivateKey)
+	edKey, err := ParseKey(EdDSA, pemBlock(t, "PRIVATE KEY", der, err), nil)
+	require.NoError(t, err)
+	der, err = x509.MarshalPKIXPublicKey(edPublicKey)
+	edPublic, err := ParseKey(EdDSA, nil, pemBlock(t, "PUBLIC KEY", der, err))
+	require.NoError(t, err)
+
+	now := time.Unix(1700000000, 0)
+	claims := Claims{
+		Subject:   "reports",
+		Issuer:    "meli",
+		Audience:  Audience{"users-api"},
+		ExpiresAt: now.Add(time.Minute).Unix(),
+		Extra:     map[string]interface{}{"scope": "users:read"},
+	}
+	v := Validation{Issuer: "meli", Audience: "users-api", Now: func() time.Time { return now }}
+
+	for _, keys := range [][2]*Key{{hmacKey, hmacKey}, {rsaKey, rsaPublic}, {edKey, edPublic}} {
+		token, err := keys[0].Sign(claims)
+		require.NoError(t, err)
+		got, err := keys[1].Parse(token, v)
+		require.NoError(t, err, keys[0].Algorithm)
+		assert.Equal(t, claims, got)
+		assert.Equal(t, "users:read", got.String("scope"))
+
+		// Any change to the token breaks the signature.
+		parts := strings.Split(token, ".")
+		tampered, _ := hmacKey.Sign(Claims{Subject: "admin", ExpiresAt: claims.ExpiresAt})
+		_, err = keys[1].Parse(parts[0]+"."+strings.Split(tampered, ".")[1]+"."+parts[2], v)
+		assert.ErrorIs(t, err, ErrSignature)
+	}
+
+	_, err = rsaPublic.Sign(claims)
+	assert.ErrorIs(t, err, ErrCannotSign)
+	token, _ := hmacKey.Sign(claims)
+	_, err = rsaPublic.Parse(token, v)
+	assert.ErrorIs(t, err, ErrAlgorithm)
+	der, err = x509.MarshalPKIXPublicKey(&rsaPrivate.PublicKey)
+	_, err = ParseKey(EdDSA, nil, pemBlock(t, "PUBLIC KEY", der, err))
+	assert.Error(t, err)
+}
+
+func TestValidation(t *testing.T) {
+	key, _ := NewHMACKey([]byte(strings.Repeat("s", MinSecretLength)))
+	now := time.Unix(1700000000, 0)
+	parse := func(c Claims, v Validation) error {
+		token, err := key.Sign(c)
+		require.NoError(t, err)
+		v.Now = func() time.Time { return now }
+		_, err = key.Parse(token, v)
+		return err
+	}
+	exp := now.Add(time.Minute).Unix()
+
+	assert.ErrorIs(t, parse(Claims{}, Validation{}), ErrExpired)
+	assert.ErrorIs(t, parse(Claims{ExpiresAt: now.Unix()}, Validation{}), ErrExpired)
+	assert.NoError(t, parse(Claims{ExpiresAt: now.Unix()}, Validation{Leeway: time.Second}))
+	assert.ErrorIs(t, parse(Claims{ExpiresAt: exp, NotBefore: now.Add(30 * time.Second).Unix()}, Validation{Leeway: 10 * time.Second}), ErrNotYetValid)
+	assert.NoError(t, parse(Claims{ExpiresAt: exp, NotBefore: now.Add(30 * time.Second).Unix()}, Validation{Leeway: time.Minute}))
+	assert.ErrorIs(t, parse(Claims{ExpiresAt: exp, Issuer: "other"}, Validation{Issuer: "meli"}), ErrIssuer)
+	assert.ErrorIs(t, parse(Claims{ExpiresAt: exp, Audience: Audience{"a", "b"}}, Validation{Audience: "c"}), ErrAudience)
+	assert.NoError(t, parse(Claims{ExpiresAt: exp, Audience: Audience{"a", "b"}}, Validation{Audience: "b"}))
+
+	_, err := key.Parse("not.a-token", Validation{})
+	assert.ErrorIs(t, err, ErrMalformed)
+	// An unsigned token is never accepted.
+	_, err = key.Parse("eyJhbGciOiJub25lIn0.eyJleHAiOjE5MDAwMDAwMDB9.", Validation{})
+	assert.ErrorIs(t, err, ErrAlgorithm)
+
+	_, err = NewHMACKey([]byte("short"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,52 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomic replaces name with data without ever leaving a partially
+// written file behind: the data goes to a temporary file in the same
+// directory, is flushed to disk and then renamed over the original.
+func writeFileAtomic(name string, data []byte, perm os.FileMode) error {
+	dir, base := filepath.Split(name)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, base+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	// Some platforms do not support fsync on directories, the rename is
+	// already done at this point so that is not worth failing the write.
+	_ = d.Sync()
+	return nil
+}
@@ -0,0 +1,284 @@
+// Package backup takes timestamped snapshots of a store.Store and restores
+// them.
+package backup
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Duarte64/go-web-meli/pkg/store"
+)
+
+const timeLayout = "20060102T150405.000000000Z"
+
+var ErrNotFound = errors.New("backup: snapshot not found")
+
+type Snapshot struct {
+	Name       string    `json:"name"`
+	CreatedAt  time.Time `json:"created_at"`
+	Size       int64     `json:"size"`
+	Compressed bool      `json:"compressed"`
+}
+
+// Config holds the settings of a Manager that do not depend on the store.
+type Config struct {
+	Dir      string
+	Compress bool
+	// Retention is how many snapshots are kept, zero keeps all of them.
+	Retention int
+	// MaxAge removes snapshots older than it, zero keeps all of them.
+	MaxAge time.Duration
+	// Interval is how often Run takes a snapshot.
+	Interval time.Duration
+}
+
+// ConfigFromEnv reads the BACKUP_* environment variables.
+func ConfigFromEnv() Config {
+	cfg := Config{Dir: os.Getenv("BACKUP_DIR")}
+	cfg.Compress, _ = strconv.ParseBool(os.Getenv("BACKUP_GZIP"))
+	cfg.Retention, _ = strconv.Atoi(os.Getenv("BACKUP_RETENTION"))
+	cfg.MaxAge, _ = time.ParseDuration(os.Getenv("BACKUP_MAX_AGE"))
+	cfg.Interval, _ = time.ParseDuration(os.Getenv("BACKUP_INTERVAL"))
+	if cfg.Dir == "" {
+		cfg.Dir = "./backups"
+	}
+	return cfg
+}
+
+// Manager takes and restores snapshots of Store. NewData returns a pointer
+// to an empty value of the type kept in the store, it is what the data is
+// read into.
+type Manager struct {
+	Config
+	Store   store.Store
+	NewData func() interface{}
+	Prefix  string
+	// AfterRestore, when set, is called once a restore is complete, e.g. to
+	// drop caches built on top of the store.
+	AfterRestore func()
+}
+
+func NewManager(db store.Store, newData func() interface{}, prefix string, cfg Config) *Manager {
+	return &Manager{Config: cfg, Store: db, NewData: newData, Prefix: prefix}
+}
+
+// Create writes a snapshot of the current data and applies the retention.
+// Every store replaces its data atomically, so a plain Read is consistent.
+func (m *Manager) Create() (Snapshot, error) {
+	data := m.NewData()
+	if err := m.Store.Read(data); err != nil {
+		return Snapshot{}, err
+	}
+	if err := os.MkdirAll(m.Dir, 0755); err != nil {
+		return Snapshot{}, err
+	}
+
+	now := time.Now().UTC()
+	name := m.Prefix + "-" + now.Format(timeLayout) + ".json"
+	if m.Compress {
+		name += ".gz"
+	}
+	if err := m.write(filepath.Join(m.Dir, name), data); err != nil {
+		return Snapshot{}, err
+	}
+	if err := m.prune(); err != nil {
+		return Snapshot{}, err
+	}
+	return m.snapshot(name)
+}
+
+// List returns the snapshots from the oldest to the newest.
+func (m *Manager) List() ([]Snapshot, error) {
+	entries, err := os.ReadDir(m.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Snapshot{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	snapshots := []Snapshot{}
+	for _, e := range entries {
+		if e.IsDir() || !m.owns(e.Name()) {
+			continue
+		}
+		s, err := m.snapshot(e.Name())
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, s)
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].CreatedAt.Before(snapshots[j].CreatedAt)
+	})
+	return snapshots, nil
+}
+
+// Restore replaces the data of the store with the snapshot called name.
+// The snapshot is fully decoded before the store is touched and the
+// replacement happens inside Update, so readers see either the old or the
+// restored data.
+func (m *Manager) Restore(name string) error {
+	if !m.owns(name) || name != filepath.Base(name) {
+		return ErrNotFound
+	}
+	restored := m.NewData()
+	if err := m.read(filepath.Join(m.Dir, name), restored); err != nil {
+		return err
+	}
+	current := m.NewData()
+	err := m.Store.Update(current, func() error {
+		reflect.ValueOf(current).Elem().Set(reflect.ValueOf(restored).Elem())
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if m.AfterRestore != nil {
+		m.AfterRestore()
+	}
+	return nil
+}
+
+// Run takes a snapshot every Interval until ctx is done, errors are sent
+// to onError.
+func (m *Manager) Run(ctx context.Context, onError func(error)) {
+	if m.Interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := m.Create(); err != nil && onError != nil {
+				onError(err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (m *Manager) owns(name string) bool {
+	if !strings.HasPrefix(name, m.Prefix+"-") {
+		return false
+	}
+	return strings.HasSuffix(name, ".json") || strings.HasSuffix(name, ".json.gz")
+}
+
+func (m *Manager) snapshot(name string) (Snapshot, error) {
+	info, err := os.Stat(filepath.Join(m.Dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return Snapshot{}, ErrNotFound
+	}
+	if err != nil {
+		return Snapshot{}, err
+	}
+	stamp := strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(name, m.Prefix+"-"), ".gz"), ".json")
+	createdAt, err := time.Parse(timeLayout, stamp)
+	if err != nil {
+		createdAt = info.ModTime().UTC()
+	}
+	return Snapshot{
+		Name:       name,
+		CreatedAt:  createdAt,
+		Size:       info.Size(),
+		Compressed: strings.HasSuffix(name, ".gz"),
+	}, nil
+}
+
+func (m *Manager) write(path string, data interface{}) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	var w io.Writer = f
+	var gz *gzip.Writer
+	if strings.HasSuffix(path, ".gz") {
+		gz = gzip.NewWriter(f)
+		w = gz
+	}
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		f.Close()
+		return err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (m *Manager) read(path string, data interface{}) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	if err := json.NewDecoder(r).Decode(data); err != nil {
+		return fmt.Errorf("backup: invalid snapshot %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
+
+func (m *Manager) prune() error {
+	snapshots, err := m.List()
+	if err != nil {
+		return err
+	}
+	cutoff := time.Time{}
+	if m.MaxAge > 0 {
+		cutoff = time.Now().Add(-m.MaxAge)
+	}
+	for i, s := range snapshots {
+		// The newest snapshot is always kept.
+		if i == len(snapshots)-1 {
+			break
+		}
+		tooMany := m.Retention > 0 && len(snapshots)-i > m.Retention
+		tooOld := !cutoff.IsZero() && s.CreatedAt.Before(cutoff)
+		if !tooMany && !tooOld {
+			continue
+		}
+		if err := os.Remove(filepath.Join(m.Dir, s.Name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package backup
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Duarte64/go-web-meli/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type item struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+}
+
+func newManagerTest(t *testing.T, cfg Config) (*Manager, store.Store) {
+	db := store.NewMemory("", 0)
+	require.NoError(t, db.Write([]item{{1, "a"}}))
+	cfg.Dir = filepath.Join(t.TempDir(), "backups")
+	return NewManager(db, func() interface{} { return &[]item{} }, "items", cfg), db
+}
+
+func TestCreateAndRestore(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		m, db := newManagerTest(t, Config{Compress: compress})
+
+		s, err := m.Create()
+		require.NoError(t, err)
+		assert.Equal(t, compress, s.Compressed)
+
+		require.NoError(t, db.Write([]item{{1, "a"}, {2, "b"}}))
+		require.NoError(t, m.Restore(s.Name))
+
+		var items []item
+		require.NoError(t, db.Read(&items))
+		assert.Equal(t, []item{{1, "a"}}, items)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	m, _ := newManagerTest(t, Config{Retention: 2})
+
+	var names []string
+	for i := 0; i < 4; i++ {
+		s, err := m.Create()
+		require.NoError(t, err)
+		names = append(names, s.Name)
+		time.Sleep(time.Millisecond)
+	}
+
+	snapshots, err := m.List()
+	require.NoError(t, err)
+	require.Len(t, snapshots, 2)
+	assert.Equal(t, names[2], snapshots[0].Name)
+	assert.Equal(t, names[3], snapshots[1].Name)
+}
+
+func TestRestoreUnknown(t *testing.T) {
+	m, _ := newManagerTest(t, Config{})
+
+	assert.ErrorIs(t, m.Restore("items-20200101T000000.000000000Z.json"), ErrNotFound)
+	assert.ErrorIs(t, m.Restore("../items-x.json"), ErrNotFound)
+	assert.ErrorIs(t, m.Restore("other.json"), ErrNotFound)
+}
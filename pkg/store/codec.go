@@ -0,0 +1,293 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Codec converts the data of a FileStore to and from its on-disk encoding.
+type Codec interface {
+	Marshal(data interface{}) ([]byte, error)
+	Unmarshal(fileData []byte, data interface{}) error
+}
+
+// CodecFor returns the codec of the given store type. FileType picks the
+// codec from the extension of fileName and defaults to indented JSON.
+func CodecFor(store Type, fileName string) (Codec, error) {
+	if store == FileType {
+		store = typeByExtension(fileName)
+	}
+	switch store {
+	case FileType:
+		return IndentedJSONCodec{}, nil
+	case JSONType:
+		return JSONCodec{}, nil
+	case JSONLType:
+		return JSONLCodec{}, nil
+	case YAMLType:
+		return YAMLCodec{}, nil
+	case CSVType:
+		return CSVCodec{}, nil
+	case GobType:
+		return GobCodec{}, nil
+	}
+	return nil, fmt.Errorf("store: no codec for %q", store)
+}
+
+func typeByExtension(fileName string) Type {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".jsonl", ".ndjson":
+		return JSONLType
+	case ".yaml", ".yml":
+		return YAMLType
+	case ".csv":
+		return CSVType
+	case ".gob":
+		return GobType
+	}
+	return FileType
+}
+
+type IndentedJSONCodec struct{}
+
+func (IndentedJSONCodec) Marshal(data interface{}) ([]byte, error) {
+	return json.MarshalIndent(data, "", "  ")
+}
+
+func (IndentedJSONCodec) Unmarshal(fileData []byte, data interface{}) error {
+	return json.Unmarshal(fileData, data)
+}
+
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(data interface{}) ([]byte, error) {
+	return json.Marshal(data)
+}
+
+func (JSONCodec) Unmarshal(fileData []byte, data interface{}) error {
+	return json.Unmarshal(fileData, data)
+}
+
+// JSONLCodec writes one JSON document per line, data must be a list.
+type JSONLCodec struct{}
+
+func (JSONLCodec) Marshal(data interface{}) ([]byte, error) {
+	list, err := toRawList(data)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	for _, item := range list {
+		buf.Write(item)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+func (JSONLCodec) Unmarshal(fileData []byte, data interface{}) error {
+	list := []json.RawMessage{}
+	scanner := bufio.NewScanner(bytes.NewReader(fileData))
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		list = append(list, append(json.RawMessage(nil), line...))
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fromRawList(list, data)
+}
+
+// YAMLCodec goes through the JSON encoding of data so the json tags keep
+// naming the fields.
+type YAMLCodec struct{}
+
+func (YAMLCodec) Marshal(data interface{}) ([]byte, error) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	if err := json.Unmarshal(jsonData, &value); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(value)
+}
+
+func (YAMLCodec) Unmarshal(fileData []byte, data interface{}) error {
+	var value interface{}
+	if err := yaml.Unmarshal(fileData, &value); err != nil {
+		return err
+	}
+	jsonData, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(jsonData, data)
+}
+
+// CSVCodec writes a list of flat objects with one column per field. Cells
+// hold strings as they are and any other value as JSON; strings that would
+// read back as another JSON value are quoted.
+type CSVCodec struct{}
+
+func (CSVCodec) Marshal(data interface{}) ([]byte, error) {
+	list, err := toRawList(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var header []string
+	columns := map[string]int{}
+	rows := make([]map[string]string, 0, len(list))
+	for _, item := range list {
+		keys, values, err := objectFields(item)
+		if err != nil {
+			return nil, err
+		}
+		row := map[string]string{}
+		for i, key := range keys {
+			if _, ok := columns[key]; !ok {
+				columns[key] = len(header)
+				header = append(header, key)
+			}
+			row[key] = csvCell(values[i])
+		}
+		rows = append(rows, row)
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write(header); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		record := make([]string, len(header))
+		for i, key := range header {
+			if cell, ok := row[key]; ok {
+				record[i] = cell
+			} else {
+				record[i] = "null"
+			}
+		}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+func (CSVCodec) Unmarshal(fileData []byte, data interface{}) error {
+	records, err := csv.NewReader(bytes.NewReader(fileData)).ReadAll()
+	if err != nil {
+		return err
+	}
+	list := []json.RawMessage{}
+	if len(records) > 0 {
+		header := records[0]
+		for _, record := range records[1:] {
+			var buf bytes.Buffer
+			buf.WriteByte('{')
+			for i, key := range header {
+				if i > 0 {
+					buf.WriteByte(',')
+				}
+				name, _ := json.Marshal(key)
+				buf.Write(name)
+				buf.WriteByte(':')
+				buf.Write(jsonCell(record[i]))
+			}
+			buf.WriteByte('}')
+			list = append(list, buf.Bytes())
+		}
+	}
+	return fromRawList(list, data)
+}
+
+func csvCell(value json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(value, &s); err != nil {
+		return string(value)
+	}
+	if json.Valid([]byte(s)) {
+		return string(value)
+	}
+	return s
+}
+
+func jsonCell(cell string) []byte {
+	if json.Valid([]byte(cell)) {
+		return []byte(cell)
+	}
+	s, _ := json.Marshal(cell)
+	return s
+}
+
+// objectFields returns the keys of a JSON object in the order they appear.
+func objectFields(item json.RawMessage) ([]string, []json.RawMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(item))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, nil, fmt.Errorf("store: csv items must be objects")
+	}
+	var keys []string
+	var values []json.RawMessage
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, tok.(string))
+		values = append(values, value)
+	}
+	return keys, values, nil
+}
+
+type GobCodec struct{}
+
+func (GobCodec) Marshal(data interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(fileData []byte, data interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(fileData)).Decode(data)
+}
+
+func toRawList(data interface{}) ([]json.RawMessage, error) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var list []json.RawMessage
+	if err := json.Unmarshal(jsonData, &list); err != nil {
+		return nil, fmt.Errorf("store: data must be a list: %w", err)
+	}
+	return list, nil
+}
+
+func fromRawList(list []json.RawMessage, data interface{}) error {
+	jsonData, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(jsonData, data)
+}
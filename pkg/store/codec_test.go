@@ -0,0 +1,57 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type typed struct {
+	ID     uint    `json:"id"`
+	Name   string  `json:"name"`
+	Age    int     `json:"age"`
+	Height float64 `json:"height"`
+	Active bool    `json:"active"`
+}
+
+func TestCodecsRoundTrip(t *testing.T) {
+	data := []typed{
+		{1, "Jane", 28, 1.7, true},
+		{2, "123", 0, 0, false},
+		{3, `true, "quoted"`, 23, 1.81, true},
+		{4, "", 1, 2, false},
+	}
+
+	for _, fileName := range []string{"users.json", "users.jsonl", "users.yaml", "users.csv", "users.gob"} {
+		t.Run(fileName, func(t *testing.T) {
+			db := newFile(t, filepath.Join(t.TempDir(), fileName))
+			require.NoError(t, db.Write(data))
+
+			var read []typed
+			require.NoError(t, db.Read(&read))
+			assert.Equal(t, data, read)
+		})
+	}
+}
+
+func TestCodecForType(t *testing.T) {
+	codec, err := CodecFor(JSONLType, "users.json")
+	assert.NoError(t, err)
+	assert.IsType(t, JSONLCodec{}, codec)
+
+	codec, err = CodecFor(FileType, "users.YML")
+	assert.NoError(t, err)
+	assert.IsType(t, YAMLCodec{}, codec)
+
+	_, err = CodecFor(SQLiteType, "users.db")
+	assert.Error(t, err)
+}
+
+func TestCSVCodecFormat(t *testing.T) {
+	fileData, err := CSVCodec{}.Marshal([]typed{{1, "Jane", 28, 1.7, true}})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "id,name,age,height,active\n1,Jane,28,1.7,true\n", string(fileData))
+}
@@ -0,0 +1,237 @@
+package store
+
+import "errors"
+
+var ErrNotFound = errors.New("store: item not found")
+
+// Entity is implemented by the items kept in a Collection.
+type Entity interface {
+	GetID() uint
+}
+
+// Collection is a typed list of entities kept in a Store and identified by
+// their ID.
+type Collection[T Entity] interface {
+	Get(id uint) (T, error)
+	List() ([]T, error)
+	// Put replaces the item with the same ID or appends it.
+	Put(item T) error
+	Delete(id uint) error
+	// DeleteFunc removes every item fn returns true for, in a single write,
+	// and returns how many were removed.
+	DeleteFunc(fn func(item T) bool) (int, error)
+	// NextID returns the ID following the greatest one in use or, in the
+	// stores that remember it, the greatest one ever given.
+	NextID() (uint, error)
+	// Create calls build with the next free ID and appends the item it
+	// returns, holding the store lock so concurrent calls get distinct IDs.
+	Create(build func(id uint) T) (T, error)
+	// CreateAll is Create for many items, build gets the first free ID and
+	// every item it returns is appended in a single write, or none is.
+	CreateAll(build func(next uint) []T) ([]T, error)
+	// Modify calls fn with the item identified by id and saves the result,
+	// holding the store lock for the whole cycle.
+	Modify(id uint, fn func(item *T) error) (T, error)
+}
+
+type collection[T Entity] struct {
+	db Store
+}
+
+func NewCollection[T Entity](db Store) Collection[T] {
+	return &collection[T]{db: db}
+}
+
+func (c *collection[T]) Get(id uint) (T, error) {
+	var items []T
+	if err := c.db.Read(&items); err != nil {
+		var zero T
+		return zero, err
+	}
+	if i := indexOf(items, id); i >= 0 {
+		return items[i], nil
+	}
+	var zero T
+	return zero, ErrNotFound
+}
+
+func (c *collection[T]) List() ([]T, error) {
+	var items []T
+	if err := c.db.Read(&items); err != nil {
+		return []T{}, err
+	}
+	return items, nil
+}
+
+func (c *collection[T]) Put(item T) error {
+	var items []T
+	return c.db.Update(&items, func() error {
+		if i := indexOf(items, item.GetID()); i >= 0 {
+			items[i] = item
+			return nil
+		}
+		if err := c.advance(item.GetID()); err != nil {
+			return err
+		}
+		items = append(items, item)
+		return nil
+	})
+}
+
+func (c *collection[T]) Delete(id uint) error {
+	var items []T
+	return c.db.Update(&items, func() error {
+		i := indexOf(items, id)
+		if i < 0 {
+			return ErrNotFound
+		}
+		if err := c.advance(nextID(items) - 1); err != nil {
+			return err
+		}
+		items = append(items[:i], items[i+1:]...)
+		return nil
+	})
+}
+
+func (c *collection[T]) DeleteFunc(fn func(item T) bool) (int, error) {
+	var items []T
+	removed := 0
+	err := c.db.Update(&items, func() error {
+		if err := c.advance(nextID(items) - 1); err != nil {
+			return err
+		}
+		kept := items[:0]
+		for _, item := range items {
+			if fn(item) {
+				removed++
+				continue
+			}
+			kept = append(kept, item)
+		}
+		items = kept
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+func (c *collection[T]) NextID() (uint, error) {
+	var items []T
+	if err := c.db.Read(&items); err != nil {
+		return 0, err
+	}
+	return c.next(items)
+}
+
+func (c *collection[T]) Create(build func(id uint) T) (T, error) {
+	var items []T
+	var item T
+	err := c.db.Update(&items, func() error {
+		next, err := c.next(items)
+		if err != nil {
+			return err
+		}
+		item = build(next)
+		if indexOf(items, item.GetID()) >= 0 || item.GetID() < next {
+			return errors.New("store: id already in use")
+		}
+		if err := c.advance(item.GetID()); err != nil {
+			return err
+		}
+		items = append(items, item)
+		return nil
+	})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return item, nil
+}
+
+func (c *collection[T]) CreateAll(build func(next uint) []T) ([]T, error) {
+	var items []T
+	var created []T
+	err := c.db.Update(&items, func() error {
+		next, err := c.next(items)
+		if err != nil {
+			return err
+		}
+		created = build(next)
+		for i, item := range created {
+			if indexOf(items, item.GetID()) >= 0 || indexOf(created[:i], item.GetID()) >= 0 || item.GetID() < next {
+				return errors.New("store: id already in use")
+			}
+			if err := c.advance(item.GetID()); err != nil {
+				return err
+			}
+		}
+		items = append(items, created...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+func (c *collection[T]) Modify(id uint, fn func(item *T) error) (T, error) {
+	var items []T
+	var item T
+	err := c.db.Update(&items, func() error {
+		i := indexOf(items, id)
+		if i < 0 {
+			return ErrNotFound
+		}
+		if err := fn(&items[i]); err != nil {
+			return err
+		}
+		item = items[i]
+		return nil
+	})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return item, nil
+}
+
+func indexOf[T Entity](items []T, id uint) int {
+	for i, item := range items {
+		if item.GetID() == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// next is the ID following both the greatest one of items and the greatest
+// one the store remembers giving.
+func (c *collection[T]) next(items []T) (uint, error) {
+	next := nextID(items)
+	last, err := LastID(c.db)
+	if err != nil {
+		return 0, err
+	}
+	if last >= next {
+		next = last + 1
+	}
+	return next, nil
+}
+
+// advance makes the store remember id as given, it must run before the
+// write that creates or removes the item so that a crash can't lose it.
+func (c *collection[T]) advance(id uint) error {
+	return AdvanceLastID(c.db, id)
+}
+
+func nextID[T Entity](items []T) uint {
+	var last uint
+	for _, item := range items {
+		if item.GetID() > last {
+			last = item.GetID()
+		}
+	}
+	return last + 1
+}
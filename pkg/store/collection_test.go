@@ -0,0 +1,153 @@
+package store
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (n named) GetID() uint {
+	return n.ID
+}
+
+func newCollectionTest(t *testing.T) Collection[named] {
+	db := NewMemory("", 0)
+	require.NoError(t, db.Write([]named{{1, "a"}, {3, "c"}}))
+	return NewCollection[named](db)
+}
+
+func TestCollectionGet(t *testing.T) {
+	c := newCollectionTest(t)
+
+	n, err := c.Get(3)
+	assert.NoError(t, err)
+	assert.Equal(t, "c", n.Name)
+
+	_, err = c.Get(2)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestCollectionPut(t *testing.T) {
+	c := newCollectionTest(t)
+
+	require.NoError(t, c.Put(named{3, "changed"}))
+	require.NoError(t, c.Put(named{5, "e"}))
+
+	ns, err := c.List()
+	assert.NoError(t, err)
+	assert.Equal(t, []named{{1, "a"}, {3, "changed"}, {5, "e"}}, ns)
+}
+
+func TestCollectionDelete(t *testing.T) {
+	c := newCollectionTest(t)
+
+	assert.NoError(t, c.Delete(1))
+	assert.ErrorIs(t, c.Delete(1), ErrNotFound)
+
+	ns, err := c.List()
+	assert.NoError(t, err)
+	assert.Equal(t, []named{{3, "c"}}, ns)
+}
+
+func TestCollectionDeleteFunc(t *testing.T) {
+	c := newCollectionTest(t)
+
+	removed, err := c.DeleteFunc(func(n named) bool { return n.ID > 2 })
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+
+	ns, err := c.List()
+	assert.NoError(t, err)
+	assert.Equal(t, []named{{1, "a"}}, ns)
+}
+
+func TestCollectionNextID(t *testing.T) {
+	c := newCollectionTest(t)
+
+	id, err := c.NextID()
+	assert.NoError(t, err)
+	assert.Equal(t, uint(4), id)
+
+	empty := NewCollection[named](NewMemory("", 0))
+	id, err = empty.NextID()
+	assert.NoError(t, err)
+	assert.Equal(t, uint(1), id)
+}
+
+func TestCollectionIDsNotReused(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "named.json")
+	for _, db := range []Store{NewMemory("", 0), newFile(t, fileName), NewLog(fileName+"l", 0)} {
+		require.NoError(t, db.Write([]named{{1, "a"}, {3, "c"}}))
+		c := NewCollection[named](db)
+
+		// The greatest ID in use is removed before anything is created.
+		_, err := c.DeleteFunc(func(n named) bool { return n.ID == 3 })
+		require.NoError(t, err)
+		created, err := c.Create(func(id uint) named { return named{id, "d"} })
+		require.NoError(t, err)
+		assert.Equal(t, uint(4), created.ID)
+
+		require.NoError(t, c.Delete(4))
+		id, err := c.NextID()
+		assert.NoError(t, err)
+		assert.Equal(t, uint(5), id)
+	}
+
+	// The sequence outlives the store.
+	id, err := NewCollection[named](newFile(t, fileName)).NextID()
+	assert.NoError(t, err)
+	assert.Equal(t, uint(5), id)
+}
+
+func TestCollectionCreateConcurrent(t *testing.T) {
+	c := newCollectionTest(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.Create(func(id uint) named { return named{id, "new"} })
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	ns, err := c.List()
+	assert.NoError(t, err)
+	assert.Len(t, ns, 22)
+	id, err := c.NextID()
+	assert.NoError(t, err)
+	assert.Equal(t, uint(24), id)
+}
+
+func TestCollectionCreateAll(t *testing.T) {
+	c := newCollectionTest(t)
+
+	created, err := c.CreateAll(func(next uint) []named { return []named{{next, "d"}, {next + 1, "e"}} })
+	assert.NoError(t, err)
+	assert.Equal(t, []named{{4, "d"}, {5, "e"}}, created)
+
+	_, err = c.CreateAll(func(next uint) []named { return []named{{next, "f"}, {3, "taken"}} })
+	assert.Error(t, err)
+	ns, err := c.List()
+	assert.NoError(t, err)
+	assert.Equal(t, []named{{1, "a"}, {3, "c"}, {4, "d"}, {5, "e"}}, ns)
+}
+
+func TestCollectionModify(t *testing.T) {
+	c := newCollectionTest(t)
+
+	n, err := c.Modify(1, func(n *named) error {
+		n.Name = "changed"
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, named{1, "changed"}, n)
+
+	_, err = c.Modify(2, func(n *named) error { return nil })
+	assert.ErrorIs(t, err, ErrNotFound)
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Config describes the store a program should open.
+type Config struct {
+	Type     Type
+	FileName string
+	// CompactEvery is the log store compaction threshold.
+	CompactEvery int
+	// SnapshotInterval is how often the memory store writes FileName.
+	SnapshotInterval time.Duration
+	// EncryptionKey enables the encrypting wrapper when set, both keys are
+	// base64 encoded and OldEncryptionKeys is a comma separated list.
+	EncryptionKey     string
+	OldEncryptionKeys string
+}
+
+// ConfigFromEnv reads the STORE_* environment variables.
+func ConfigFromEnv() Config {
+	compactEvery, _ := strconv.Atoi(os.Getenv("STORE_COMPACT_EVERY"))
+	interval, _ := time.ParseDuration(os.Getenv("STORE_SNAPSHOT_INTERVAL"))
+	return Config{
+		Type:              Type(os.Getenv("STORE_TYPE")),
+		FileName:          os.Getenv("STORE_FILE"),
+		CompactEvery:      compactEvery,
+		SnapshotInterval:  interval,
+		EncryptionKey:     os.Getenv("STORE_ENCRYPTION_KEY"),
+		OldEncryptionKeys: os.Getenv("STORE_ENCRYPTION_OLD_KEYS"),
+	}
+}
+
+// Open builds the store described by cfg.
+func Open(cfg Config) (Store, error) {
+	if cfg.Type == "" {
+		cfg.Type = FileType
+	}
+	if cfg.FileName == "" && cfg.Type != MemoryType {
+		cfg.FileName = "./users.json"
+		if cfg.Type == SQLiteType {
+			cfg.FileName = "./users.db"
+		}
+	}
+
+	var db Store
+	switch cfg.Type {
+	case SQLiteType:
+		if cfg.EncryptionKey != "" {
+			return nil, errors.New("store: encryption is not supported by the sqlite store")
+		}
+		return NewSQLite(cfg.FileName), nil
+	case LogType:
+		db = NewLog(cfg.FileName, cfg.CompactEvery)
+	case MemoryType:
+		// Without a file name the data only lives as long as the process.
+		db = NewMemory(cfg.FileName, cfg.SnapshotInterval)
+	default:
+		var err error
+		db, err = New(cfg.Type, cfg.FileName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.EncryptionKey != "" {
+		keys, err := KeyringFromBase64(cfg.EncryptionKey, cfg.OldEncryptionKeys)
+		if err != nil {
+			return nil, err
+		}
+		db = NewEncrypted(db, keys)
+	}
+	return db, nil
+}
@@ -0,0 +1,196 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrNotEncrypted = errors.New("store: data is not encrypted")
+
+// Keyring holds the AES-256 keys of an EncryptedStore. New data is always
+// sealed with the primary key, the old keys are only used to open data
+// written before a rotation.
+type Keyring struct {
+	primary string
+	aeads   map[string]cipher.AEAD
+}
+
+func NewKeyring(primary []byte, old ...[]byte) (*Keyring, error) {
+	k := &Keyring{aeads: map[string]cipher.AEAD{}}
+	for i, key := range append([][]byte{primary}, old...) {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("store: encryption keys must have 32 bytes, got %d", len(key))
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		id := keyID(key)
+		if i == 0 {
+			k.primary = id
+		}
+		k.aeads[id] = aead
+	}
+	return k, nil
+}
+
+// KeyringFromBase64 builds a Keyring from base64 encoded keys, old holds a
+// comma separated list and may be empty.
+func KeyringFromBase64(primary, old string) (*Keyring, error) {
+	primaryKey, err := base64.StdEncoding.DecodeString(primary)
+	if err != nil {
+		return nil, fmt.Errorf("store: invalid encryption key: %w", err)
+	}
+	var oldKeys [][]byte
+	for _, encoded := range strings.Split(old, ",") {
+		encoded = strings.TrimSpace(encoded)
+		if encoded == "" {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("store: invalid old encryption key: %w", err)
+		}
+		oldKeys = append(oldKeys, key)
+	}
+	return NewKeyring(primaryKey, oldKeys...)
+}
+
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Envelope is what an EncryptedStore keeps in the wrapped store. It is
+// written as a one item list with an id so that every store and codec,
+// including the log store and CSV, can hold it. Tools that move the
+// encrypted data around without opening it, like backups, read the wrapped
+// store into a []Envelope.
+type Envelope struct {
+	ID         uint   `json:"id"`
+	KeyID      string `json:"key_id"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// EncryptedStore encrypts the JSON encoding of the data with AES-GCM before
+// handing it to the wrapped store.
+type EncryptedStore struct {
+	Store Store
+	Keys  *Keyring
+}
+
+func NewEncrypted(s Store, keys *Keyring) *EncryptedStore {
+	return &EncryptedStore{Store: s, Keys: keys}
+}
+
+func (es *EncryptedStore) Read(data interface{}) error {
+	var envs []Envelope
+	if err := es.Store.Read(&envs); err != nil {
+		return err
+	}
+	return es.open(envs, data)
+}
+
+func (es *EncryptedStore) Write(data interface{}) error {
+	envs, err := es.seal(data)
+	if err != nil {
+		return err
+	}
+	return es.Store.Write(envs)
+}
+
+func (es *EncryptedStore) Update(data interface{}, fn func() error) error {
+	var envs []Envelope
+	return es.Store.Update(&envs, func() error {
+		if err := es.open(envs, data); err != nil {
+			return err
+		}
+		if err := fn(); err != nil {
+			return err
+		}
+		sealed, err := es.seal(data)
+		if err != nil {
+			return err
+		}
+		envs = sealed
+		return nil
+	})
+}
+
+// Rotate encrypts the stored data again with the primary key.
+func (es *EncryptedStore) Rotate() error {
+	var data json.RawMessage
+	return es.Update(&data, func() error { return nil })
+}
+
+// Encrypt replaces the plain data kept in the wrapped store by its
+// encrypted form. The data is read into data, so it must have the type it
+// was written with. It does not lock the wrapped store across the read and
+// the write and is meant to be run while nothing else uses the store.
+func (es *EncryptedStore) Encrypt(data interface{}) error {
+	if err := es.Store.Read(data); err != nil {
+		return err
+	}
+	return es.Write(data)
+}
+
+func (es *EncryptedStore) Close() error {
+	if closer, ok := es.Store.(interface{ Close() error }); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (es *EncryptedStore) open(envs []Envelope, data interface{}) error {
+	if len(envs) == 0 {
+		return nil
+	}
+	env := envs[0]
+	if len(envs) > 1 || env.KeyID == "" || len(env.Ciphertext) == 0 {
+		return ErrNotEncrypted
+	}
+	aead, ok := es.Keys.aeads[env.KeyID]
+	if !ok {
+		return fmt.Errorf("store: unknown encryption key %s", env.KeyID)
+	}
+	// Open panics on a nonce of the wrong size, which a damaged file may have.
+	if len(env.Nonce) != aead.NonceSize() {
+		return errors.New("store: unable to decrypt data: invalid nonce")
+	}
+	plain, err := aead.Open(nil, env.Nonce, env.Ciphertext, []byte(env.KeyID))
+	if err != nil {
+		return fmt.Errorf("store: unable to decrypt data: %w", err)
+	}
+	return json.Unmarshal(plain, data)
+}
+
+func (es *EncryptedStore) seal(data interface{}) ([]Envelope, error) {
+	plain, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	aead := es.Keys.aeads[es.Keys.primary]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return []Envelope{{
+		ID:         1,
+		KeyID:      es.Keys.primary,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, plain, []byte(es.Keys.primary)),
+	}}, nil
+}
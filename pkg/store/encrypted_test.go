@@ -0,0 +1,96 @@
+package store
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func TestEncryptedStoreRoundTrip(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "data.json")
+	keys, err := NewKeyring(newKey(1))
+	require.NoError(t, err)
+	es := NewEncrypted(newFile(t, fileName), keys)
+
+	require.NoError(t, es.Write([]named{{1, "jane.doe@gmail.com"}}))
+	var ns []named
+	require.NoError(t, es.Update(&ns, func() error {
+		ns = append(ns, named{2, "gabriel"})
+		return nil
+	}))
+
+	fileData, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	assert.NotContains(t, string(fileData), "jane.doe")
+
+	require.NoError(t, es.Read(&ns))
+	assert.Equal(t, []named{{1, "jane.doe@gmail.com"}, {2, "gabriel"}}, ns)
+}
+
+func TestEncryptedStoreCorruptEnvelope(t *testing.T) {
+	keys, err := NewKeyring(newKey(1))
+	require.NoError(t, err)
+	es := NewEncrypted(NewMemory("", 0), keys)
+	require.NoError(t, es.Write([]named{{1, "a"}}))
+
+	var envs []Envelope
+	require.NoError(t, es.Store.Read(&envs))
+	for _, corrupt := range []func(env *Envelope){
+		func(env *Envelope) { env.Nonce = env.Nonce[:4] },
+		func(env *Envelope) { env.Nonce = nil },
+		func(env *Envelope) { env.Ciphertext[0] ^= 1 },
+	} {
+		env := Envelope{ID: envs[0].ID, KeyID: envs[0].KeyID, Nonce: bytes.Clone(envs[0].Nonce), Ciphertext: bytes.Clone(envs[0].Ciphertext)}
+		corrupt(&env)
+		require.NoError(t, es.Store.Write([]Envelope{env}))
+		var ns []named
+		assert.ErrorContains(t, es.Read(&ns), "unable to decrypt")
+	}
+}
+
+func TestEncryptedStoreRotate(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "data.json")
+	oldKeys, err := NewKeyring(newKey(1))
+	require.NoError(t, err)
+	require.NoError(t, NewEncrypted(newFile(t, fileName), oldKeys).Write([]named{{1, "a"}}))
+
+	newKeys, err := NewKeyring(newKey(2), newKey(1))
+	require.NoError(t, err)
+	require.NoError(t, NewEncrypted(newFile(t, fileName), newKeys).Rotate())
+
+	onlyNew, err := NewKeyring(newKey(2))
+	require.NoError(t, err)
+	var ns []named
+	require.NoError(t, NewEncrypted(newFile(t, fileName), onlyNew).Read(&ns))
+	assert.Equal(t, []named{{1, "a"}}, ns)
+
+	assert.Error(t, NewEncrypted(newFile(t, fileName), oldKeys).Read(&ns))
+}
+
+func TestEncryptedStoreEncryptPlainData(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "data.json")
+	require.NoError(t, newFile(t, fileName).Write([]named{{1, "a"}}))
+	keys, err := NewKeyring(newKey(1))
+	require.NoError(t, err)
+	es := NewEncrypted(newFile(t, fileName), keys)
+
+	var ns []named
+	assert.ErrorIs(t, es.Read(&ns), ErrNotEncrypted)
+
+	require.NoError(t, es.Encrypt(&[]named{}))
+	require.NoError(t, es.Read(&ns))
+	assert.Equal(t, []named{{1, "a"}}, ns)
+}
+
+func TestKeyringRejectsShortKeys(t *testing.T) {
+	_, err := NewKeyring([]byte("short"))
+	assert.Error(t, err)
+}
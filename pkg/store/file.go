@@ -1,39 +1,66 @@
 package store
 
 import (
-	"encoding/json"
+	"fmt"
 	"os"
 )
 
 type Store interface {
 	Read(data interface{}) error
 	Write(data interface{}) error
+	// Update reads the stored data into data, calls fn and writes data back,
+	// holding the store lock for the whole cycle. Nothing is written when fn
+	// returns an error.
+	Update(data interface{}, fn func() error) error
 }
 
 type Type string
 
 const (
-	FileType Type = "file"
+	FileType   Type = "file"
+	SQLiteType Type = "sqlite"
+	LogType    Type = "log"
+	MemoryType Type = "memory"
+
+	// Encodings of the file store, FileType picks one by the file extension.
+	JSONType  Type = "json"
+	JSONLType Type = "jsonl"
+	YAMLType  Type = "yaml"
+	CSVType   Type = "csv"
+	GobType   Type = "gob"
 )
 
-func New(store Type, fileName string) Store {
+func New(store Type, fileName string) (Store, error) {
 	switch store {
-	case FileType:
-		return &FileStore{fileName}
+	case FileType, JSONType, JSONLType, YAMLType, CSVType, GobType:
+		codec, err := CodecFor(store, fileName)
+		if err != nil {
+			return nil, err
+		}
+		return &FileStore{FileName: fileName, Codec: codec}, nil
+	case SQLiteType:
+		return NewSQLite(fileName), nil
+	case LogType:
+		return NewLog(fileName, DefaultCompactEvery), nil
+	case MemoryType:
+		return NewMemory(fileName, 0), nil
 	}
-	return nil
+	return nil, fmt.Errorf("store: unknown type %q", store)
 }
 
 type FileStore struct {
 	FileName string
+	// Codec encodes the file, indented JSON when nil.
+	Codec Codec
 }
 
 func (fs *FileStore) Write(data interface{}) error {
-	fileData, err := json.MarshalIndent(data, "", "  ")
+	unlock, err := lockPath(fs.FileName)
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(fs.FileName, fileData, 0644)
+	defer unlock()
+	return fs.write(data)
 }
 
 func (fs *FileStore) Read(data interface{}) error {
@@ -41,5 +68,39 @@ func (fs *FileStore) Read(data interface{}) error {
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(file, &data)
+	if len(file) == 0 {
+		return nil
+	}
+	return fs.codec().Unmarshal(file, data)
+}
+
+func (fs *FileStore) Update(data interface{}, fn func() error) error {
+	unlock, err := lockPath(fs.FileName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := fs.Read(data); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	return fs.write(data)
+}
+
+func (fs *FileStore) write(data interface{}) error {
+	fileData, err := fs.codec().Marshal(data)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(fs.FileName, fileData, 0644)
+}
+
+func (fs *FileStore) codec() Codec {
+	if fs.Codec == nil {
+		return IndentedJSONCodec{}
+	}
+	return fs.Codec
 }
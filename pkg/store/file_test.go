@@ -0,0 +1,86 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type record struct {
+	ID uint `json:"id"`
+}
+
+func newFileStoreTest(t *testing.T) *FileStore {
+	fileName := filepath.Join(t.TempDir(), "data.json")
+	require.NoError(t, os.WriteFile(fileName, []byte("[]"), 0644))
+	return &FileStore{FileName: fileName}
+}
+
+func newFile(t *testing.T, fileName string) Store {
+	db, err := New(FileType, fileName)
+	require.NoError(t, err)
+	return db
+}
+
+func TestNewUnknownType(t *testing.T) {
+	db, err := New(Type("xml"), "data.xml")
+	assert.Error(t, err)
+	assert.Nil(t, db)
+
+	_, err = Open(Config{Type: Type("xml")})
+	assert.Error(t, err)
+}
+
+func TestFileStoreUpdateConcurrent(t *testing.T) {
+	fs := newFileStoreTest(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(id uint) {
+			defer wg.Done()
+			var rs []record
+			err := fs.Update(&rs, func() error {
+				rs = append(rs, record{id})
+				return nil
+			})
+			assert.NoError(t, err)
+		}(uint(i))
+	}
+	wg.Wait()
+
+	var rs []record
+	require.NoError(t, fs.Read(&rs))
+	assert.Len(t, rs, 50)
+}
+
+func TestFileStoreUpdateError(t *testing.T) {
+	fs := newFileStoreTest(t)
+
+	var rs []record
+	err := fs.Update(&rs, func() error {
+		rs = append(rs, record{1})
+		return errors.New("abort")
+	})
+	assert.EqualError(t, err, "abort")
+
+	require.NoError(t, fs.Read(&rs))
+	assert.Empty(t, rs)
+}
+
+func TestFileStoreWriteLeavesNoTempFiles(t *testing.T) {
+	fs := newFileStoreTest(t)
+
+	require.NoError(t, fs.Write([]record{{1}, {2}}))
+
+	entries, err := os.ReadDir(filepath.Dir(fs.FileName))
+	require.NoError(t, err)
+	for _, e := range entries {
+		assert.NotContains(t, e.Name(), ".tmp-")
+	}
+}
@@ -0,0 +1,32 @@
+package store
+
+import (
+	"path/filepath"
+	"sync"
+)
+
+// fileLocks serializes access to the same file between every store of this
+// process, flock alone does not exclude goroutines sharing a descriptor table.
+var fileLocks sync.Map
+
+// lockPath holds the process-level and the OS-level lock of name until the
+// returned function is called.
+func lockPath(name string) (func(), error) {
+	path, err := filepath.Abs(name)
+	if err != nil {
+		return nil, err
+	}
+	value, _ := fileLocks.LoadOrStore(path, &sync.Mutex{})
+	mu := value.(*sync.Mutex)
+	mu.Lock()
+
+	f, err := lockFile(path + ".lock")
+	if err != nil {
+		mu.Unlock()
+		return nil, err
+	}
+	return func() {
+		unlockFile(f)
+		mu.Unlock()
+	}, nil
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package store
+
+import "os"
+
+// On platforms without flock only the process-level lock applies.
+func lockFile(name string) (*os.File, error) {
+	return os.OpenFile(name, os.O_CREATE|os.O_RDWR, 0644)
+}
+
+func unlockFile(f *os.File) error {
+	return f.Close()
+}
@@ -0,0 +1,342 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// DefaultCompactEvery is the number of log records after which a LogStore
+// folds its log into a new snapshot.
+const DefaultCompactEvery = 1000
+
+const (
+	opPut    = "put"
+	opDelete = "delete"
+)
+
+type logRecord struct {
+	Op   string          `json:"op"`
+	ID   string          `json:"id"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// LogStore keeps a list of records identified by their "id" field. The
+// snapshot in FileName has the same layout as a FileStore file and every
+// Write only appends the records that were created, changed or deleted to
+// FileName + ".wal". The state is rebuilt in memory on first use by
+// replaying the log over the snapshot, so a LogStore must be the only
+// writer of its files.
+type LogStore struct {
+	FileName     string
+	CompactEvery int
+
+	mu      sync.Mutex
+	once    sync.Once
+	err     error
+	order   []string
+	items   map[string]json.RawMessage
+	log     *os.File
+	records int
+}
+
+func NewLog(fileName string, compactEvery int) *LogStore {
+	if compactEvery <= 0 {
+		compactEvery = DefaultCompactEvery
+	}
+	return &LogStore{FileName: fileName, CompactEvery: compactEvery}
+}
+
+func (ls *LogStore) logName() string {
+	return ls.FileName + ".wal"
+}
+
+func (ls *LogStore) Read(data interface{}) error {
+	if err := ls.open(); err != nil {
+		return err
+	}
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return ls.read(data)
+}
+
+func (ls *LogStore) Write(data interface{}) error {
+	if err := ls.open(); err != nil {
+		return err
+	}
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return ls.write(data)
+}
+
+func (ls *LogStore) Update(data interface{}, fn func() error) error {
+	if err := ls.open(); err != nil {
+		return err
+	}
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	if err := ls.read(data); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	return ls.write(data)
+}
+
+// Compact writes the current state as the new snapshot and empties the log.
+func (ls *LogStore) Compact() error {
+	if err := ls.open(); err != nil {
+		return err
+	}
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return ls.compact()
+}
+
+func (ls *LogStore) Close() error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if ls.log == nil {
+		return nil
+	}
+	err := ls.log.Close()
+	ls.log = nil
+	return err
+}
+
+func (ls *LogStore) read(data interface{}) error {
+	list := make([]json.RawMessage, 0, len(ls.order))
+	for _, id := range ls.order {
+		list = append(list, ls.items[id])
+	}
+	fileData, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(fileData, data)
+}
+
+func (ls *LogStore) write(data interface{}) error {
+	fileData, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	var list []json.RawMessage
+	if err := json.Unmarshal(fileData, &list); err != nil {
+		return fmt.Errorf("log store: data must be a list: %w", err)
+	}
+
+	var records []logRecord
+	seen := make(map[string]bool, len(list))
+	for _, item := range list {
+		id, err := recordID(item)
+		if err != nil {
+			return err
+		}
+		if seen[id] {
+			return fmt.Errorf("log store: duplicated id %s", id)
+		}
+		seen[id] = true
+		if current, ok := ls.items[id]; !ok || !bytes.Equal(current, item) {
+			records = append(records, logRecord{Op: opPut, ID: id, Data: item})
+		}
+	}
+	for _, id := range ls.order {
+		if !seen[id] {
+			records = append(records, logRecord{Op: opDelete, ID: id})
+		}
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	if err := ls.append(records); err != nil {
+		return err
+	}
+	for _, r := range records {
+		ls.apply(r)
+	}
+	if ls.records >= ls.CompactEvery {
+		return ls.compact()
+	}
+	return nil
+}
+
+func (ls *LogStore) append(records []logRecord) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	offset, err := ls.log.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	_, err = ls.log.Write(buf.Bytes())
+	if err == nil {
+		err = ls.log.Sync()
+	}
+	if err != nil {
+		// The records are not applied, so whatever part of them was written
+		// is dropped, later appends must not follow a partial line.
+		if truncateErr := ls.log.Truncate(offset); truncateErr != nil {
+			return fmt.Errorf("%w, and the log could not be restored: %v", err, truncateErr)
+		}
+		if _, seekErr := ls.log.Seek(offset, io.SeekStart); seekErr != nil {
+			return fmt.Errorf("%w, and the log could not be restored: %v", err, seekErr)
+		}
+		return err
+	}
+	ls.records += len(records)
+	return nil
+}
+
+func (ls *LogStore) apply(r logRecord) {
+	switch r.Op {
+	case opPut:
+		if _, ok := ls.items[r.ID]; !ok {
+			ls.order = append(ls.order, r.ID)
+		}
+		ls.items[r.ID] = r.Data
+	case opDelete:
+		if _, ok := ls.items[r.ID]; !ok {
+			return
+		}
+		delete(ls.items, r.ID)
+		for i, id := range ls.order {
+			if id == r.ID {
+				ls.order = append(ls.order[:i], ls.order[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+func (ls *LogStore) compact() error {
+	list := make([]json.RawMessage, 0, len(ls.order))
+	for _, id := range ls.order {
+		list = append(list, ls.items[id])
+	}
+	fileData, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(ls.FileName, fileData, 0644); err != nil {
+		return err
+	}
+	// Replaying the old log over the new snapshot is harmless, so a crash
+	// before the truncate below does not lose or duplicate anything.
+	if err := ls.log.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := ls.log.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	ls.records = 0
+	return ls.log.Sync()
+}
+
+func (ls *LogStore) open() error {
+	ls.once.Do(func() {
+		ls.mu.Lock()
+		defer ls.mu.Unlock()
+		ls.err = ls.load()
+	})
+	return ls.err
+}
+
+func (ls *LogStore) load() error {
+	ls.items = map[string]json.RawMessage{}
+
+	snapshot, err := os.ReadFile(ls.FileName)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if len(bytes.TrimSpace(snapshot)) > 0 {
+		var list []json.RawMessage
+		if err := json.Unmarshal(snapshot, &list); err != nil {
+			return err
+		}
+		for _, item := range list {
+			id, err := recordID(item)
+			if err != nil {
+				return err
+			}
+			ls.apply(logRecord{Op: opPut, ID: id, Data: compactJSON(item)})
+		}
+	}
+
+	ls.log, err = os.OpenFile(ls.logName(), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	valid, err := ls.replay()
+	if err != nil {
+		return err
+	}
+	// A crash in the middle of an append leaves a partial last line, drop it
+	// so the next records start on a clean line.
+	if err := ls.log.Truncate(valid); err != nil {
+		return err
+	}
+	_, err = ls.log.Seek(valid, io.SeekStart)
+	return err
+}
+
+// replay applies every complete record of the log and returns the offset
+// right after the last one. Only the last line may be partial, a record
+// that can't be read anywhere else is corruption and fails the replay
+// rather than dropping the records after it.
+func (ls *LogStore) replay() (int64, error) {
+	reader := bufio.NewReader(ls.log)
+	var valid int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return valid, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		var r logRecord
+		if err := json.Unmarshal(line, &r); err != nil {
+			return 0, fmt.Errorf("log store: corrupt record at offset %d of %s: %w", valid, ls.logName(), err)
+		}
+		r.Data = compactJSON(r.Data)
+		ls.apply(r)
+		ls.records++
+		valid += int64(len(line))
+	}
+}
+
+func recordID(item json.RawMessage) (string, error) {
+	var entity struct {
+		ID json.RawMessage `json:"id"`
+	}
+	if err := json.Unmarshal(item, &entity); err != nil {
+		return "", fmt.Errorf("log store: record is not an object: %w", err)
+	}
+	if len(entity.ID) == 0 {
+		return "", errors.New("log store: record without id")
+	}
+	return string(entity.ID), nil
+}
+
+// compactJSON normalizes item so it can be compared with the output of
+// json.Marshal when deciding whether a record changed.
+func compactJSON(item json.RawMessage) json.RawMessage {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, item); err != nil {
+		return item
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,107 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type named struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestLogStoreReplay(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "data.json")
+	ls := NewLog(fileName, 100)
+
+	require.NoError(t, ls.Write([]named{{1, "a"}, {2, "b"}, {3, "c"}}))
+	require.NoError(t, ls.Write([]named{{1, "a"}, {3, "changed"}}))
+	require.NoError(t, ls.Close())
+
+	_, err := os.Stat(fileName)
+	assert.True(t, os.IsNotExist(err), "no snapshot before the first compaction")
+
+	var ns []named
+	require.NoError(t, NewLog(fileName, 100).Read(&ns))
+	assert.Equal(t, []named{{1, "a"}, {3, "changed"}}, ns)
+}
+
+func TestLogStoreOnlyAppendsChanges(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "data.json")
+	ls := NewLog(fileName, 100)
+
+	require.NoError(t, ls.Write([]named{{1, "a"}, {2, "b"}}))
+	require.NoError(t, ls.Write([]named{{1, "a"}, {2, "b"}}))
+	var ns []named
+	require.NoError(t, ls.Update(&ns, func() error {
+		ns[1].Name = "c"
+		return nil
+	}))
+
+	assert.Equal(t, 3, ls.records)
+}
+
+func TestLogStoreCompact(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "data.json")
+	ls := NewLog(fileName, 3)
+
+	require.NoError(t, ls.Write([]named{{1, "a"}, {2, "b"}}))
+	require.NoError(t, ls.Write([]named{{1, "a"}, {2, "b"}, {3, "c"}}))
+
+	info, err := os.Stat(fileName + ".wal")
+	require.NoError(t, err)
+	assert.Zero(t, info.Size())
+
+	var ns []named
+	require.NoError(t, (&FileStore{FileName: fileName}).Read(&ns))
+	assert.Len(t, ns, 3)
+}
+
+func TestLogStoreIgnoresTornRecord(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "data.json")
+	ls := NewLog(fileName, 100)
+	require.NoError(t, ls.Write([]named{{1, "a"}}))
+	require.NoError(t, ls.Close())
+
+	f, err := os.OpenFile(fileName+".wal", os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"op":"put","id":"2","data":{"id":2,`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	ls = NewLog(fileName, 100)
+	var ns []named
+	require.NoError(t, ls.Read(&ns))
+	assert.Equal(t, []named{{1, "a"}}, ns)
+
+	require.NoError(t, ls.Write([]named{{1, "a"}, {2, "b"}}))
+	require.NoError(t, ls.Close())
+	require.NoError(t, NewLog(fileName, 100).Read(&ns))
+	assert.Equal(t, []named{{1, "a"}, {2, "b"}}, ns)
+}
+
+func TestLogStoreFailsOnCorruptRecord(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "data.json")
+	ls := NewLog(fileName, 100)
+	require.NoError(t, ls.Write([]named{{1, "a"}}))
+	require.NoError(t, ls.Close())
+
+	f, err := os.OpenFile(fileName+".wal", os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString("{\"op\":\"put\",\"id\":\"2\",\n{\"op\":\"put\",\"id\":\"3\",\"data\":{\"id\":3,\"name\":\"c\"}}\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	before, err := os.ReadFile(fileName + ".wal")
+	require.NoError(t, err)
+
+	var ns []named
+	assert.ErrorContains(t, NewLog(fileName, 100).Read(&ns), "corrupt record")
+	// The records after the corrupt one are kept for whoever repairs it.
+	after, err := os.ReadFile(fileName + ".wal")
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+}
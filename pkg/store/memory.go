@@ -0,0 +1,177 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MemoryStore keeps its data in memory. The data is held in its encoded
+// form, so every Read returns a fresh copy and callers never share state
+// with the store or with each other.
+//
+// When FileName is set the store starts from the contents of that file and
+// writes snapshots back to it every interval (if positive) and on Close.
+type MemoryStore struct {
+	FileName string
+
+	mu      sync.Mutex
+	flushMu sync.Mutex
+	once    sync.Once
+	err     error
+	data    []byte
+	dirty   bool
+	version int
+	// last is the sequence of the store when it has no file.
+	last atomic.Uint64
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewMemory(fileName string, interval time.Duration) *MemoryStore {
+	ms := &MemoryStore{FileName: fileName}
+	if fileName != "" && interval > 0 {
+		ms.stop = make(chan struct{})
+		ms.done = make(chan struct{})
+		go ms.flushEvery(interval)
+	}
+	return ms
+}
+
+func (ms *MemoryStore) Read(data interface{}) error {
+	if err := ms.load(); err != nil {
+		return err
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.read(data)
+}
+
+func (ms *MemoryStore) Write(data interface{}) error {
+	if err := ms.load(); err != nil {
+		return err
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.write(data)
+}
+
+func (ms *MemoryStore) Update(data interface{}, fn func() error) error {
+	if err := ms.load(); err != nil {
+		return err
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if err := ms.read(data); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	return ms.write(data)
+}
+
+// Flush writes a snapshot to FileName if anything changed since the last one.
+func (ms *MemoryStore) Flush() error {
+	if ms.FileName == "" {
+		return nil
+	}
+	ms.flushMu.Lock()
+	defer ms.flushMu.Unlock()
+
+	ms.mu.Lock()
+	if !ms.dirty {
+		ms.mu.Unlock()
+		return nil
+	}
+	var buf bytes.Buffer
+	err := json.Indent(&buf, ms.data, "", "  ")
+	ms.dirty = false
+	ms.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(ms.FileName, buf.Bytes(), 0644); err != nil {
+		ms.mu.Lock()
+		ms.dirty = true
+		ms.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Close stops the periodic snapshots and writes a last one.
+func (ms *MemoryStore) Close() error {
+	if ms.stop != nil {
+		close(ms.stop)
+		<-ms.done
+		ms.stop = nil
+	}
+	return ms.Flush()
+}
+
+func (ms *MemoryStore) read(data interface{}) error {
+	if ms.data == nil {
+		return nil
+	}
+	return json.Unmarshal(ms.data, data)
+}
+
+func (ms *MemoryStore) write(data interface{}) error {
+	fileData, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	ms.data = fileData
+	ms.dirty = true
+	return nil
+}
+
+func (ms *MemoryStore) load() error {
+	ms.once.Do(func() {
+		if ms.FileName == "" {
+			return
+		}
+		fileData, err := os.ReadFile(ms.FileName)
+		if errors.Is(err, os.ErrNotExist) {
+			return
+		}
+		if err != nil {
+			ms.err = err
+			return
+		}
+		if len(bytes.TrimSpace(fileData)) == 0 {
+			return
+		}
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, fileData); err != nil {
+			ms.err = err
+			return
+		}
+		ms.mu.Lock()
+		ms.data = buf.Bytes()
+		ms.mu.Unlock()
+	})
+	return ms.err
+}
+
+func (ms *MemoryStore) flushEvery(interval time.Duration) {
+	defer close(ms.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// A failed snapshot stays dirty and is retried on the next tick.
+			_ = ms.Flush()
+		case <-ms.stop:
+			return
+		}
+	}
+}
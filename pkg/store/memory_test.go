@@ -0,0 +1,56 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStoreCopiesData(t *testing.T) {
+	ms := NewMemory("", 0)
+
+	written := []named{{1, "a"}}
+	require.NoError(t, ms.Write(written))
+	written[0].Name = "changed after write"
+
+	var first, second []named
+	require.NoError(t, ms.Read(&first))
+	first[0].Name = "changed after read"
+	require.NoError(t, ms.Read(&second))
+
+	assert.Equal(t, []named{{1, "a"}}, second)
+}
+
+func TestMemoryStoreSnapshotOnClose(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "data.json")
+	require.NoError(t, os.WriteFile(fileName, []byte(`[{"id": 1, "name": "a"}]`), 0644))
+
+	ms := NewMemory(fileName, time.Hour)
+	var ns []named
+	require.NoError(t, ms.Update(&ns, func() error {
+		ns = append(ns, named{2, "b"})
+		return nil
+	}))
+	require.NoError(t, ms.Close())
+
+	var saved []named
+	require.NoError(t, (&FileStore{FileName: fileName}).Read(&saved))
+	assert.Equal(t, []named{{1, "a"}, {2, "b"}}, saved)
+}
+
+func TestMemoryStoreSnapshotOnInterval(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "data.json")
+	ms := NewMemory(fileName, 10*time.Millisecond)
+	defer ms.Close()
+
+	require.NoError(t, ms.Write([]named{{1, "a"}}))
+
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(fileName)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+}
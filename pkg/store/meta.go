@@ -0,0 +1,212 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+var ErrNotVersioned = errors.New("store: schema version not supported")
+
+// Versioned is implemented by the stores that keep the schema version of
+// their data, see the migrate package.
+type Versioned interface {
+	SchemaVersion() (int, error)
+	SetSchemaVersion(version int) error
+}
+
+// sequenced is implemented by the stores that remember the greatest ID a
+// Collection ever gave, so that the IDs of removed items are not given
+// again. setLastID is only called from the fn of an Update, with the store
+// locked. The SQLite store is not sequenced, its repositories keep their own
+// tables.
+type sequenced interface {
+	lastID() (uint, error)
+	setLastID(id uint) error
+}
+
+// LastID returns the greatest ID a Collection of db ever gave, zero when db
+// does not remember it.
+func LastID(db Store) (uint, error) {
+	if s, ok := db.(sequenced); ok {
+		return s.lastID()
+	}
+	return 0, nil
+}
+
+// AdvanceLastID makes db remember id as given unless it remembers a greater
+// one. Like in a Collection, it is called from the fn of an Update of db.
+func AdvanceLastID(db Store, id uint) error {
+	s, ok := db.(sequenced)
+	if !ok {
+		return nil
+	}
+	last, err := s.lastID()
+	if err != nil || id <= last {
+		return err
+	}
+	return s.setLastID(id)
+}
+
+// meta is kept next to the data file of the file based stores.
+type meta struct {
+	SchemaVersion int  `json:"schema_version"`
+	LastID        uint `json:"last_id,omitempty"`
+}
+
+func metaName(fileName string) string {
+	return fileName + ".meta"
+}
+
+func readMeta(fileName string) (meta, error) {
+	var m meta
+	fileData, err := os.ReadFile(metaName(fileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return m, err
+	}
+	return m, json.Unmarshal(fileData, &m)
+}
+
+// updateMeta changes the meta of fileName, keeping the fields fn leaves
+// alone.
+func updateMeta(fileName string, fn func(m *meta)) error {
+	m, err := readMeta(fileName)
+	if err != nil {
+		return err
+	}
+	fn(&m)
+	fileData, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(metaName(fileName), fileData, 0644)
+}
+
+func readSchemaVersion(fileName string) (int, error) {
+	m, err := readMeta(fileName)
+	return m.SchemaVersion, err
+}
+
+func writeSchemaVersion(fileName string, version int) error {
+	return updateMeta(fileName, func(m *meta) { m.SchemaVersion = version })
+}
+
+func readLastID(fileName string) (uint, error) {
+	m, err := readMeta(fileName)
+	return m.LastID, err
+}
+
+func writeLastID(fileName string, id uint) error {
+	return updateMeta(fileName, func(m *meta) { m.LastID = id })
+}
+
+func (fs *FileStore) SchemaVersion() (int, error) {
+	return readSchemaVersion(fs.FileName)
+}
+
+func (fs *FileStore) SetSchemaVersion(version int) error {
+	unlock, err := lockPath(fs.FileName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return writeSchemaVersion(fs.FileName, version)
+}
+
+func (ls *LogStore) SchemaVersion() (int, error) {
+	return readSchemaVersion(ls.FileName)
+}
+
+func (ls *LogStore) SetSchemaVersion(version int) error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return writeSchemaVersion(ls.FileName, version)
+}
+
+func (ms *MemoryStore) SchemaVersion() (int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if ms.FileName == "" {
+		return ms.version, nil
+	}
+	return readSchemaVersion(ms.FileName)
+}
+
+func (ms *MemoryStore) SetSchemaVersion(version int) error {
+	// The data the version refers to must reach the disk before the version.
+	if err := ms.Flush(); err != nil {
+		return err
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.version = version
+	if ms.FileName == "" {
+		return nil
+	}
+	return writeSchemaVersion(ms.FileName, version)
+}
+
+// The schema version is not secret, it is kept by the wrapped store.
+func (es *EncryptedStore) SchemaVersion() (int, error) {
+	versioned, ok := es.Store.(Versioned)
+	if !ok {
+		return 0, ErrNotVersioned
+	}
+	return versioned.SchemaVersion()
+}
+
+func (es *EncryptedStore) SetSchemaVersion(version int) error {
+	versioned, ok := es.Store.(Versioned)
+	if !ok {
+		return ErrNotVersioned
+	}
+	return versioned.SetSchemaVersion(version)
+}
+
+func (fs *FileStore) lastID() (uint, error) {
+	return readLastID(fs.FileName)
+}
+
+func (fs *FileStore) setLastID(id uint) error {
+	return writeLastID(fs.FileName, id)
+}
+
+func (ls *LogStore) lastID() (uint, error) {
+	return readLastID(ls.FileName)
+}
+
+func (ls *LogStore) setLastID(id uint) error {
+	return writeLastID(ls.FileName, id)
+}
+
+func (ms *MemoryStore) lastID() (uint, error) {
+	if ms.FileName == "" {
+		return uint(ms.last.Load()), nil
+	}
+	return readLastID(ms.FileName)
+}
+
+func (ms *MemoryStore) setLastID(id uint) error {
+	if ms.FileName == "" {
+		ms.last.Store(uint64(id))
+		return nil
+	}
+	return writeLastID(ms.FileName, id)
+}
+
+func (es *EncryptedStore) lastID() (uint, error) {
+	if s, ok := es.Store.(sequenced); ok {
+		return s.lastID()
+	}
+	return 0, nil
+}
+
+func (es *EncryptedStore) setLastID(id uint) error {
+	if s, ok := es.Store.(sequenced); ok {
+		return s.setLastID(id)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+// Package migrate brings the records kept in a store.Store up to the
+// current schema version.
+package migrate
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/Duarte64/go-web-meli/pkg/store"
+)
+
+// Record is one item of the store as decoded from JSON.
+type Record = map[string]interface{}
+
+// Migration changes every record to the shape of schema Version. Up must be
+// idempotent: a migration may be applied again to records it already
+// changed if the process stops before the new version is saved.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(r Record) (changed bool, err error)
+}
+
+// Result tells how many records a migration changed.
+type Result struct {
+	Version int    `json:"version"`
+	Name    string `json:"name"`
+	Changed int    `json:"changed"`
+}
+
+type Runner struct {
+	Store      store.Store
+	Migrations []Migration
+	// NewData, when set, returns a pointer to the type the store is read
+	// into instead of a list of records, for encodings like gob that can
+	// only be decoded into the type they were written with. Fields that type
+	// does not have are not seen by the migrations.
+	NewData func() interface{}
+}
+
+func NewRunner(db store.Store, migrations []Migration) *Runner {
+	sorted := append([]Migration{}, migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	return &Runner{Store: db, Migrations: sorted}
+}
+
+// Status returns the schema version of the store and the migrations that
+// are still to be applied.
+func (r *Runner) Status() (int, []Migration, error) {
+	versioned, ok := r.Store.(store.Versioned)
+	if !ok {
+		return 0, nil, store.ErrNotVersioned
+	}
+	current, err := versioned.SchemaVersion()
+	if err != nil {
+		return 0, nil, err
+	}
+	var pending []Migration
+	for _, m := range r.Migrations {
+		if m.Version > current {
+			pending = append(pending, m)
+		}
+	}
+	return current, pending, nil
+}
+
+// Run applies the pending migrations in order. With dryRun the records are
+// migrated in memory only, to report what would change.
+func (r *Runner) Run(dryRun bool) ([]Result, error) {
+	_, pending, err := r.Status()
+	if err != nil {
+		return nil, err
+	}
+	if len(pending) == 0 {
+		return []Result{}, nil
+	}
+
+	var results []Result
+	data := r.newData()
+	migrateData := func() error {
+		records, err := toRecords(data)
+		if err != nil {
+			return err
+		}
+		results, err = migrateAll(records, pending)
+		if err != nil {
+			return err
+		}
+		return fromRecords(records, data)
+	}
+	if dryRun {
+		if err := r.Store.Read(data); err != nil {
+			return nil, err
+		}
+		return results, migrateData()
+	}
+	if err := r.Store.Update(data, migrateData); err != nil {
+		return nil, err
+	}
+	last := pending[len(pending)-1].Version
+	if err := r.Store.(store.Versioned).SetSchemaVersion(last); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (r *Runner) newData() interface{} {
+	if r.NewData != nil {
+		return r.NewData()
+	}
+	return &[]Record{}
+}
+
+func toRecords(data interface{}) ([]Record, error) {
+	if records, ok := data.(*[]Record); ok {
+		return *records, nil
+	}
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var records []Record
+	return records, json.Unmarshal(jsonData, &records)
+}
+
+// fromRecords copies the migrated records back into data, the records are
+// changed in place when data already is a list of records.
+func fromRecords(records []Record, data interface{}) error {
+	if _, ok := data.(*[]Record); ok {
+		return nil
+	}
+	jsonData, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(jsonData, data)
+}
+
+func migrateAll(records []Record, pending []Migration) ([]Result, error) {
+	results := make([]Result, 0, len(pending))
+	for _, m := range pending {
+		result := Result{Version: m.Version, Name: m.Name}
+		for _, record := range records {
+			changed, err := m.Up(record)
+			if err != nil {
+				return nil, fmt.Errorf("migration %d %s, record %s: %w", m.Version, m.Name, recordID(record), err)
+			}
+			if changed {
+				result.Changed++
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func recordID(r Record) string {
+	id, _ := json.Marshal(r["id"])
+	return string(id)
+}
@@ -0,0 +1,110 @@
+package migrate
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Duarte64/go-web-meli/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var migrations = []Migration{
+	{
+		Version: 2,
+		Name:    "add_active",
+		Up: func(r Record) (bool, error) {
+			if _, ok := r["active"]; ok {
+				return false, nil
+			}
+			r["active"] = true
+			return true, nil
+		},
+	},
+	{
+		Version: 1,
+		Name:    "rename_nome",
+		Up: func(r Record) (bool, error) {
+			nome, ok := r["nome"]
+			if !ok {
+				return false, nil
+			}
+			r["name"] = nome
+			delete(r, "nome")
+			return true, nil
+		},
+	},
+}
+
+func newRunnerTest(t *testing.T) (*Runner, *store.MemoryStore) {
+	db := store.NewMemory("", 0)
+	require.NoError(t, db.Write([]Record{{"id": 1, "nome": "a"}, {"id": 2, "name": "b", "active": false}}))
+	return NewRunner(db, migrations), db
+}
+
+func TestStatus(t *testing.T) {
+	r, db := newRunnerTest(t)
+
+	current, pending, err := r.Status()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, current)
+	require.Len(t, pending, 2)
+	assert.Equal(t, 1, pending[0].Version)
+
+	require.NoError(t, db.SetSchemaVersion(1))
+	_, pending, err = r.Status()
+	assert.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, "add_active", pending[0].Name)
+}
+
+func TestRun(t *testing.T) {
+	r, db := newRunnerTest(t)
+
+	results, err := r.Run(false)
+	assert.NoError(t, err)
+	assert.Equal(t, []Result{{1, "rename_nome", 1}, {2, "add_active", 1}}, results)
+
+	var records []Record
+	require.NoError(t, db.Read(&records))
+	assert.Equal(t, []Record{
+		{"id": float64(1), "name": "a", "active": true},
+		{"id": float64(2), "name": "b", "active": false},
+	}, records)
+
+	version, err := db.SchemaVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, version)
+
+	results, err = r.Run(false)
+	assert.NoError(t, err)
+	assert.Empty(t, results)
+}
+
+func TestRunDryRun(t *testing.T) {
+	r, db := newRunnerTest(t)
+
+	results, err := r.Run(true)
+	assert.NoError(t, err)
+	assert.Equal(t, []Result{{1, "rename_nome", 1}, {2, "add_active", 1}}, results)
+
+	var records []Record
+	require.NoError(t, db.Read(&records))
+	assert.Equal(t, "a", records[0]["nome"])
+	version, err := db.SchemaVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, version)
+}
+
+func TestRunStopsOnError(t *testing.T) {
+	db := store.NewMemory("", 0)
+	require.NoError(t, db.Write([]Record{{"id": 1}}))
+	r := NewRunner(db, []Migration{{1, "broken", func(Record) (bool, error) { return false, errors.New("boom") }}})
+
+	_, err := r.Run(false)
+	assert.EqualError(t, err, "migration 1 broken, record 1: boom")
+
+	version, err := db.SchemaVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, version)
+}
@@ -0,0 +1,127 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	_ "modernc.org/sqlite"
+)
+
+const documentKey = "default"
+
+const createDocumentsTable = `CREATE TABLE IF NOT EXISTS documents (
+	name TEXT PRIMARY KEY,
+	data BLOB NOT NULL
+)`
+
+// SQLiteStore keeps its data in an embedded SQLite database file. Domain
+// repositories can use DB to work with their own tables; Read and Write
+// keep the whole document in the documents table so it can also be used
+// anywhere a Store is expected.
+type SQLiteStore struct {
+	FileName string
+
+	once sync.Once
+	db   *sql.DB
+	err  error
+}
+
+func NewSQLite(fileName string) *SQLiteStore {
+	return &SQLiteStore{FileName: fileName}
+}
+
+// DB opens the database on first use and returns the shared connection pool.
+func (s *SQLiteStore) DB() (*sql.DB, error) {
+	s.once.Do(func() {
+		db, err := sql.Open("sqlite", s.FileName+"?_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)")
+		if err != nil {
+			s.err = err
+			return
+		}
+		// SQLite only allows one writer at a time, a single connection avoids SQLITE_BUSY.
+		db.SetMaxOpenConns(1)
+		if _, err := db.Exec(createDocumentsTable); err != nil {
+			db.Close()
+			s.err = err
+			return
+		}
+		s.db = db
+	})
+	return s.db, s.err
+}
+
+func (s *SQLiteStore) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+// querier is the subset of *sql.DB and *sql.Tx used by the document methods.
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func (s *SQLiteStore) Write(data interface{}) error {
+	db, err := s.DB()
+	if err != nil {
+		return err
+	}
+	return writeDocument(db, data)
+}
+
+func (s *SQLiteStore) Read(data interface{}) error {
+	db, err := s.DB()
+	if err != nil {
+		return err
+	}
+	return readDocument(db, data)
+}
+
+func (s *SQLiteStore) Update(data interface{}, fn func() error) error {
+	db, err := s.DB()
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := readDocument(tx, data); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	if err := writeDocument(tx, data); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func writeDocument(q querier, data interface{}) error {
+	fileData, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(`INSERT INTO documents (name, data) VALUES (?, ?)
+		ON CONFLICT(name) DO UPDATE SET data = excluded.data`, documentKey, fileData)
+	return err
+}
+
+func readDocument(q querier, data interface{}) error {
+	var fileData []byte
+	err := q.QueryRow(`SELECT data FROM documents WHERE name = ?`, documentKey).Scan(&fileData)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(fileData, data)
+}
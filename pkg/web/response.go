@@ -5,12 +5,37 @@ import "strconv"
 type Response struct {
 	Code  string      `json:"code"`
 	Data  interface{} `json:"data,omitempty"`
+	Meta  *Meta       `json:"meta,omitempty"`
 	Error string      `json:"error,omitempty"`
+	// Details describes the error further, e.g. the fields that failed
+	// validation.
+	Details interface{} `json:"details,omitempty"`
+}
+
+// Meta describes a paginated response, Next and Prev are links to the
+// neighbouring pages.
+type Meta struct {
+	Total  int    `json:"total"`
+	Offset int    `json:"offset"`
+	Next   string `json:"next,omitempty"`
+	Prev   string `json:"prev,omitempty"`
 }
 
 func NewResponse(code int, data interface{}, err string) Response {
 	if code < 300 {
-		return Response{strconv.FormatInt(int64(code), 10), data, ""}
+		return Response{Code: strconv.FormatInt(int64(code), 10), Data: data}
 	}
-	return Response{strconv.FormatInt(int64(code), 10), nil, err}
+	return Response{Code: strconv.FormatInt(int64(code), 10), Error: err}
+}
+
+func NewErrorResponse(code int, err string, details interface{}) Response {
+	r := NewResponse(code, nil, err)
+	r.Details = details
+	return r
+}
+
+func NewPageResponse(code int, data interface{}, meta Meta) Response {
+	r := NewResponse(code, data, "")
+	r.Meta = &meta
+	return r
 }